@@ -0,0 +1,339 @@
+package GameSpy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+	"github.com/go-redis/redis"
+)
+
+// RejectReason tells why a connection was not admitted
+type RejectReason string
+
+// Reasons for rejecting a connection
+const (
+	RejectDenied      RejectReason = "denied"
+	RejectNotAllowed  RejectReason = "not allowed"
+	RejectTooMany     RejectReason = "too many connections"
+	RejectRateLimited RejectReason = "rate limited"
+)
+
+// RejectError is returned by Admit for connections which are turned away
+type RejectError struct {
+	IP     net.IP
+	Reason RejectReason
+}
+
+func (err *RejectError) Error() string {
+	return fmt.Sprintf("connection of %v rejected: %s", err.IP, err.Reason)
+}
+
+// AdmissionConfig holds the limits of an Admission. Zero values disable
+// the respective limit.
+type AdmissionConfig struct {
+	// MaxPerIP is the maximum number of concurrent connections per IP
+	MaxPerIP int
+	// Rate is the number of connections accepted per second over all
+	// IPs, Burst the number accepted at once
+	Rate  float64
+	Burst int
+	// IPRate and IPBurst are the same per IP
+	IPRate  float64
+	IPBurst int
+}
+
+// ListSource loads the allow and deny lists of an Admission
+type ListSource interface {
+	Load() (allow []*net.IPNet, deny []*net.IPNet, err error)
+}
+
+// AdmissionStats are the counters of an Admission
+type AdmissionStats struct {
+	Admitted uint64
+	Active   int
+	Rejected map[RejectReason]uint64
+}
+
+// Admission decides which connections a server accepts. A single
+// Admission can be shared by all sockets, so the limits apply to the
+// whole process.
+type Admission struct {
+	Config AdmissionConfig
+	// Source provides the allow and deny lists. With an empty allow list
+	// everybody not denied is allowed.
+	Source ListSource
+
+	mutex      sync.Mutex
+	allow      []*net.IPNet
+	deny       []*net.IPNet
+	bucket     *tokenBucket
+	peers      map[string]*admissionPeer
+	lastSweep  time.Time
+	admitted   uint64
+	rejected   map[RejectReason]uint64
+	loadedOnce sync.Once
+}
+
+// admissionPeer is the state kept per IP
+type admissionPeer struct {
+	conns  int
+	bucket *tokenBucket
+}
+
+// Reload loads the lists from Source again. The old lists stay in place
+// if loading fails.
+func (admission *Admission) Reload() error {
+	if admission.Source == nil {
+		return nil
+	}
+
+	allow, deny, err := admission.Source.Load()
+	if err != nil {
+		log.Errorf("Admission: Loading the lists failed, keeping the old ones. %v", err)
+		return err
+	}
+
+	admission.mutex.Lock()
+	admission.allow, admission.deny = allow, deny
+	admission.mutex.Unlock()
+
+	log.Notef("Admission: Loaded %d allowed and %d denied networks", len(allow), len(deny))
+	return nil
+}
+
+// Watch reloads the lists every interval until ctx is done
+func (admission *Admission) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			admission.Reload()
+		}
+	}
+}
+
+// Admit decides whether a new connection of addr is accepted. Every
+// admitted connection has to be handed to Release once it's gone.
+func (admission *Admission) Admit(addr net.Addr) error {
+	admission.loadedOnce.Do(func() {
+		admission.Reload()
+	})
+
+	ip := AddrIP(addr)
+	now := time.Now()
+
+	admission.mutex.Lock()
+	defer admission.mutex.Unlock()
+
+	if admission.peers == nil {
+		admission.peers = make(map[string]*admissionPeer)
+		admission.rejected = make(map[RejectReason]uint64)
+	}
+	admission.sweep(now)
+
+	reason := admission.check(ip, now)
+	if reason != "" {
+		admission.rejected[reason]++
+		log.Notef("Admission: Rejected connection of %v, %s", ip, reason)
+		return &RejectError{IP: ip, Reason: reason}
+	}
+
+	admission.admitted++
+	return nil
+}
+
+// Release hands back the slot of a connection admitted before
+func (admission *Admission) Release(addr net.Addr) {
+	ip := AddrIP(addr)
+
+	admission.mutex.Lock()
+	defer admission.mutex.Unlock()
+
+	if peer, ok := admission.peers[ip.String()]; ok && peer.conns > 0 {
+		peer.conns--
+	}
+}
+
+// Stats returns the counters of the admission
+func (admission *Admission) Stats() AdmissionStats {
+	admission.mutex.Lock()
+	defer admission.mutex.Unlock()
+
+	stats := AdmissionStats{
+		Admitted: admission.admitted,
+		Rejected: make(map[RejectReason]uint64),
+	}
+	for _, peer := range admission.peers {
+		stats.Active += peer.conns
+	}
+	for reason, count := range admission.rejected {
+		stats.Rejected[reason] = count
+	}
+	return stats
+}
+
+// check returns why ip is rejected, or "" if it's admitted. Only
+// admitted connections take tokens and a connection slot, and only their
+// IPs are remembered, so rejected floods leave no state behind.
+func (admission *Admission) check(ip net.IP, now time.Time) RejectReason {
+	if ip == nil {
+		return RejectNotAllowed
+	}
+	if containsIP(admission.deny, ip) {
+		return RejectDenied
+	}
+	if len(admission.allow) > 0 && !containsIP(admission.allow, ip) {
+		return RejectNotAllowed
+	}
+
+	config := admission.Config
+	peer, ok := admission.peers[ip.String()]
+	if ok && config.MaxPerIP > 0 && peer.conns >= config.MaxPerIP {
+		return RejectTooMany
+	}
+	if ok && peer.bucket != nil && !peer.bucket.ready(now) {
+		return RejectRateLimited
+	}
+	if config.Rate > 0 {
+		if admission.bucket == nil {
+			admission.bucket = newTokenBucket(config.Rate, config.Burst, now)
+		}
+		if !admission.bucket.allow(now) {
+			return RejectRateLimited
+		}
+	}
+
+	if !ok {
+		peer = new(admissionPeer)
+		if config.IPRate > 0 {
+			peer.bucket = newTokenBucket(config.IPRate, config.IPBurst, now)
+		}
+		admission.peers[ip.String()] = peer
+	}
+	if peer.bucket != nil {
+		peer.bucket.allow(now)
+	}
+	peer.conns++
+	return ""
+}
+
+// sweep forgets IPs without connections and with a full bucket once a
+// minute
+func (admission *Admission) sweep(now time.Time) {
+	if now.Sub(admission.lastSweep) < time.Minute {
+		return
+	}
+	admission.lastSweep = now
+
+	for key, peer := range admission.peers {
+		if peer.conns == 0 && (peer.bucket == nil || peer.bucket.full(now)) {
+			delete(admission.peers, key)
+		}
+	}
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileListSource reads the lists from a file. Every line holds a network
+// or address, prefixed by "allow" or "deny". Lines without a prefix are
+// denied, so a plain list of addresses makes a ban list.
+//
+//	# Office
+//	allow 192.0.2.0/24
+//	deny 198.51.100.7
+//	203.0.113.0/24
+type FileListSource struct {
+	Path string
+}
+
+// Load reads the file
+func (source FileListSource) Load() ([]*net.IPNet, []*net.IPNet, error) {
+	file, err := os.Open(source.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var allow, deny []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch {
+		case len(fields) == 1:
+			deny = append(deny, fields[0])
+		case len(fields) == 2 && fields[0] == "allow":
+			allow = append(allow, fields[1])
+		case len(fields) == 2 && fields[0] == "deny":
+			deny = append(deny, fields[1])
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: invalid line %q", source.Path, line, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return parseLists(allow, deny)
+}
+
+// RedisListSource reads the lists from two Redis sets of networks
+type RedisListSource struct {
+	Redis    *redis.Client
+	AllowKey string
+	DenyKey  string
+}
+
+// Load reads the sets
+func (source RedisListSource) Load() ([]*net.IPNet, []*net.IPNet, error) {
+	var allow, deny []string
+	var err error
+
+	if source.AllowKey != "" {
+		allow, err = source.Redis.SMembers(source.AllowKey).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if source.DenyKey != "" {
+		deny, err = source.Redis.SMembers(source.DenyKey).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return parseLists(allow, deny)
+}
+
+func parseLists(allow []string, deny []string) ([]*net.IPNet, []*net.IPNet, error) {
+	allowed, err := ParseCIDRs(allow)
+	if err != nil {
+		return nil, nil, fmt.Errorf("allow list: %w", err)
+	}
+	denied, err := ParseCIDRs(deny)
+	if err != nil {
+		return nil, nil, fmt.Errorf("deny list: %w", err)
+	}
+	return allowed, denied, nil
+}
@@ -0,0 +1,181 @@
+package GameSpy_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func TestAdmissionMaxPerIP(t *testing.T) {
+	admission := &GameSpy.Admission{
+		Config: GameSpy.AdmissionConfig{MaxPerIP: 1},
+	}
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{Name: "test", Admission: admission}
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	first, _ := net.Dial("tcp", listener.Addr().String())
+	defer first.Close()
+	nextSocketEvent(t, events, "newClient")
+
+	// The second connection is closed right away
+	second, _ := net.Dial("tcp", listener.Addr().String())
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Second connection was not rejected, got: %v.", err)
+	}
+
+	// Once the first one is gone there is room again
+	first.Close()
+	nextSocketEvent(t, events, "client.close")
+	third, _ := net.Dial("tcp", listener.Addr().String())
+	defer third.Close()
+	nextSocketEvent(t, events, "newClient")
+
+	stats := admission.Stats()
+	if stats.Admitted != 2 || stats.Active != 1 || stats.Rejected[GameSpy.RejectTooMany] != 1 {
+		t.Errorf("Stats were incorrect, got: %+v.", stats)
+	}
+}
+
+func TestAdmissionQuickDisconnect(t *testing.T) {
+	admission := &GameSpy.Admission{
+		Config: GameSpy.AdmissionConfig{MaxPerIP: 1},
+	}
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{Name: "test", Admission: admission}
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	// Clients leaving before they are set up give their slot back
+	for i := 0; i < 20; i++ {
+		conn, _ := net.Dial("tcp", listener.Addr().String())
+		conn.Close()
+		nextSocketEvent(t, events, "client.close")
+
+		deadline := time.Now().Add(time.Second)
+		for admission.Stats().Active != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Slot of client %d was not released, got: %+v.", i, admission.Stats())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestAdmissionPacketShutdown(t *testing.T) {
+	admission := &GameSpy.Admission{
+		Config: GameSpy.AdmissionConfig{MaxPerIP: 1},
+	}
+
+	packetConn, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	server := &GameSpy.Server{Name: "test", Framer: GameSpy.GameSpyFramer{}, Admission: admission}
+	events, _ := server.ListenPacket(context.Background(), packetConn)
+
+	conn, _ := net.Dial("udp", packetConn.LocalAddr().String())
+	defer conn.Close()
+	conn.Write([]byte("\\heartbeat\\1\\final\\"))
+	nextSocketEvent(t, events, "command.heartbeat")
+	go func() {
+		for range events {
+		}
+	}()
+
+	// The sessions left give their slots back to the shared admission
+	server.Shutdown(context.Background())
+	if stats := admission.Stats(); stats.Active != 0 {
+		t.Errorf("Active after the shutdown was incorrect, got: %d, want: %d.", stats.Active, 0)
+	}
+}
+
+func TestAdmissionRate(t *testing.T) {
+	admission := &GameSpy.Admission{
+		Config: GameSpy.AdmissionConfig{IPRate: 1, IPBurst: 2},
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+
+	for i := 0; i < 2; i++ {
+		if err := admission.Admit(addr); err != nil {
+			t.Fatalf("Admit within the burst threw an error: %v", err)
+		}
+	}
+
+	var rejected *GameSpy.RejectError
+	err := admission.Admit(addr)
+	if !errors.As(err, &rejected) || rejected.Reason != GameSpy.RejectRateLimited {
+		t.Errorf("Admit was incorrect, got: %v, want: %s.", err, GameSpy.RejectRateLimited)
+	}
+
+	// Other IPs have their own bucket
+	if err := admission.Admit(&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}); err != nil {
+		t.Errorf("Admit of another IP threw an error: %v", err)
+	}
+}
+
+func TestAdmissionGlobalRate(t *testing.T) {
+	admission := &GameSpy.Admission{
+		Config: GameSpy.AdmissionConfig{Rate: 20, Burst: 1, IPRate: 0.001, IPBurst: 1},
+	}
+	first := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	second := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}
+
+	if err := admission.Admit(first); err != nil {
+		t.Fatalf("Admit threw an error: %v", err)
+	}
+	var rejected *GameSpy.RejectError
+	if err := admission.Admit(second); !errors.As(err, &rejected) || rejected.Reason != GameSpy.RejectRateLimited {
+		t.Errorf("Admit beyond the global rate was incorrect, got: %v.", err)
+	}
+
+	// The rejection took none of the tokens of the second IP
+	time.Sleep(100 * time.Millisecond)
+	if err := admission.Admit(second); err != nil {
+		t.Errorf("Admit once the global bucket refilled threw an error: %v", err)
+	}
+}
+
+func TestAdmissionListReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banlist")
+	os.WriteFile(path, []byte("# Bans\n192.0.2.0/24\n"), 0644)
+
+	admission := &GameSpy.Admission{Source: GameSpy.FileListSource{Path: path}}
+	banned := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	other := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1}
+
+	if err := admission.Admit(banned); err == nil {
+		t.Errorf("Banned address was admitted.")
+	}
+	if err := admission.Admit(other); err != nil {
+		t.Errorf("Admit threw an error: %v", err)
+	}
+
+	os.WriteFile(path, []byte("allow 192.0.2.0/24\n"), 0644)
+	if err := admission.Reload(); err != nil {
+		t.Fatalf("Reload threw an error: %v", err)
+	}
+	if err := admission.Admit(banned); err != nil {
+		t.Errorf("Admit after the reload threw an error: %v", err)
+	}
+	if err := admission.Admit(other); err == nil {
+		t.Errorf("Address outside the allow list was admitted.")
+	}
+
+	// Broken lists keep the old ones in place
+	os.WriteFile(path, []byte("maybe 192.0.2.0/24\n"), 0644)
+	if err := admission.Reload(); err == nil {
+		t.Errorf("Reloading a broken list did not fail.")
+	}
+	if err := admission.Admit(banned); err != nil {
+		t.Errorf("Admit after the broken reload threw an error: %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package GameSpy
+
+import (
+	"errors"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// OverflowPolicy decides what happens to an event when the buffer of a
+// subscriber is full
+type OverflowPolicy int
+
+const (
+	// OverflowDefault is the policy of whoever applies it, subscribers
+	// block and client writers disconnect
+	OverflowDefault OverflowPolicy = iota
+	// OverflowBlock waits until the subscriber has room again. This
+	// stalls whoever publishes the event, e.g. the client goroutine.
+	OverflowBlock
+	// OverflowDropOldest throws away the oldest buffered event. It needs
+	// a Buffer of at least 1.
+	OverflowDropOldest
+	// OverflowDisconnect drops the event and disconnects the client it
+	// belongs to. Events without a client are just dropped.
+	OverflowDisconnect
+)
+
+// NamedEvent is an event which can be published on an EventBus
+type NamedEvent interface {
+	Event
+	EventName() string
+}
+
+// EventName returns the full name of the event, e.g. "client.command.login"
+func (event SocketEvent) EventName() string {
+	return event.Name
+}
+
+// EventName returns the full name of the event, e.g. "command.heartbeat"
+func (event SocketUDPEvent) EventName() string {
+	return event.Name
+}
+
+// SubscribeOptions configures a single subscription
+type SubscribeOptions struct {
+	// Buffer is the number of events buffered for the subscriber
+	Buffer int
+	// Policy is applied whenever the buffer is full. The zero value
+	// blocks.
+	Policy OverflowPolicy
+}
+
+// ErrNoBuffer is returned subscribing with OverflowDropOldest without a
+// buffer, there would never be an event to drop
+var ErrNoBuffer = errors.New("dropping the oldest event needs a buffer")
+
+// EventBus fans out events to any number of subscribers
+type EventBus[T NamedEvent] struct {
+	mutex       sync.RWMutex
+	subscribers []*Subscription[T]
+	published   uint64
+}
+
+// EventBusStats are the counters of an EventBus
+type EventBusStats struct {
+	Subscribers  int
+	Published    uint64
+	Dropped      uint64
+	Disconnected uint64
+}
+
+// Subscription receives all events matching its pattern on C
+type Subscription[T NamedEvent] struct {
+	C       chan T
+	Pattern string
+	Policy  OverflowPolicy
+
+	bus  *EventBus[T]
+	done chan struct{}
+	// sending is held by deliveries, Close waits for them before it
+	// closes C
+	sending      sync.RWMutex
+	closeOnce    sync.Once
+	dropped      uint64
+	disconnected uint64
+}
+
+// Subscribe adds a subscriber for all events with a name matching
+// pattern. Patterns use path.Match syntax, so "client.command.acct.*"
+// matches every acct transaction and "*" matches every event.
+func (bus *EventBus[T]) Subscribe(pattern string, options SubscribeOptions) (*Subscription[T], error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if options.Policy == OverflowDropOldest && options.Buffer < 1 {
+		return nil, ErrNoBuffer
+	}
+
+	subscription := &Subscription[T]{
+		C:       make(chan T, options.Buffer),
+		Pattern: pattern,
+		Policy:  options.Policy,
+		bus:     bus,
+		done:    make(chan struct{}),
+	}
+
+	bus.mutex.Lock()
+	bus.subscribers = append(bus.subscribers, subscription)
+	bus.mutex.Unlock()
+
+	return subscription, nil
+}
+
+// Publish hands event to every matching subscriber. The bus isn't locked
+// meanwhile, so a blocking subscriber doesn't hold up Subscribe and Close.
+func (bus *EventBus[T]) Publish(event T) {
+	atomic.AddUint64(&bus.published, 1)
+
+	name := event.EventName()
+	var matching []*Subscription[T]
+	bus.mutex.RLock()
+	for _, subscription := range bus.subscribers {
+		if matched, _ := path.Match(subscription.Pattern, name); matched {
+			matching = append(matching, subscription)
+		}
+	}
+	bus.mutex.RUnlock()
+
+	for _, subscription := range matching {
+		subscription.deliver(event)
+	}
+}
+
+// Stats returns the counters of the bus summed up over all subscribers
+func (bus *EventBus[T]) Stats() EventBusStats {
+	bus.mutex.RLock()
+	defer bus.mutex.RUnlock()
+
+	stats := EventBusStats{
+		Subscribers: len(bus.subscribers),
+		Published:   atomic.LoadUint64(&bus.published),
+	}
+	for _, subscription := range bus.subscribers {
+		stats.Dropped += subscription.Dropped()
+		stats.Disconnected += subscription.Disconnected()
+	}
+	return stats
+}
+
+// Close removes all subscribers and closes their channels
+func (bus *EventBus[T]) Close() {
+	bus.mutex.RLock()
+	subscribers := make([]*Subscription[T], len(bus.subscribers))
+	copy(subscribers, bus.subscribers)
+	bus.mutex.RUnlock()
+
+	for _, subscription := range subscribers {
+		subscription.Close()
+	}
+}
+
+func (subscription *Subscription[T]) deliver(event T) {
+	subscription.sending.RLock()
+	defer subscription.sending.RUnlock()
+
+	// Closed after Publish picked us
+	select {
+	case <-subscription.done:
+		return
+	default:
+	}
+
+	select {
+	case subscription.C <- event:
+		return
+	case <-subscription.done:
+		return
+	default:
+	}
+
+	switch subscription.Policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-subscription.C:
+				atomic.AddUint64(&subscription.dropped, 1)
+			case <-subscription.done:
+				return
+			default:
+			}
+
+			select {
+			case subscription.C <- event:
+				return
+			default:
+			}
+		}
+	case OverflowDisconnect:
+		atomic.AddUint64(&subscription.dropped, 1)
+		if client, ok := eventClient(event); ok {
+			log.Notef("Subscriber %s is full, disconnecting client of %s", subscription.Pattern, event.EventName())
+			atomic.AddUint64(&subscription.disconnected, 1)
+			client.disconnect()
+		}
+	default:
+		select {
+		case subscription.C <- event:
+		case <-subscription.done:
+		}
+	}
+}
+
+// Dropped returns the number of events this subscriber did not receive
+func (subscription *Subscription[T]) Dropped() uint64 {
+	return atomic.LoadUint64(&subscription.dropped)
+}
+
+// Disconnected returns the number of clients disconnected because this
+// subscriber was full
+func (subscription *Subscription[T]) Disconnected() uint64 {
+	return atomic.LoadUint64(&subscription.disconnected)
+}
+
+// Close unsubscribes and closes C
+func (subscription *Subscription[T]) Close() {
+	subscription.closeOnce.Do(func() {
+		// Unblock publishers waiting on us before taking the lock
+		close(subscription.done)
+
+		bus := subscription.bus
+		bus.mutex.Lock()
+		for i := range bus.subscribers {
+			if bus.subscribers[i] == subscription {
+				bus.subscribers = append(bus.subscribers[:i], bus.subscribers[i+1:]...)
+				break
+			}
+		}
+		bus.mutex.Unlock()
+
+		subscription.sending.Lock()
+		close(subscription.C)
+		subscription.sending.Unlock()
+	})
+}
+
+// eventClient returns the client an event belongs to, if any
+func eventClient(event Event) (*Client, bool) {
+	var payload Event
+	switch event := event.(type) {
+	case SocketEvent:
+		payload = event.Data
+	case SocketUDPEvent:
+		payload = event.Data
+	default:
+		payload = event
+	}
+
+	switch payload := payload.(type) {
+	case EventNewClient:
+		return payload.Client, payload.Client != nil
+	case EventClientClose:
+		return payload.Client, payload.Client != nil
+	case EventClientError:
+		return payload.Client, payload.Client != nil
+	case EventClientCommand:
+		return payload.Client, payload.Client != nil
+	case EventClientFESLCommand:
+		return payload.Client, payload.Client != nil
+	case EventClientData:
+		return payload.Client, payload.Client != nil
+	case EventClientPanic:
+		return payload.Client, payload.Client != nil
+	}
+	return nil, false
+}
@@ -0,0 +1,143 @@
+package GameSpy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func commandEvent(name string) GameSpy.SocketEvent {
+	return GameSpy.SocketEvent{
+		Name: name,
+		Data: GameSpy.EventClientFESLCommand{
+			Command: &GameSpy.CommandFESL{},
+		},
+	}
+}
+
+func TestEventBusPatterns(t *testing.T) {
+	bus := new(GameSpy.EventBus[GameSpy.SocketEvent])
+
+	acct, err := bus.Subscribe("client.command.acct.*", GameSpy.SubscribeOptions{Buffer: 10})
+	if err != nil {
+		t.Fatalf("Subscribe threw an error: %v", err)
+	}
+	all, _ := bus.Subscribe("*", GameSpy.SubscribeOptions{Buffer: 10})
+
+	bus.Publish(commandEvent("client.command.acct.NuLogin"))
+	bus.Publish(commandEvent("client.command.fsys.Hello"))
+
+	if len(acct.C) != 1 {
+		t.Errorf("Subscriber for acct got %d events, want: 1.", len(acct.C))
+	}
+	if event := <-acct.C; event.Name != "client.command.acct.NuLogin" {
+		t.Errorf("Subscriber for acct got the wrong event: %s.", event.Name)
+	}
+	if len(all.C) != 2 {
+		t.Errorf("Subscriber for * got %d events, want: 2.", len(all.C))
+	}
+
+	if _, err := bus.Subscribe("client.[", GameSpy.SubscribeOptions{}); err == nil {
+		t.Errorf("Subscribe accepted an invalid pattern.")
+	}
+}
+
+func TestEventBusDropOldest(t *testing.T) {
+	bus := new(GameSpy.EventBus[GameSpy.SocketEvent])
+	subscription, _ := bus.Subscribe("*", GameSpy.SubscribeOptions{
+		Buffer: 2,
+		Policy: GameSpy.OverflowDropOldest,
+	})
+
+	bus.Publish(commandEvent("client.command.1"))
+	bus.Publish(commandEvent("client.command.2"))
+	bus.Publish(commandEvent("client.command.3"))
+
+	if subscription.Dropped() != 1 {
+		t.Errorf("Dropped was incorrect, got: %d, want: %d.", subscription.Dropped(), 1)
+	}
+	if event := <-subscription.C; event.Name != "client.command.2" {
+		t.Errorf("Oldest event was not dropped, got: %s.", event.Name)
+	}
+
+	stats := bus.Stats()
+	if stats.Published != 3 || stats.Dropped != 1 || stats.Subscribers != 1 {
+		t.Errorf("Stats were incorrect, got: %+v.", stats)
+	}
+
+	subscription.Close()
+	if _, ok := <-subscription.C; !ok {
+		t.Errorf("Close removed buffered events.")
+	}
+	if _, ok := <-subscription.C; ok {
+		t.Errorf("Close did not close the channel.")
+	}
+	if bus.Stats().Subscribers != 0 {
+		t.Errorf("Close did not unsubscribe.")
+	}
+}
+
+func TestEventBusDropOldestUnbuffered(t *testing.T) {
+	bus := new(GameSpy.EventBus[GameSpy.SocketEvent])
+	_, err := bus.Subscribe("*", GameSpy.SubscribeOptions{
+		Buffer: 0,
+		Policy: GameSpy.OverflowDropOldest,
+	})
+	if err != GameSpy.ErrNoBuffer {
+		t.Errorf("Subscribe without a buffer was incorrect, got: %v, want: %v.", err, GameSpy.ErrNoBuffer)
+	}
+
+	// Publishing doesn't spin on a subscriber which can never take it
+	bus.Publish(commandEvent("client.command.1"))
+	if bus.Stats().Subscribers != 0 {
+		t.Errorf("Subscribers were incorrect, got: %d, want: %d.", bus.Stats().Subscribers, 0)
+	}
+}
+
+func TestEventBusDisconnectWithoutClient(t *testing.T) {
+	bus := new(GameSpy.EventBus[GameSpy.SocketEvent])
+	subscription, _ := bus.Subscribe("*", GameSpy.SubscribeOptions{
+		Buffer: 1,
+		Policy: GameSpy.OverflowDisconnect,
+	})
+
+	bus.Publish(commandEvent("client.command.1"))
+	bus.Publish(commandEvent("client.command.2"))
+
+	if subscription.Dropped() != 1 || subscription.Disconnected() != 0 {
+		t.Errorf("Counters were incorrect, got: %d dropped, %d disconnected.", subscription.Dropped(), subscription.Disconnected())
+	}
+}
+
+func TestEventBusBlockingSubscriber(t *testing.T) {
+	bus := new(GameSpy.EventBus[GameSpy.SocketEvent])
+	blocking, _ := bus.Subscribe("*", GameSpy.SubscribeOptions{Policy: GameSpy.OverflowBlock})
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(commandEvent("client.command.1"))
+		close(published)
+	}()
+
+	// The bus stays usable while Publish waits for the subscriber
+	subscribed := make(chan struct{})
+	go func() {
+		subscription, _ := bus.Subscribe("*", GameSpy.SubscribeOptions{Buffer: 1})
+		subscription.Close()
+		bus.Stats()
+		close(subscribed)
+	}()
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatalf("Subscribe waited for a blocking subscriber.")
+	}
+
+	blocking.Close()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatalf("Publish kept waiting for a closed subscriber.")
+	}
+}
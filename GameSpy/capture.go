@@ -0,0 +1,418 @@
+package GameSpy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// A capture file holds the traffic of a single connection or UDP
+// session. It starts with a header followed by one record per frame:
+//
+//	header  "GACP" version(1 byte) protocol server addr(uvarint length
+//	        prefixed strings) client id(uvarint) start(varint unix nanos)
+//	        flags(1 byte, 1 = datagrams)
+//	record  direction(1 byte, 'i' or 'o') offset since start(uvarint
+//	        microseconds) length(uvarint) frame
+//
+// Frames are stored as the framer hands them out, i.e. after Split on
+// the way in and before Encode on the way out. XOR'ed traffic is stored
+// in the clear.
+const captureMagic = "GACP"
+
+// CaptureVersion is the version of the capture format written
+const CaptureVersion = 1
+
+// ErrInvalidCapture is returned for files which are no captures
+var ErrInvalidCapture = errors.New("not a capture file")
+
+// Direction tells who sent a frame
+type Direction byte
+
+// Directions of recorded frames
+const (
+	Inbound  Direction = 'i'
+	Outbound Direction = 'o'
+)
+
+func (direction Direction) String() string {
+	switch direction {
+	case Inbound:
+		return "in"
+	case Outbound:
+		return "out"
+	}
+	return fmt.Sprintf("Direction(%d)", byte(direction))
+}
+
+// CaptureHeader describes the connection a capture belongs to
+type CaptureHeader struct {
+	Version int
+	// Protocol names the framer, see ProtocolName
+	Protocol string
+	Server   string
+	// ClientID identifies the client or session within the server
+	ClientID uint64
+	Addr     string
+	Start    time.Time
+	// Datagram is set for the sessions of servers handling datagrams
+	Datagram bool
+}
+
+// CaptureRecord is a single recorded frame
+type CaptureRecord struct {
+	Time      time.Time
+	Direction Direction
+	Frame     []byte
+}
+
+// Capture is a whole capture file
+type Capture struct {
+	CaptureHeader
+	Records []CaptureRecord
+}
+
+// CaptureWriter writes a capture file record by record. It's safe for
+// concurrent use, reads and writes of a client record from different
+// goroutines.
+type CaptureWriter struct {
+	mutex  sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	start  time.Time
+	err    error
+}
+
+// NewCaptureWriter writes header to w and returns a writer for the
+// records. Close closes w if it's an io.Closer.
+func NewCaptureWriter(w io.Writer, header CaptureHeader) (*CaptureWriter, error) {
+	if header.Start.IsZero() {
+		header.Start = time.Now()
+	}
+
+	capture := &CaptureWriter{
+		w:     bufio.NewWriter(w),
+		start: header.Start,
+	}
+	capture.closer, _ = w.(io.Closer)
+
+	buf := append([]byte(captureMagic), CaptureVersion)
+	buf = appendString(buf, header.Protocol)
+	buf = appendString(buf, header.Server)
+	buf = appendString(buf, header.Addr)
+	buf = binary.AppendUvarint(buf, header.ClientID)
+	buf = binary.AppendVarint(buf, header.Start.UnixNano())
+	if header.Datagram {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+
+	if _, err := capture.w.Write(buf); err != nil {
+		return nil, err
+	}
+	return capture, capture.w.Flush()
+}
+
+// Record appends a frame sent at t. Records are flushed right away, so
+// the file is complete up to the last frame even after a crash.
+func (capture *CaptureWriter) Record(t time.Time, direction Direction, frame []byte) error {
+	if capture == nil {
+		return nil
+	}
+
+	capture.mutex.Lock()
+	defer capture.mutex.Unlock()
+
+	if capture.err != nil {
+		return capture.err
+	}
+
+	offset := t.Sub(capture.start)
+	if offset < 0 {
+		offset = 0
+	}
+
+	buf := make([]byte, 0, len(frame)+16)
+	buf = append(buf, byte(direction))
+	buf = binary.AppendUvarint(buf, uint64(offset/time.Microsecond))
+	buf = binary.AppendUvarint(buf, uint64(len(frame)))
+	buf = append(buf, frame...)
+
+	if _, err := capture.w.Write(buf); err != nil {
+		capture.err = err
+		return err
+	}
+	if err := capture.w.Flush(); err != nil {
+		capture.err = err
+		return err
+	}
+	return nil
+}
+
+// Close stops the writer. Records after Close are dropped.
+func (capture *CaptureWriter) Close() error {
+	if capture == nil {
+		return nil
+	}
+
+	capture.mutex.Lock()
+	defer capture.mutex.Unlock()
+
+	if capture.err == os.ErrClosed {
+		return nil
+	}
+	capture.err = os.ErrClosed
+
+	if capture.closer != nil {
+		return capture.closer.Close()
+	}
+	return nil
+}
+
+// record adds a frame of now, logging failures instead of returning
+// them. Recording must never get in the way of the traffic.
+func (capture *CaptureWriter) record(direction Direction, frame []byte) {
+	err := capture.Record(time.Now(), direction, frame)
+	if err != nil && err != os.ErrClosed {
+		log.Errorln("Recording a frame failed.", err)
+	}
+}
+
+// WriteCapture writes a whole capture to w
+func WriteCapture(w io.Writer, capture *Capture) error {
+	writer, err := NewCaptureWriter(w, capture.CaptureHeader)
+	if err != nil {
+		return err
+	}
+	for _, record := range capture.Records {
+		if err := writer.Record(record.Time, record.Direction, record.Frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadCapture reads a whole capture from r
+func ReadCapture(r io.Reader) (*Capture, error) {
+	reader := bufio.NewReader(r)
+
+	magic := make([]byte, len(captureMagic)+1)
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic[:len(captureMagic)]) != captureMagic {
+		return nil, ErrInvalidCapture
+	}
+
+	capture := new(Capture)
+	capture.Version = int(magic[len(captureMagic)])
+	if capture.Version != CaptureVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrInvalidCapture, capture.Version)
+	}
+
+	var err error
+	if capture.Protocol, err = readString(reader); err != nil {
+		return nil, err
+	}
+	if capture.Server, err = readString(reader); err != nil {
+		return nil, err
+	}
+	if capture.Addr, err = readString(reader); err != nil {
+		return nil, err
+	}
+	if capture.ClientID, err = binary.ReadUvarint(reader); err != nil {
+		return nil, truncated(err)
+	}
+	start, err := binary.ReadVarint(reader)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	capture.Start = time.Unix(0, start)
+	flags, err := reader.ReadByte()
+	if err != nil {
+		return nil, truncated(err)
+	}
+	capture.Datagram = flags&1 != 0
+
+	for {
+		direction, err := reader.ReadByte()
+		if err == io.EOF {
+			return capture, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if Direction(direction) != Inbound && Direction(direction) != Outbound {
+			return nil, fmt.Errorf("%w: unknown direction %q", ErrInvalidCapture, direction)
+		}
+
+		offset, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, truncated(err)
+		}
+		frame, err := readBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		capture.Records = append(capture.Records, CaptureRecord{
+			Time:      capture.Start.Add(time.Duration(offset) * time.Microsecond),
+			Direction: Direction(direction),
+			Frame:     frame,
+		})
+	}
+}
+
+// ReadCaptureFile reads the capture file at path
+func ReadCaptureFile(path string) (*Capture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadCapture(file)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// maxCaptureFrame guards against allocating whatever a broken length
+// claims
+const maxCaptureFrame = 1 << 24
+
+func readBytes(reader *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	if length > maxCaptureFrame {
+		return nil, fmt.Errorf("%w: frame of %d bytes", ErrInvalidCapture, length)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, truncated(err)
+	}
+	return buf, nil
+}
+
+func readString(reader *bufio.Reader) (string, error) {
+	buf, err := readBytes(reader)
+	return string(buf), err
+}
+
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: truncated", ErrInvalidCapture)
+	}
+	return err
+}
+
+// ProtocolName returns the name captures and DefaultLimits use for the
+// protocol of framer, e.g. "fesl"
+func ProtocolName(framer Framer) string {
+	switch framer := framer.(type) {
+	case GameSpyFramer:
+		return "gamespy"
+	case FESLFramer:
+		return "fesl"
+	case LineFramer:
+		return "irc"
+	case QR2Framer:
+		return "qr2"
+	case XORFramer:
+		return ProtocolName(framer.Framer) + "+xor"
+	}
+	return fmt.Sprintf("%T", framer)
+}
+
+// FramerFor returns a framer with default settings for a protocol named
+// by ProtocolName
+func FramerFor(protocol string) (Framer, error) {
+	if inner := strings.TrimSuffix(protocol, "+xor"); inner != protocol {
+		framer, err := FramerFor(inner)
+		if err != nil {
+			return nil, err
+		}
+		return XORFramer{Framer: framer}, nil
+	}
+
+	switch protocol {
+	case "gamespy":
+		return GameSpyFramer{}, nil
+	case "fesl":
+		return FESLFramer{}, nil
+	case "irc":
+		return LineFramer{}, nil
+	case "qr2":
+		return QR2Framer{}, nil
+	}
+	return nil, fmt.Errorf("unknown protocol %q", protocol)
+}
+
+// RecordConfig enables recording the traffic of a server. Every
+// connection and UDP session gets a capture file of its own.
+type RecordConfig struct {
+	// Dir is where capture files are written to. Empty disables
+	// recording.
+	Dir string
+}
+
+// open creates the capture file of a new client or session. Failures
+// are logged, the client is served without recording.
+func (config RecordConfig) open(server string, framer Framer, id uint64, addr net.Addr, datagram bool) *CaptureWriter {
+	if config.Dir == "" {
+		return nil
+	}
+
+	header := CaptureHeader{
+		Protocol: ProtocolName(framer),
+		Server:   server,
+		ClientID: id,
+		Start:    time.Now(),
+		Datagram: datagram,
+	}
+	if addr != nil {
+		header.Addr = addr.String()
+	}
+
+	capture, err := config.create(header)
+	if err != nil {
+		log.Errorln(server+": Creating the capture file failed, not recording.", err)
+		return nil
+	}
+	return capture
+}
+
+func (config RecordConfig) create(header CaptureHeader) (*CaptureWriter, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s-%s-%d.gacap",
+		header.Start.Format("20060102-150405.000000"),
+		strings.NewReplacer(" ", "_", "/", "_", ":", "_").Replace(header.Server),
+		header.ClientID)
+	file, err := os.Create(filepath.Join(config.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	capture, err := NewCaptureWriter(file, header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return capture, nil
+}
@@ -0,0 +1,76 @@
+package GameSpy_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	capture := &GameSpy.Capture{
+		CaptureHeader: GameSpy.CaptureHeader{
+			Version:  GameSpy.CaptureVersion,
+			Protocol: "gamespy+xor",
+			Server:   "QR2",
+			ClientID: 300,
+			Addr:     "192.0.2.1:27900",
+			Start:    start,
+			Datagram: true,
+		},
+		Records: []GameSpy.CaptureRecord{
+			{Time: start.Add(1500 * time.Microsecond), Direction: GameSpy.Inbound, Frame: []byte("\\heartbeat\\\\final\\")},
+			{Time: start.Add(time.Second), Direction: GameSpy.Outbound, Frame: []byte{}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := GameSpy.WriteCapture(&buf, capture); err != nil {
+		t.Fatalf("WriteCapture threw an error: %v", err)
+	}
+
+	read, err := GameSpy.ReadCapture(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadCapture threw an error: %v", err)
+	}
+	if read.CaptureHeader != capture.CaptureHeader {
+		t.Errorf("Header was incorrect, got: %+v, want: %+v.", read.CaptureHeader, capture.CaptureHeader)
+	}
+	if !reflect.DeepEqual(read.Records, capture.Records) {
+		t.Errorf("Records were incorrect, got: %+v, want: %+v.", read.Records, capture.Records)
+	}
+
+	// Cut off mid-record
+	_, err = GameSpy.ReadCapture(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if !errors.Is(err, GameSpy.ErrInvalidCapture) {
+		t.Errorf("Truncated capture threw the wrong error: %v", err)
+	}
+
+	_, err = GameSpy.ReadCapture(bytes.NewReader([]byte("\\lc\\1\\final\\")))
+	if !errors.Is(err, GameSpy.ErrInvalidCapture) {
+		t.Errorf("Reading something else threw the wrong error: %v", err)
+	}
+}
+
+func TestProtocolNames(t *testing.T) {
+	for _, framer := range []GameSpy.Framer{
+		GameSpy.GameSpyFramer{},
+		GameSpy.FESLFramer{},
+		GameSpy.LineFramer{},
+		GameSpy.XORFramer{Framer: GameSpy.GameSpyFramer{}},
+	} {
+		name := GameSpy.ProtocolName(framer)
+		got, err := GameSpy.FramerFor(name)
+		if err != nil || !reflect.DeepEqual(got, framer) {
+			t.Errorf("FramerFor(%q) was incorrect, got: %#v, %v, want: %#v.", name, got, err, framer)
+		}
+	}
+
+	if _, err := GameSpy.FramerFor("smtp"); err == nil {
+		t.Errorf("FramerFor accepted an unknown protocol.")
+	}
+}
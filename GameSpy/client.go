@@ -1,30 +1,42 @@
 package GameSpy
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/binary"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"io"
 	"net"
-	"strings"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/HeroesAwaken/GoAwaken/Log"
+	"github.com/HeroesAwaken/GoAwaken/core"
 )
 
 type Client struct {
-	name       string
-	conn       *net.Conn
-	recvBuffer []byte
-	eventChan  chan ClientEvent
-	IsActive   bool
-	reader     *bufio.Reader
-	IpAddr     net.Addr
-	State      ClientState
-	FESL       bool
+	name         string
+	conn         net.Conn
+	writer       *writer
+	writerConfig WriterConfig
+	framer       Framer
+	rateLimit    RateLimitConfig
+	limiter      *messageLimiter
+	crashConfig  CrashConfig
+	history      *frameHistory
+	capture      *CaptureWriter
+	upstream     *upstreamConn
+	closed       chan struct{}
+	closeOnce    sync.Once
+	releaseOnce  sync.Once
+	active       atomic.Bool
+	recvBuffer   []byte
+	eventChan    chan ClientEvent
+	ID           uint64
+	IpAddr       net.Addr
+	RedisState   *core.RedisState
+	State        ClientState
+	FESL         bool
 }
 
 type ClientState struct {
@@ -48,6 +60,21 @@ type ClientState struct {
 	HeartTicker     *time.Ticker
 }
 
+type CommandFESL struct {
+	Message   map[string]string
+	Query     string
+	PayloadID uint32
+}
+
+// eventName returns the name events of this command are fired with, the
+// FESL type followed by the transaction if there is one, e.g. "acct.NuLogin"
+func (command *CommandFESL) eventName() string {
+	if txn, ok := command.Message["TXN"]; ok && txn != "" {
+		return command.Query + "." + txn
+	}
+	return command.Query
+}
+
 // ClientEvent is the generic struct for events
 // by this Client
 type ClientEvent struct {
@@ -55,30 +82,52 @@ type ClientEvent struct {
 	Data interface{}
 }
 
-// New creates a new Client and starts up the handling of the connection
-func (client *Client) New(name string, conn *net.Conn) (chan ClientEvent, error) {
+// New creates a new Client and starts up the handling of the connection.
+// conn can be any connection, e.g. TCP, TLS after the handshake or one
+// end of a net.Pipe. The traffic is split by a FESLFramer if FESL is set
+// and by a GameSpyFramer otherwise.
+func (client *Client) New(name string, conn net.Conn) (chan ClientEvent, error) {
+	if client.framer == nil {
+		if client.FESL {
+			client.framer = FESLFramer{}
+		} else {
+			client.framer = GameSpyFramer{}
+		}
+	}
+
 	client.name = name
 	client.conn = conn
-	client.IpAddr = (*client.conn).RemoteAddr()
+	// Behind a proxy this already is the address of the real client
+	client.IpAddr = client.conn.RemoteAddr()
+	client.State.IpAddress = client.IpAddr
 	client.eventChan = make(chan ClientEvent, 20)
-	client.reader = bufio.NewReader(*client.conn)
-	client.IsActive = true
+	client.writer = newWriter(name, client.conn, client.writerConfig)
+	client.limiter = newMessageLimiter(client.rateLimit, time.Now())
+	client.history = newFrameHistory(client.crashConfig.Frames)
+	client.closed = make(chan struct{})
+	client.active.Store(true)
 
 	go client.handleRequest()
 
 	return client.eventChan, nil
 }
 
+// Active reports whether the client is still connected. It's safe to call
+// from any goroutine.
+func (client *Client) Active() bool {
+	return client.active.Load()
+}
+
 func (client *Client) Write(command string) error {
-	if !client.IsActive {
+	if !client.active.Load() {
 		log.Notef("%s: Trying to write to inactive client.\n%v", client.name, command)
 		return errors.New("client is not active. Can't send message")
 	}
 
 	log.Debugln("Write message:", command)
 
-	(*client.conn).Write([]byte(command))
-	return nil
+	client.capture.record(Outbound, []byte(command))
+	return client.writer.Write(client.framer.Encode([]byte(command)))
 }
 
 // WriteError Handy for informing the user they're a piece of shit.
@@ -87,25 +136,44 @@ func (client *Client) WriteError(code string, message string) error {
 	return err
 }
 
-func (client *Client) processCommand(command string) {
-	gsPacket, err := ProcessCommand(command)
-	if err != nil {
-		log.Errorf("%s: Error processing command %s.\n%v", client.name, command, err)
-		client.eventChan <- ClientEvent{
-			Name: "error",
-			Data: err,
-		}
-		return
-	}
+// errorCodes of FESL answers
+const (
+	// FESLErrorSystem is the errorCode of FESL's generic system error
+	FESLErrorSystem = 99
+	// FESLErrorUserNotFound answers a NuLogin of an unknown account
+	FESLErrorUserNotFound = 101
+	// FESLErrorWrongPassword answers a NuLogin with the wrong password
+	FESLErrorWrongPassword = 122
+)
 
-	client.eventChan <- ClientEvent{
-		Name: "command." + gsPacket.Query,
-		Data: gsPacket,
-	}
-	client.eventChan <- ClientEvent{
-		Name: "command",
-		Data: gsPacket,
+// WriteFESLError answers a FESL transaction with an error
+func (client *Client) WriteFESLError(msgType string, txn string, code int, message string, msgType2 uint32) error {
+	return client.WriteFESL(msgType, map[string]string{
+		"TXN":               txn,
+		"localizedMessage":  "\"" + message + "\"",
+		"errorContainer.[]": "0",
+		"errorCode":         strconv.Itoa(code),
+	}, msgType2)
+}
+
+// WriteFESLUnavailable answers a FESL transaction with the error of a
+// service which is down, e.g. the database while the circuit breaker of
+// core.DB fails fast with core.ErrUnavailable
+func (client *Client) WriteFESLUnavailable(msgType string, txn string, msgType2 uint32) error {
+	return client.WriteFESLError(msgType, txn, FESLErrorSystem, "Service unavailable", msgType2)
+}
+
+// WriteNuLoginError answers a NuLogin which core.Credentials refused
+func (client *Client) WriteNuLoginError(err error, msgType2 uint32) error {
+	switch {
+	case errors.Is(err, core.ErrNotFound):
+		return client.WriteFESLError("acct", "NuLogin", FESLErrorUserNotFound, "The user was not found", msgType2)
+	case errors.Is(err, core.ErrWrongPassword):
+		return client.WriteFESLError("acct", "NuLogin", FESLErrorWrongPassword, "The password the user specified is incorrect", msgType2)
+	case errors.Is(err, core.ErrUnavailable):
+		return client.WriteFESLUnavailable("acct", "NuLogin", msgType2)
 	}
+	return client.WriteFESLError("acct", "NuLogin", FESLErrorSystem, "System error", msgType2)
 }
 
 func (client *Client) Close() {
@@ -114,95 +182,131 @@ func (client *Client) Close() {
 		Name: "close",
 		Data: client,
 	}
-	client.IsActive = false
+	client.active.Store(false)
+}
+
+// disconnect closes the connection without waiting for anything, the
+// read loop notices and cleans up
+func (client *Client) disconnect() {
+	client.conn.Close()
+}
+
+// markClosed tells everybody waiting on the client that it's gone
+func (client *Client) markClosed() {
+	client.closeOnce.Do(func() {
+		close(client.closed)
+	})
 }
 
 func (client *Client) WriteFESL(msgType string, msg map[string]string, msgType2 uint32) error {
 
-	if !client.IsActive {
+	if !client.active.Load() {
 		log.Notef("%s: Trying to write to inactive Client.\n%v", client.name, msg)
-		return errors.New("ClientTLS is not active. Can't send message")
+		return errors.New("client is not active. Can't send message")
 	}
-	var lena int32
-	var buf bytes.Buffer
 
-	payloadEncoded := SerializeFESL(msg)
-	baselen := len(payloadEncoded)
-	lena = int32(baselen + 12)
+	log.Debugln("Write message:", msg, msgType, msgType2)
 
-	buf.Write([]byte(msgType))
+	frame := EncodeFESL(msgType, msg, msgType2)
+	client.capture.record(Outbound, frame)
+	return client.writer.Write(client.framer.Encode(frame))
+}
 
-	err := binary.Write(&buf, binary.BigEndian, &msgType2)
+// handleFrame fires the events of a single frame. It returns false once
+// the client has to go.
+func (client *Client) handleFrame(frame []byte) bool {
+	message, err := client.framer.Decode(frame)
 	if err != nil {
-		fmt.Println("binary.Write failed:", err)
+		// Garbage must not be cheaper than well-formed messages
+		if !client.limiter.allow("error", time.Now()) {
+			client.flood(nil)
+			return false
+		}
+		log.Errorf("%s: Error processing frame %s.\n%v", client.name, hex.EncodeToString(frame), err)
+		client.eventChan <- ClientEvent{
+			Name: "error",
+			Data: err,
+		}
+		return true
 	}
-
-	err = binary.Write(&buf, binary.BigEndian, &lena)
-	if err != nil {
-		fmt.Println("binary.Write failed:", err)
+	if message == nil {
+		return true
 	}
 
-	buf.Write([]byte(payloadEncoded))
-
-	log.Debugln("Write message:", msg, msgType, msgType2)
-
-	n, err := (*client.conn).Write(buf.Bytes())
-	if err != nil {
-		fmt.Println("Writing failed:", n, err)
+	if !client.limiter.allow(message.eventName(), time.Now()) {
+		client.flood(message)
+		return false
 	}
-	return nil
-}
 
-func (client *Client) readFESL(data []byte) {
-	p := bytes.NewBuffer(data)
-	log.Debugln(hex.EncodeToString(data))
-	i := 0
-	for {
-		outCommand := new(CommandFESL)
-
-		var payloadId uint32
-		var payloadLen uint32
-
-		payloadTypeRaw := make([]byte, 4)
-		_, err := p.Read(payloadTypeRaw)
-		if err != nil {
-			return
+	// Text protocols hand out the raw command as well
+	if _, ok := message.(*Command); ok {
+		client.eventChan <- ClientEvent{
+			Name: "data",
+			Data: string(frame),
 		}
+	}
 
-		payloadType := string(payloadTypeRaw)
-
-		binary.Read(p, binary.BigEndian, &payloadId)
-		binary.Read(p, binary.BigEndian, &payloadLen)
-
-		payloadRaw := make([]byte, (payloadLen - 12))
-		p.Read(payloadRaw)
-
-		payload := ProcessFESL(string(payloadRaw))
-
-		outCommand.Query = payloadType
-		outCommand.PayloadID = payloadId
-		outCommand.Message = payload
+	client.eventChan <- ClientEvent{
+		Name: "command." + message.eventName(),
+		Data: message,
+	}
+	client.eventChan <- ClientEvent{
+		Name: "command",
+		Data: message,
+	}
+	return true
+}
 
-		client.eventChan <- ClientEvent{
-			Name: "command." + payloadType,
-			Data: outCommand,
-		}
-		client.eventChan <- ClientEvent{
-			Name: "command",
-			Data: outCommand,
+// flood tells a client sending too fast off in its own protocol and
+// disconnects it once the error is sent. message is nil for frames that
+// didn't decode, those get no answer.
+func (client *Client) flood(message Message) {
+	name := "undecodable frames"
+	if message != nil {
+		name = message.eventName()
+	}
+	log.Notef("%s: %v is flooding with %s, disconnecting.", client.name, client.IpAddr, name)
+
+	switch message := message.(type) {
+	case *CommandFESL:
+		client.WriteFESLError(message.Query, message.Message["TXN"], FESLErrorSystem, "Too many requests", message.PayloadID)
+	case *Command:
+		if _, ok := client.framer.(LineFramer); ok {
+			client.Write("ERROR :Excess Flood")
+		} else {
+			client.WriteError("0", "Too many requests")
 		}
-
-		i++
 	}
 
+	client.eventChan <- ClientEvent{
+		Name: "error",
+		Data: ErrRateLimited,
+	}
+	client.eventChan <- ClientEvent{
+		Name: "close",
+		Data: client,
+	}
 }
 
 func (client *Client) handleRequest() {
-	client.IsActive = true
-	buf := make([]byte, 1024) // buffer
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			report := client.crash("read loop", recovered, nil)
+			client.eventChan <- ClientEvent{
+				Name: "panic",
+				Data: report,
+			}
+			client.eventChan <- ClientEvent{
+				Name: "close",
+				Data: client,
+			}
+		}
+	}()
+
+	buf := make([]byte, 4096) // buffer
 
-	for client.IsActive {
-		n, err := (*client.conn).Read(buf)
+	for client.active.Load() {
+		n, err := client.conn.Read(buf)
 		if err != nil {
 			if err != io.EOF {
 				log.Debugf("%s: Reading from client threw an error. %v", client.name, err)
@@ -226,41 +330,40 @@ func (client *Client) handleRequest() {
 
 		}
 
-		if client.FESL {
-			client.readFESL(buf[:n])
-			continue
-		}
-
 		client.recvBuffer = append(client.recvBuffer, buf[:n]...)
 
-		message := strings.TrimSpace(string(client.recvBuffer))
+		log.Debugln("Got message:", hex.EncodeToString(buf[:n]))
 
-		log.Debugln("Got message:", hex.EncodeToString(client.recvBuffer))
-
-		if strings.Index(message, "\\final\\") == -1 {
-			if len(client.recvBuffer) > 4096 {
-				// We don't support more than 2048 long messages
-				client.recvBuffer = make([]byte, 0)
+		for len(client.recvBuffer) > 0 {
+			advance, frame, err := client.framer.Split(client.recvBuffer)
+			if err != nil {
+				// There is no telling where the next frame starts
+				log.Errorf("%s: Unreadable data from %v, disconnecting. %v", client.name, client.IpAddr, err)
+				client.eventChan <- ClientEvent{
+					Name: "error",
+					Data: err,
+				}
+				client.eventChan <- ClientEvent{
+					Name: "close",
+					Data: client,
+				}
+				return
 			}
-			continue
-		}
-
-		client.eventChan <- ClientEvent{
-			Name: "data",
-			Data: message,
-		}
-
-		commands := strings.Split(message, "\\final\\")
-		for _, command := range commands {
-			if len(command) == 0 {
-				continue
+			if advance == 0 {
+				break
 			}
 
-			client.processCommand(command)
+			client.recvBuffer = client.recvBuffer[advance:]
+			client.history.add(frame)
+			client.capture.record(Inbound, frame)
+			client.upstream.forward(frame)
+			if !client.handleFrame(frame) {
+				return
+			}
 		}
 
-		// Add unprocessed commands back into recvBuffer
-		client.recvBuffer = []byte(commands[(len(commands) - 1)])
+		// Don't let the buffer keep the whole history alive
+		client.recvBuffer = append(make([]byte, 0, len(client.recvBuffer)), client.recvBuffer...)
 	}
 
 }
@@ -0,0 +1,105 @@
+package GameSpy_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func nextClientEvent(t *testing.T, events chan GameSpy.ClientEvent) GameSpy.ClientEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("Client fired no event.")
+	}
+	return GameSpy.ClientEvent{}
+}
+
+func feslFrame(msgType string, msgType2 uint32, payload string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(msgType)
+	binary.Write(&buf, binary.BigEndian, msgType2)
+	binary.Write(&buf, binary.BigEndian, uint32(len(payload)+12))
+	buf.WriteString(payload)
+	return buf.Bytes()
+}
+
+func TestClientSessionOverPipe(t *testing.T) {
+	conn, peer := net.Pipe()
+
+	client := new(GameSpy.Client)
+	events, err := client.New("test", conn)
+	if err != nil {
+		t.Fatalf("New threw an error: %v", err)
+	}
+
+	go peer.Write([]byte("\\login\\\\challenge\\abc\\uniquenick\\test\\final\\"))
+
+	if event := nextClientEvent(t, events); event.Name != "data" {
+		t.Errorf("First event was incorrect, got: %s, want: %s.", event.Name, "data")
+	}
+	event := nextClientEvent(t, events)
+	if event.Name != "command.login" {
+		t.Fatalf("Command event was incorrect, got: %s, want: %s.", event.Name, "command.login")
+	}
+	if command := event.Data.(*GameSpy.Command); command.Message["uniquenick"] != "test" {
+		t.Errorf("Command was parsed incorrectly, got: %v.", command.Message)
+	}
+	if event := nextClientEvent(t, events); event.Name != "command" {
+		t.Errorf("Generic command event was incorrect, got: %s, want: %s.", event.Name, "command")
+	}
+
+	reply := "\\lc\\2\\sesskey\\1\\final\\"
+	client.Write(reply)
+	got := make([]byte, len(reply))
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(peer, got); err != nil || string(got) != reply {
+		t.Errorf("Reply was incorrect, got: %q, %v.", got, err)
+	}
+
+	peer.Close()
+	if event := nextClientEvent(t, events); event.Name != "close" {
+		t.Errorf("Closing the peer fired the wrong event, got: %s, want: %s.", event.Name, "close")
+	}
+}
+
+func TestClientFESLSessionOverPipe(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	client := new(GameSpy.Client)
+	client.FESL = true
+	events, _ := client.New("test", conn)
+
+	go peer.Write(feslFrame("fsys", 0xC0000001, "TXN=Hello\nclientString=bfwest-pc\x00"))
+
+	event := nextClientEvent(t, events)
+	if event.Name != "command.fsys.Hello" {
+		t.Fatalf("Command event was incorrect, got: %s, want: %s.", event.Name, "command.fsys.Hello")
+	}
+	command := event.Data.(*GameSpy.CommandFESL)
+	if command.Query != "fsys" || command.PayloadID != 0xC0000001 || command.Message["TXN"] != "Hello" {
+		t.Errorf("Command was parsed incorrectly, got: %+v.", command)
+	}
+
+	client.WriteFESL("fsys", map[string]string{"TXN": "Hello"}, 0x80000001)
+	header := make([]byte, 12)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(peer, header); err != nil {
+		t.Fatalf("Reading the reply threw an error: %v", err)
+	}
+	if string(header[:4]) != "fsys" || binary.BigEndian.Uint32(header[4:8]) != 0x80000001 {
+		t.Errorf("Reply header was incorrect, got: %x.", header)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[8:12])-12)
+	io.ReadFull(peer, payload)
+	if string(payload) != "TXN=Hello\x00" {
+		t.Errorf("Reply payload was incorrect, got: %q.", payload)
+	}
+}
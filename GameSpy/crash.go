@@ -0,0 +1,205 @@
+package GameSpy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// CrashConfig configures what happens when handling a client panics.
+// The client is closed either way, the rest of the server keeps running.
+type CrashConfig struct {
+	// Dir is where crash reports are written to. Empty disables them.
+	Dir string
+	// Frames is the number of inbound frames kept per client for the
+	// crash report. 0 means 16.
+	Frames int
+}
+
+// CrashReport describes a panic while handling a client or a datagram
+type CrashReport struct {
+	Time   time.Time
+	Server string
+	Addr   string
+	// Where names the goroutine or handler which panicked
+	Where string
+	Panic string
+	Stack []byte
+	// Frames are the last frames the client sent, oldest first, or the
+	// datagram. Passwords, login responses and keys are redacted.
+	Frames [][]byte
+	// State is the client state as the handler which panicked saw it.
+	// Handlers change it without any lock, so it's only taken in their
+	// goroutine and left empty for panics anywhere else.
+	State ClientState
+	// Path is the file the report was written to, if any
+	Path string
+}
+
+// String formats the report the way it's written to disk
+func (report *CrashReport) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Time:   %s\n", report.Time.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "Server: %s\n", report.Server)
+	fmt.Fprintf(&b, "Client: %s\n", report.Addr)
+	fmt.Fprintf(&b, "Where:  %s\n", report.Where)
+	fmt.Fprintf(&b, "Panic:  %s\n\n", report.Panic)
+	fmt.Fprintf(&b, "%s\n", report.Stack)
+
+	fmt.Fprintf(&b, "Last %d frames:\n", len(report.Frames))
+	for i, frame := range report.Frames {
+		fmt.Fprintf(&b, "--- %d ---\n%s", i, hex.Dump(frame))
+	}
+
+	fmt.Fprintf(&b, "\nState:\n%+v\n", report.State)
+	return b.String()
+}
+
+// frameHistory keeps the last frames of a client
+type frameHistory struct {
+	mutex  sync.Mutex
+	frames [][]byte
+	next   int
+	full   bool
+}
+
+func newFrameHistory(size int) *frameHistory {
+	if size <= 0 {
+		size = 16
+	}
+	return &frameHistory{frames: make([][]byte, size)}
+}
+
+// add keeps a redacted copy of frame, dropping the oldest one if needed
+func (history *frameHistory) add(frame []byte) {
+	frame = redactFrame(frame)
+
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	history.frames[history.next] = frame
+	history.next = (history.next + 1) % len(history.frames)
+	if history.next == 0 {
+		history.full = true
+	}
+}
+
+// list returns the kept frames, oldest first
+func (history *frameHistory) list() [][]byte {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	if !history.full {
+		return append([][]byte(nil), history.frames[:history.next]...)
+	}
+	return append(append([][]byte(nil), history.frames[history.next:]...), history.frames[:history.next]...)
+}
+
+// crash builds the report for a panic and writes it to disk. Call it
+// from the deferred function which recovered. state is nil unless the
+// caller may read the client state.
+func (client *Client) crash(where string, recovered interface{}, state *ClientState) *CrashReport {
+	report := &CrashReport{
+		Time:   time.Now(),
+		Server: client.name,
+		Where:  where,
+		Panic:  fmt.Sprint(recovered),
+		Stack:  debug.Stack(),
+	}
+	if state != nil {
+		report.State = *state
+	}
+	if client.IpAddr != nil {
+		report.Addr = client.IpAddr.String()
+	}
+	if client.history != nil {
+		report.Frames = client.history.list()
+	}
+
+	log.Errorln(client.name+": Panic in", where, "of", report.Addr, "closing the client.", report.Panic)
+	report.write(client.crashConfig)
+	return report
+}
+
+// packetCrash builds the report for a panic handling a datagram of addr
+// and writes it to disk. Call it from the deferred function which
+// recovered.
+func (server *Server) packetCrash(addr *net.UDPAddr, datagram []byte, recovered interface{}) *CrashReport {
+	report := &CrashReport{
+		Time:   time.Now(),
+		Server: server.Name,
+		Where:  "datagram",
+		Panic:  fmt.Sprint(recovered),
+		Stack:  debug.Stack(),
+		Frames: [][]byte{redactFrame(datagram)},
+	}
+	if addr != nil {
+		report.Addr = addr.String()
+	}
+
+	log.Errorln(server.Name+": Panic handling a datagram of", report.Addr, "dropping it.", report.Panic)
+	report.write(server.Crashes)
+	return report
+}
+
+// write writes the report to the directory of config, if there is one
+func (report *CrashReport) write(config CrashConfig) {
+	if config.Dir == "" {
+		return
+	}
+
+	path, err := writeCrashReport(config.Dir, report)
+	if err != nil {
+		log.Errorln(report.Server+": Writing the crash report failed.", err)
+		return
+	}
+	report.Path = path
+	log.Errorln(report.Server+": Crash report written to", path)
+}
+
+// panicked hands a report from outside the client goroutines over to
+// the server and closes the client
+func (client *Client) panicked(report *CrashReport) {
+	// The caller may be the one consuming the events, don't wait for it
+	go func() {
+		select {
+		case client.eventChan <- ClientEvent{Name: "panic", Data: report}:
+		case <-client.closed:
+		}
+		client.disconnect()
+	}()
+}
+
+// secretValues matches the values of the keys which must not end up in a
+// crash report. FESL keys start after the header or a newline.
+var secretValues = regexp.MustCompile(`((?:^|(?s:^.{12})|[\n\x00])(?:password|response|lkey)=)[^\n\x00]*|(\\(?:password|response|lkey)\\)[^\\]*`)
+
+// redactFrame returns a copy of frame without the values of secret keys
+func redactFrame(frame []byte) []byte {
+	return secretValues.ReplaceAll(frame, []byte("$1$2***"))
+}
+
+// writeCrashReport writes report to dir. Reports hold what clients sent,
+// so only the owner may read them.
+func writeCrashReport(dir string, report *CrashReport) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("crash-%s-%s.txt",
+		report.Time.Format("20060102-150405.000000"),
+		strings.NewReplacer(":", "_", "/", "_", "[", "", "]", "").Replace(report.Addr))
+	path := filepath.Join(dir, name)
+
+	return path, os.WriteFile(path, []byte(report.String()), 0600)
+}
@@ -0,0 +1,133 @@
+package GameSpy_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+// panicFramer is a GameSpyFramer which panics on \boom\
+type panicFramer struct {
+	GameSpy.GameSpyFramer
+}
+
+func (framer panicFramer) Decode(frame []byte) (GameSpy.Message, error) {
+	if strings.HasPrefix(string(frame), "\\boom\\") {
+		var command *GameSpy.Command
+		_ = command.Query
+	}
+	return framer.GameSpyFramer.Decode(frame)
+}
+
+func TestClientPanic(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{
+		Name:    "test",
+		Framer:  panicFramer{},
+		Crashes: GameSpy.CrashConfig{Dir: t.TempDir(), Frames: 2},
+	}
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	defer conn.Close()
+	conn.Write([]byte("\\ka\\1\\final\\\\login\\\\response\\secret\\final\\\\boom\\\\final\\"))
+
+	event := nextSocketEvent(t, events, "client.panic")
+	report := event.Data.(GameSpy.EventClientPanic).Report
+	if len(report.Frames) != 2 || string(report.Frames[1]) != "\\boom\\\\final\\" {
+		t.Errorf("Report frames were incorrect, got: %q.", report.Frames)
+	}
+	if len(report.Frames) > 0 && string(report.Frames[0]) != "\\login\\\\response\\***\\final\\" {
+		t.Errorf("Login response was not redacted, got: %q.", report.Frames[0])
+	}
+	written, err := os.ReadFile(report.Path)
+	if err != nil || !strings.Contains(string(written), "nil pointer dereference") || strings.Contains(string(written), "secret") {
+		t.Errorf("Crash report was not written, got: %v.", err)
+	}
+	if info, err := os.Stat(report.Path); runtime.GOOS != "windows" && (err != nil || info.Mode().Perm() != 0600) {
+		t.Errorf("Crash report mode was incorrect, got: %v, %v.", info.Mode().Perm(), err)
+	}
+	nextSocketEvent(t, events, "client.close")
+
+	// Everybody else carries on
+	other, _ := net.Dial("tcp", listener.Addr().String())
+	defer other.Close()
+	other.Write([]byte("\\ka\\\\final\\"))
+	nextSocketEvent(t, events, "client.command.ka")
+}
+
+func TestPacketPanic(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening threw an error: %v", err)
+	}
+	server := &GameSpy.Server{
+		Name:    "test",
+		Framer:  panicFramer{},
+		Crashes: GameSpy.CrashConfig{Dir: t.TempDir()},
+	}
+	events, _ := server.ListenPacket(context.Background(), packetConn)
+	defer server.Shutdown(context.Background())
+
+	conn, _ := net.Dial("udp", packetConn.LocalAddr().String())
+	defer conn.Close()
+	conn.Write([]byte("\\boom\\\\final\\"))
+
+	event := nextSocketEvent(t, events, "client.panic")
+	panicked := event.Data.(GameSpy.EventClientPanic)
+	if panicked.Client != nil || len(panicked.Report.Frames) != 1 || string(panicked.Report.Frames[0]) != "\\boom\\\\final\\" {
+		t.Errorf("Panic event was incorrect, got: %+v.", panicked)
+	}
+	if _, err := os.Stat(panicked.Report.Path); err != nil {
+		t.Errorf("Crash report was not written, got: %v.", err)
+	}
+
+	// The server keeps reading datagrams
+	conn.Write([]byte("\\ka\\\\final\\"))
+	nextSocketEvent(t, events, "command.ka")
+}
+
+func TestHandlersDispatchPanic(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{Name: "test"}
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	handlers := new(GameSpy.Handlers)
+	GameSpy.On(handlers, func(event GameSpy.EventClientCommand) {
+		if event.Command.Query == "boom" {
+			panic("handler failed")
+		}
+	})
+
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	defer conn.Close()
+	conn.Write([]byte("\\boom\\\\final\\"))
+
+	var panicked bool
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			handlers.Dispatch(event)
+			switch event.Name {
+			case "client.panic":
+				panicked = event.Data.(GameSpy.EventClientPanic).Report.Panic == "handler failed"
+			case "client.close":
+				if !panicked {
+					t.Errorf("Client was closed without a panic event.")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Client was not closed after its handler panicked.")
+		}
+	}
+}
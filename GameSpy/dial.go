@@ -0,0 +1,363 @@
+package GameSpy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrConnClosed is returned by calls on a dialed connection once it's gone
+var ErrConnClosed = errors.New("connection closed")
+
+// DialConfig is what all dialed connections have in common
+type DialConfig struct {
+	// Dial opens the connection. nil dials with a net.Dialer.
+	Dial func(ctx context.Context, network string, address string) (net.Conn, error)
+	// TLS runs a TLS handshake over the connection if it's set, as the
+	// clients of FESL do. See NewLegacyTLSConfig for the server side.
+	TLS *tls.Config
+	// Timeout bounds calls whose context has no deadline. 0 means 10
+	// seconds.
+	Timeout time.Duration
+}
+
+func (config DialConfig) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	dial := config.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if config.TLS == nil {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, config.TLS)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (config DialConfig) timeout() time.Duration {
+	if config.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return config.Timeout
+}
+
+// withTimeout gives ctx a deadline unless it has one
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// FESLError is the error answer to a FESL or Theater transaction
+type FESLError struct {
+	Type    string
+	TXN     string
+	Code    int
+	Message string
+}
+
+func (err *FESLError) Error() string {
+	return fmt.Sprintf("%s %s failed with error %d: %s", err.Type, err.TXN, err.Code, err.Message)
+}
+
+// frameReader reads frames off a connection
+type frameReader struct {
+	conn   net.Conn
+	framer Framer
+	buffer []byte
+	buf    []byte
+}
+
+func newFrameReader(conn net.Conn, framer Framer) *frameReader {
+	return &frameReader{conn: conn, framer: framer, buf: make([]byte, 4096)}
+}
+
+// next returns the next frame, waiting for it if need be
+func (reader *frameReader) next() ([]byte, error) {
+	for {
+		advance, frame, err := reader.framer.Split(reader.buffer)
+		if err != nil {
+			return nil, err
+		}
+		if advance > 0 {
+			frame = append([]byte(nil), frame...)
+			reader.buffer = reader.buffer[advance:]
+			return frame, nil
+		}
+
+		n, err := reader.conn.Read(reader.buf)
+		reader.buffer = append(reader.buffer, reader.buf[:n]...)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// feslConn is the dial side of FESL and Theater. It matches answers to
+// their requests, by the id in the header for FESL and by the TID for
+// Theater, and hands everything else to the handler.
+type feslConn struct {
+	conn    net.Conn
+	reader  *frameReader
+	timeout time.Duration
+	theater bool
+	handler func(*CommandFESL)
+
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	seq     uint32
+	pending map[uint32]chan *CommandFESL
+	chunks  map[string]*strings.Builder
+	err     error
+
+	closed chan struct{}
+}
+
+func newFESLConn(conn net.Conn, config DialConfig, theater bool, handler func(*CommandFESL)) *feslConn {
+	fesl := &feslConn{
+		conn:    conn,
+		reader:  newFrameReader(conn, FESLFramer{}),
+		timeout: config.timeout(),
+		theater: theater,
+		handler: handler,
+		pending: make(map[uint32]chan *CommandFESL),
+		chunks:  make(map[string]*strings.Builder),
+		closed:  make(chan struct{}),
+	}
+	go fesl.run()
+	return fesl
+}
+
+// Call sends a request and waits for its answer. Error answers come back
+// as *FESLError.
+func (fesl *feslConn) Call(ctx context.Context, msgType string, msg map[string]string) (*CommandFESL, error) {
+	ctx, cancel := withTimeout(ctx, fesl.timeout)
+	defer cancel()
+
+	request := make(map[string]string, len(msg)+1)
+	for key, value := range msg {
+		request[key] = value
+	}
+
+	answer := make(chan *CommandFESL, 1)
+
+	fesl.lock.Lock()
+	if fesl.err != nil {
+		fesl.lock.Unlock()
+		return nil, fesl.err
+	}
+	fesl.seq = (fesl.seq + 1) & 0x00FFFFFF
+	if fesl.seq == 0 {
+		fesl.seq = 1
+	}
+	seq := fesl.seq
+	fesl.pending[seq] = answer
+	fesl.lock.Unlock()
+
+	defer func() {
+		fesl.lock.Lock()
+		delete(fesl.pending, seq)
+		fesl.lock.Unlock()
+	}()
+
+	// FESL requests carry their sequence in the header, Theater ones in
+	// the TID
+	msgType2 := 0xC0000000 | seq
+	if fesl.theater {
+		msgType2 = 0x40000000
+		request["TID"] = strconv.FormatUint(uint64(seq), 10)
+	}
+	if err := fesl.write(ctx, msgType, request, msgType2); err != nil {
+		return nil, err
+	}
+
+	select {
+	case message := <-answer:
+		if code, ok := message.Message["errorCode"]; ok {
+			number, _ := strconv.Atoi(code)
+			return message, &FESLError{
+				Type:    message.Query,
+				TXN:     message.Message["TXN"],
+				Code:    number,
+				Message: strings.Trim(message.Message["localizedMessage"], "\""),
+			}
+		}
+		return message, nil
+	case <-fesl.closed:
+		return nil, fesl.closeErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Send writes a message without waiting for an answer
+func (fesl *feslConn) Send(ctx context.Context, msgType string, msg map[string]string, msgType2 uint32) error {
+	ctx, cancel := withTimeout(ctx, fesl.timeout)
+	defer cancel()
+	return fesl.write(ctx, msgType, msg, msgType2)
+}
+
+func (fesl *feslConn) write(ctx context.Context, msgType string, msg map[string]string, msgType2 uint32) error {
+	fesl.writeLock.Lock()
+	defer fesl.writeLock.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		fesl.conn.SetWriteDeadline(deadline)
+	}
+	_, err := fesl.conn.Write(EncodeFESL(msgType, msg, msgType2))
+	return err
+}
+
+// Close closes the connection
+func (fesl *feslConn) Close() error {
+	err := fesl.conn.Close()
+	<-fesl.closed
+	return err
+}
+
+// Done is closed once the connection is gone
+func (fesl *feslConn) Done() <-chan struct{} {
+	return fesl.closed
+}
+
+func (fesl *feslConn) closeErr() error {
+	fesl.lock.Lock()
+	defer fesl.lock.Unlock()
+	return fesl.err
+}
+
+func (fesl *feslConn) run() {
+	var err error
+	defer func() {
+		fesl.lock.Lock()
+		if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+			err = nil
+		}
+		fesl.err = ErrConnClosed
+		if err != nil {
+			fesl.err = fmt.Errorf("%w: %v", ErrConnClosed, err)
+		}
+		fesl.lock.Unlock()
+		close(fesl.closed)
+	}()
+
+	for {
+		var frame []byte
+		frame, err = fesl.reader.next()
+		if err != nil {
+			return
+		}
+
+		// Answers aren't held to the limits requests are
+		command, ok := fesl.reassemble(&CommandFESL{
+			Query:     string(frame[:4]),
+			PayloadID: binary.BigEndian.Uint32(frame[4:8]),
+			Message:   ProcessFESL(string(frame[12:])),
+		})
+		if !ok {
+			continue
+		}
+
+		seq := command.PayloadID & 0x00FFFFFF
+		if fesl.theater {
+			tid, _ := strconv.ParseUint(command.Message["TID"], 10, 32)
+			seq = uint32(tid)
+		}
+
+		fesl.lock.Lock()
+		answer, ok := fesl.pending[seq]
+		delete(fesl.pending, seq)
+		fesl.lock.Unlock()
+
+		if ok && seq != 0 {
+			answer <- command
+			continue
+		}
+		fesl.unsolicited(command)
+	}
+}
+
+// unsolicited answers the keep alives of the server and hands everything
+// else that isn't an answer to the handler
+func (fesl *feslConn) unsolicited(command *CommandFESL) {
+	ctx := context.Background()
+
+	switch {
+	case command.Query == "fsys" && command.Message["TXN"] == "MemCheck":
+		fesl.Send(ctx, "fsys", map[string]string{"TXN": "MemCheck", "result": ""}, command.PayloadID)
+		return
+	case command.Query == "fsys" && command.Message["TXN"] == "Ping":
+		fesl.Send(ctx, "fsys", map[string]string{"TXN": "Ping"}, command.PayloadID)
+		return
+	case command.Query == "PING":
+		fesl.Send(ctx, "PING", map[string]string{"TID": command.Message["TID"]}, 0)
+		return
+	}
+
+	if fesl.handler != nil {
+		fesl.handler(command)
+	}
+}
+
+// reassemble puts chunked answers back together. It returns false until
+// the last chunk is in.
+func (fesl *feslConn) reassemble(command *CommandFESL) (*CommandFESL, bool) {
+	data, ok := command.Message["data"]
+	size, err := strconv.Atoi(command.Message["decodedSize"])
+	if !ok || err != nil {
+		return command, true
+	}
+
+	key := command.Query + strconv.FormatUint(uint64(command.PayloadID), 16)
+	chunks, ok := fesl.chunks[key]
+	if !ok {
+		chunks = new(strings.Builder)
+		fesl.chunks[key] = chunks
+	}
+	chunks.WriteString(data)
+
+	encoded, err := url.QueryUnescape(chunks.String())
+	if err != nil {
+		encoded = chunks.String()
+	}
+	if base64.StdEncoding.DecodedLen(len(encoded)) < size {
+		return nil, false
+	}
+	delete(fesl.chunks, key)
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return command, true
+	}
+	return &CommandFESL{
+		Query:     command.Query,
+		PayloadID: command.PayloadID,
+		Message:   ProcessFESL(string(decoded)),
+	}, true
+}
+
+func atoi(s string) int {
+	number, _ := strconv.Atoi(s)
+	return number
+}
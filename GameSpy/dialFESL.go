@@ -0,0 +1,209 @@
+package GameSpy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// FESLConfig configures DialFESL. The defaults are those of the Heroes
+// client.
+type FESLConfig struct {
+	DialConfig
+	Hello HelloRequest
+	// User and Password log in with NuLogin after the Hello if User is
+	// set
+	User     string
+	Password string
+	MacAddr  string
+	// Persona logs in with NuLoginPersona after NuLogin if it's set
+	Persona string
+	// Handler gets every message which isn't an answer, MemCheck and
+	// Ping are answered before
+	Handler func(*CommandFESL)
+}
+
+// HelloRequest is the fsys Hello of a client
+type HelloRequest struct {
+	ClientString    string
+	SKU             string
+	Locale          string
+	ClientPlatform  string
+	ClientVersion   string
+	SDKVersion      string
+	ProtocolVersion string
+	FragmentSize    string
+	// ClientType is "server" for game servers, empty for players
+	ClientType string
+}
+
+// HelloResponse is the answer to a HelloRequest
+type HelloResponse struct {
+	Domain          string
+	SubDomain       string
+	TheaterIP       string
+	TheaterPort     int
+	MessengerIP     string
+	MessengerPort   int
+	ActivityTimeout time.Duration
+	CurTime         string
+}
+
+// LoginResponse is the answer to NuLogin
+type LoginResponse struct {
+	LKey        string
+	Nuid        string
+	DisplayName string
+	UserID      int
+	ProfileID   int
+}
+
+// PersonaResponse is the answer to NuLoginPersona
+type PersonaResponse struct {
+	LKey      string
+	UserID    int
+	ProfileID int
+}
+
+// FESLConn is the client side of a FESL connection
+type FESLConn struct {
+	*feslConn
+
+	// Hello, Login and Persona hold the answers of the handshake
+	Hello   *HelloResponse
+	Login   *LoginResponse
+	Persona *PersonaResponse
+}
+
+// DialFESL connects to a FESL server and runs the handshake: the Hello,
+// then NuLogin and NuLoginPersona if the config asks for them.
+func DialFESL(ctx context.Context, address string, config FESLConfig) (*FESLConn, error) {
+	conn, err := config.dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	fesl := &FESLConn{feslConn: newFESLConn(conn, config.DialConfig, false, config.Handler)}
+
+	if fesl.Hello, err = fesl.SendHello(ctx, config.Hello); err != nil {
+		fesl.Close()
+		return nil, err
+	}
+	if config.User != "" {
+		if fesl.Login, err = fesl.NuLogin(ctx, config.User, config.Password, config.MacAddr); err != nil {
+			fesl.Close()
+			return nil, err
+		}
+	}
+	if config.Persona != "" {
+		if fesl.Persona, err = fesl.NuLoginPersona(ctx, config.Persona); err != nil {
+			fesl.Close()
+			return nil, err
+		}
+	}
+	return fesl, nil
+}
+
+// SendHello sends fsys Hello, empty fields take the defaults of the
+// Heroes client
+func (fesl *FESLConn) SendHello(ctx context.Context, hello HelloRequest) (*HelloResponse, error) {
+	answer, err := fesl.Call(ctx, "fsys", map[string]string{
+		"TXN":             "Hello",
+		"clientString":    or(hello.ClientString, "bfheroes-pc"),
+		"sku":             or(hello.SKU, "PC"),
+		"locale":          or(hello.Locale, "en_US"),
+		"clientPlatform":  or(hello.ClientPlatform, "PC"),
+		"clientVersion":   or(hello.ClientVersion, "1.42.217478.0"),
+		"SDKVersion":      or(hello.SDKVersion, "5.0.0.0.0"),
+		"protocolVersion": or(hello.ProtocolVersion, "2.0"),
+		"fragmentSize":    or(hello.FragmentSize, "8096"),
+		"clientType":      hello.ClientType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message := answer.Message
+	return &HelloResponse{
+		Domain:          message["domainPartition.domain"],
+		SubDomain:       message["domainPartition.subDomain"],
+		TheaterIP:       message["theaterIp"],
+		TheaterPort:     atoi(message["theaterPort"]),
+		MessengerIP:     message["messengerIp"],
+		MessengerPort:   atoi(message["messengerPort"]),
+		ActivityTimeout: time.Duration(atoi(message["activityTimeoutSecs"])) * time.Second,
+		CurTime:         message["curTime"],
+	}, nil
+}
+
+// NuLogin logs in with the account name and password
+func (fesl *FESLConn) NuLogin(ctx context.Context, user string, password string, macAddr string) (*LoginResponse, error) {
+	answer, err := fesl.Call(ctx, "acct", map[string]string{
+		"TXN":                 "NuLogin",
+		"returnEncryptedInfo": "0",
+		"nuid":                user,
+		"password":            password,
+		"macAddr":             or(macAddr, "$000000000000"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message := answer.Message
+	return &LoginResponse{
+		LKey:        message["lkey"],
+		Nuid:        message["nuid"],
+		DisplayName: message["displayName"],
+		UserID:      atoi(message["userId"]),
+		ProfileID:   atoi(message["profileId"]),
+	}, nil
+}
+
+// NuLoginPersona logs in with one of the personas of the account
+func (fesl *FESLConn) NuLoginPersona(ctx context.Context, name string) (*PersonaResponse, error) {
+	answer, err := fesl.Call(ctx, "acct", map[string]string{
+		"TXN":  "NuLoginPersona",
+		"name": name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message := answer.Message
+	return &PersonaResponse{
+		LKey:      message["lkey"],
+		UserID:    atoi(message["userId"]),
+		ProfileID: atoi(message["profileId"]),
+	}, nil
+}
+
+// NuGetPersonas returns the names of the personas of the account
+func (fesl *FESLConn) NuGetPersonas(ctx context.Context) ([]string, error) {
+	answer, err := fesl.Call(ctx, "acct", map[string]string{
+		"TXN":       "NuGetPersonas",
+		"namespace": "",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The count comes from the server, it can't be more than the keys a
+	// frame may hold
+	count := atoi(answer.Message["personas.[]"])
+	if count < 0 || count > limitsFor("fesl", Limits{}).MaxKeys {
+		return nil, fmt.Errorf("%w: NuGetPersonas answered with %d personas", ErrInvalidFrame, count)
+	}
+	personas := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		personas = append(personas, answer.Message["personas."+strconv.Itoa(i)])
+	}
+	return personas, nil
+}
+
+// or returns value, or def if it's empty
+func or(value string, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
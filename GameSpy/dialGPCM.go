@@ -0,0 +1,370 @@
+package GameSpy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrGPCMProof is returned by DialGPCM if the server doesn't know the
+// password either
+var ErrGPCMProof = errors.New("server sent the wrong proof")
+
+// GPCMError is an \error\ answer of GPCM
+type GPCMError struct {
+	Code    int
+	Message string
+	Fatal   bool
+}
+
+func (err *GPCMError) Error() string {
+	return fmt.Sprintf("GPCM error %d: %s", err.Code, err.Message)
+}
+
+// GPCMConfig configures DialGPCM
+type GPCMConfig struct {
+	DialConfig
+	// User is the unique nick to log in with
+	User     string
+	Password string
+	// GameName, ProductID and NamespaceID are sent along with the login
+	// if they are set
+	GameName    string
+	ProductID   string
+	NamespaceID string
+	// Handler gets every command which isn't an answer, keep alives are
+	// answered before
+	Handler func(*Command)
+}
+
+// GPCMLogin is the answer to a successful login
+type GPCMLogin struct {
+	SessionKey  string
+	UserID      int
+	ProfileID   int
+	UniqueNick  string
+	LoginTicket string
+}
+
+// GPCMProfile is the answer to getprofile
+type GPCMProfile struct {
+	ProfileID  int
+	Nick       string
+	UniqueNick string
+	Email      string
+	Country    string
+}
+
+// GPCMConn is the client side of a GPCM connection. Answers are matched
+// to their requests by the id.
+type GPCMConn struct {
+	conn    net.Conn
+	reader  *frameReader
+	timeout time.Duration
+	handler func(*Command)
+
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	seq     int
+	pending map[string]chan *Command
+	err     error
+
+	closed chan struct{}
+
+	// Login holds the answer of the handshake
+	Login *GPCMLogin
+}
+
+// DialGPCM connects to a GPCM server and logs in: it waits for the
+// challenge, answers with the proof and checks the proof of the server.
+func DialGPCM(ctx context.Context, address string, config GPCMConfig) (*GPCMConn, error) {
+	conn, err := config.dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	gpcm := &GPCMConn{
+		conn:    conn,
+		reader:  newFrameReader(conn, GameSpyFramer{}),
+		timeout: config.timeout(),
+		handler: config.Handler,
+		seq:     1,
+		pending: make(map[string]chan *Command),
+		closed:  make(chan struct{}),
+	}
+
+	if gpcm.Login, err = gpcm.login(ctx, config); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go gpcm.run()
+	return gpcm, nil
+}
+
+// login runs the handshake, before anything else reads the connection
+func (gpcm *GPCMConn) login(ctx context.Context, config GPCMConfig) (*GPCMLogin, error) {
+	ctx, cancel := withTimeout(ctx, gpcm.timeout)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+	gpcm.conn.SetDeadline(deadline)
+	defer gpcm.conn.SetDeadline(time.Time{})
+
+	challenge, err := gpcm.expect("lc")
+	if err != nil {
+		return nil, err
+	}
+	serverChallenge := challenge.Message["challenge"]
+
+	clientChallenge := BF2Random(32, rand.NewSource(time.Now().UnixNano()))
+	passwordHash := Hash(config.Password)
+
+	fields := []string{
+		"challenge", clientChallenge,
+		"uniquenick", config.User,
+		"response", GPCMProof(passwordHash, config.User, clientChallenge, serverChallenge),
+	}
+	for _, field := range [][2]string{
+		{"productid", config.ProductID},
+		{"gamename", config.GameName},
+		{"namespaceid", config.NamespaceID},
+	} {
+		if field[1] != "" {
+			fields = append(fields, field[0], field[1])
+		}
+	}
+	if _, err := gpcm.conn.Write(gpcmCommand("login", append(fields, "id", "1"))); err != nil {
+		return nil, err
+	}
+
+	answer, err := gpcm.expect("lc")
+	if err != nil {
+		return nil, err
+	}
+	if answer.Message["proof"] != GPCMProof(passwordHash, config.User, serverChallenge, clientChallenge) {
+		return nil, ErrGPCMProof
+	}
+
+	return &GPCMLogin{
+		SessionKey:  answer.Message["sesskey"],
+		UserID:      atoi(answer.Message["userid"]),
+		ProfileID:   atoi(answer.Message["profileid"]),
+		UniqueNick:  answer.Message["uniquenick"],
+		LoginTicket: answer.Message["lt"],
+	}, nil
+}
+
+// expect reads commands until one with the query comes in. Errors are
+// returned as *GPCMError.
+func (gpcm *GPCMConn) expect(query string) (*Command, error) {
+	for {
+		frame, err := gpcm.reader.next()
+		if err != nil {
+			return nil, err
+		}
+		command, err := gpcmDecode(frame)
+		if err != nil {
+			return nil, err
+		}
+		if command == nil {
+			continue
+		}
+		if err := gpcmError(command); err != nil {
+			return nil, err
+		}
+		if command.Query == query {
+			return command, nil
+		}
+	}
+}
+
+// Call sends a command and waits for the answer with the same id.
+// fields are the keys and values of the command, in order.
+func (gpcm *GPCMConn) Call(ctx context.Context, query string, fields ...string) (*Command, error) {
+	ctx, cancel := withTimeout(ctx, gpcm.timeout)
+	defer cancel()
+
+	answer := make(chan *Command, 1)
+
+	gpcm.lock.Lock()
+	if gpcm.err != nil {
+		gpcm.lock.Unlock()
+		return nil, gpcm.err
+	}
+	gpcm.seq++
+	id := strconv.Itoa(gpcm.seq)
+	gpcm.pending[id] = answer
+	gpcm.lock.Unlock()
+
+	defer func() {
+		gpcm.lock.Lock()
+		delete(gpcm.pending, id)
+		gpcm.lock.Unlock()
+	}()
+
+	if err := gpcm.write(ctx, gpcmCommand(query, append(fields[:len(fields):len(fields)], "id", id))); err != nil {
+		return nil, err
+	}
+
+	select {
+	case command := <-answer:
+		if err := gpcmError(command); err != nil {
+			return command, err
+		}
+		return command, nil
+	case <-gpcm.closed:
+		gpcm.lock.Lock()
+		defer gpcm.lock.Unlock()
+		return nil, gpcm.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Send writes a command without waiting for an answer
+func (gpcm *GPCMConn) Send(ctx context.Context, query string, fields ...string) error {
+	ctx, cancel := withTimeout(ctx, gpcm.timeout)
+	defer cancel()
+	return gpcm.write(ctx, gpcmCommand(query, fields))
+}
+
+// GetProfile returns the profile of the given id
+func (gpcm *GPCMConn) GetProfile(ctx context.Context, profileID int) (*GPCMProfile, error) {
+	answer, err := gpcm.Call(ctx, "getprofile", "sesskey", gpcm.Login.SessionKey, "profileid", strconv.Itoa(profileID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &GPCMProfile{
+		ProfileID:  atoi(answer.Message["profileid"]),
+		Nick:       answer.Message["nick"],
+		UniqueNick: answer.Message["uniquenick"],
+		Email:      answer.Message["email"],
+		Country:    answer.Message["countrycode"],
+	}, nil
+}
+
+// Logout says goodbye and closes the connection
+func (gpcm *GPCMConn) Logout(ctx context.Context) error {
+	err := gpcm.Send(ctx, "logout", "sesskey", gpcm.Login.SessionKey)
+	gpcm.Close()
+	return err
+}
+
+// Close closes the connection
+func (gpcm *GPCMConn) Close() error {
+	err := gpcm.conn.Close()
+	<-gpcm.closed
+	return err
+}
+
+// Done is closed once the connection is gone
+func (gpcm *GPCMConn) Done() <-chan struct{} {
+	return gpcm.closed
+}
+
+func (gpcm *GPCMConn) write(ctx context.Context, command []byte) error {
+	gpcm.writeLock.Lock()
+	defer gpcm.writeLock.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		gpcm.conn.SetWriteDeadline(deadline)
+	}
+	_, err := gpcm.conn.Write(command)
+	return err
+}
+
+func (gpcm *GPCMConn) run() {
+	var err error
+	defer func() {
+		gpcm.lock.Lock()
+		if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+			err = nil
+		}
+		gpcm.err = ErrConnClosed
+		if err != nil {
+			gpcm.err = fmt.Errorf("%w: %v", ErrConnClosed, err)
+		}
+		gpcm.lock.Unlock()
+		close(gpcm.closed)
+	}()
+
+	for {
+		var frame []byte
+		frame, err = gpcm.reader.next()
+		if err != nil {
+			return
+		}
+
+		var command *Command
+		command, err = gpcmDecode(frame)
+		if err != nil {
+			return
+		}
+		if command == nil {
+			continue
+		}
+
+		id := command.Message["id"]
+		gpcm.lock.Lock()
+		answer, ok := gpcm.pending[id]
+		delete(gpcm.pending, id)
+		gpcm.lock.Unlock()
+
+		if ok {
+			answer <- command
+			continue
+		}
+
+		if command.Query == "ka" {
+			gpcm.Send(context.Background(), "ka")
+			continue
+		}
+		if gpcm.handler != nil {
+			gpcm.handler(command)
+		}
+	}
+}
+
+// gpcmDecode parses a command without holding it to the limits requests
+// are
+func gpcmDecode(frame []byte) (*Command, error) {
+	command := strings.TrimSpace(strings.TrimSuffix(string(frame), string(gamespyFinal)))
+	if len(command) == 0 {
+		return nil, nil
+	}
+	return ProcessCommand(command)
+}
+
+// gpcmError turns \error\ commands into a *GPCMError
+func gpcmError(command *Command) error {
+	if command.Query != "error" {
+		return nil
+	}
+	_, fatal := command.Message["fatal"]
+	return &GPCMError{
+		Code:    atoi(command.Message["err"]),
+		Message: command.Message["errmsg"],
+		Fatal:   fatal,
+	}
+}
+
+// gpcmCommand builds a command out of the query and pairs of keys and
+// values
+func gpcmCommand(query string, fields []string) []byte {
+	var b strings.Builder
+	b.WriteString("\\" + query + "\\")
+	for i := 0; i+1 < len(fields); i += 2 {
+		b.WriteString("\\" + fields[i] + "\\" + fields[i+1])
+	}
+	b.WriteString("\\final\\")
+	return []byte(b.String())
+}
@@ -0,0 +1,106 @@
+package GameSpy
+
+import (
+	"context"
+	"time"
+)
+
+// TheaterConfig configures DialTheater. The defaults are those of the
+// Heroes client.
+type TheaterConfig struct {
+	DialConfig
+	Conn TheaterConnRequest
+	// LKey is the session key of the FESL login. USER is sent after CONN
+	// if it's set.
+	LKey string
+	Name string
+	MAC  string
+	// Handler gets every message which isn't an answer, PING is answered
+	// before
+	Handler func(*CommandFESL)
+}
+
+// TheaterConnRequest is the CONN of a client
+type TheaterConnRequest struct {
+	Prod       string
+	Vers       string
+	Plat       string
+	Locale     string
+	SDKVersion string
+}
+
+// TheaterConnResponse is the answer to CONN
+type TheaterConnResponse struct {
+	Time            string
+	ActivityTimeout time.Duration
+	Prot            string
+}
+
+// TheaterConn is the client side of a Theater connection
+type TheaterConn struct {
+	*feslConn
+
+	// Conn and User hold the answers of the handshake
+	Conn *TheaterConnResponse
+	User string
+}
+
+// DialTheater connects to a Theater server and sends CONN, followed by
+// USER if the config holds a session key
+func DialTheater(ctx context.Context, address string, config TheaterConfig) (*TheaterConn, error) {
+	conn, err := config.dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	theater := &TheaterConn{feslConn: newFESLConn(conn, config.DialConfig, true, config.Handler)}
+
+	if theater.Conn, err = theater.SendConn(ctx, config.Conn); err != nil {
+		theater.Close()
+		return nil, err
+	}
+	if config.LKey != "" {
+		if theater.User, err = theater.SendUser(ctx, config.LKey, config.Name, config.MAC); err != nil {
+			theater.Close()
+			return nil, err
+		}
+	}
+	return theater, nil
+}
+
+// SendConn sends CONN, empty fields take the defaults of the Heroes
+// client
+func (theater *TheaterConn) SendConn(ctx context.Context, request TheaterConnRequest) (*TheaterConnResponse, error) {
+	answer, err := theater.Call(ctx, "CONN", map[string]string{
+		"PROT":       "2",
+		"PROD":       or(request.Prod, "bfwest-pc"),
+		"VERS":       or(request.Vers, "1.0"),
+		"PLAT":       or(request.Plat, "PC"),
+		"LOCALE":     or(request.Locale, "en_US"),
+		"SDKVERSION": or(request.SDKVersion, "5.0.0.0.0"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TheaterConnResponse{
+		Time:            answer.Message["TIME"],
+		ActivityTimeout: time.Duration(atoi(answer.Message["activityTimeoutSecs"])) * time.Second,
+		Prot:            answer.Message["PROT"],
+	}, nil
+}
+
+// SendUser logs in with the session key of FESL and returns the name the
+// server knows the user by
+func (theater *TheaterConn) SendUser(ctx context.Context, lkey string, name string, mac string) (string, error) {
+	answer, err := theater.Call(ctx, "USER", map[string]string{
+		"MAC":  or(mac, "$000000000000"),
+		"SKU":  "125170",
+		"LKEY": lkey,
+		"NAME": name,
+		"CID":  "",
+	})
+	if err != nil {
+		return "", err
+	}
+	return answer.Message["NAME"], nil
+}
@@ -0,0 +1,217 @@
+package GameSpy_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+// pipeDialer dials the listener whatever the address
+func pipeDialer(listener *GameSpy.PipeListener) GameSpy.DialConfig {
+	return GameSpy.DialConfig{
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return listener.Dial(ctx)
+		},
+	}
+}
+
+// startServer runs a server handing every event to handle
+func startServer(t *testing.T, framer GameSpy.Framer, handle func(event GameSpy.SocketEvent)) *GameSpy.PipeListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	listener := GameSpy.NewPipeListener()
+	server := &GameSpy.Server{Name: "Dial", Framer: framer}
+	events, err := server.Listen(ctx, listener)
+	if err != nil {
+		t.Fatalf("Listen threw an error: %v", err)
+	}
+	go func() {
+		for event := range events {
+			handle(event)
+		}
+	}()
+	return listener
+}
+
+func TestDialFESL(t *testing.T) {
+	listener, _ := startLoginServer(t, "", "Heroes")
+
+	fesl, err := GameSpy.DialFESL(context.Background(), "fesl", GameSpy.FESLConfig{
+		DialConfig: pipeDialer(listener),
+		User:       "heroes@example.com",
+		Password:   "secret",
+		Persona:    "Heroes",
+	})
+	if err != nil {
+		t.Fatalf("DialFESL threw an error: %v", err)
+	}
+	defer fesl.Close()
+
+	if fesl.Hello.TheaterPort != 18275 || fesl.Hello.SubDomain != "bfwest-dedicated" {
+		t.Errorf("Hello was incorrect, got: %+v.", fesl.Hello)
+	}
+	if fesl.Login.DisplayName != "Heroes" || fesl.Login.Nuid != "heroes@example.com" || fesl.Login.LKey == "" {
+		t.Errorf("Login was incorrect, got: %+v.", fesl.Login)
+	}
+	if fesl.Persona.ProfileID != 2 || fesl.Persona.UserID != 1 {
+		t.Errorf("Persona was incorrect, got: %+v.", fesl.Persona)
+	}
+
+	_, err = GameSpy.DialFESL(context.Background(), "fesl", GameSpy.FESLConfig{
+		DialConfig: pipeDialer(listener),
+		User:       "heroes@example.com",
+		Password:   "wrong",
+	})
+	var feslErr *GameSpy.FESLError
+	if !errors.As(err, &feslErr) || feslErr.Code != 122 || feslErr.TXN != "NuLogin" {
+		t.Errorf("DialFESL threw the wrong error: %v", err)
+	}
+}
+
+func TestNuGetPersonas(t *testing.T) {
+	counts := make(chan string, 3)
+	listener := startServer(t, GameSpy.FESLFramer{}, func(event GameSpy.SocketEvent) {
+		command, ok := event.Data.(GameSpy.EventClientFESLCommand)
+		if !ok || event.Name == "client.command" {
+			return
+		}
+		client, message := command.Client, command.Command
+
+		switch event.Name {
+		case "client.command.fsys.Hello":
+			client.WriteFESL("fsys", map[string]string{"TXN": "Hello", "theaterPort": "18275"}, message.PayloadID)
+		case "client.command.acct.NuGetPersonas":
+			client.WriteFESL("acct", map[string]string{
+				"TXN":         "NuGetPersonas",
+				"personas.[]": <-counts,
+				"personas.0":  "Heroes",
+				"personas.1":  "Villains",
+			}, message.PayloadID)
+		}
+	})
+
+	fesl, err := GameSpy.DialFESL(context.Background(), "fesl", GameSpy.FESLConfig{DialConfig: pipeDialer(listener)})
+	if err != nil {
+		t.Fatalf("DialFESL threw an error: %v", err)
+	}
+	defer fesl.Close()
+
+	counts <- "2"
+	if personas, err := fesl.NuGetPersonas(context.Background()); err != nil || !reflect.DeepEqual(personas, []string{"Heroes", "Villains"}) {
+		t.Errorf("NuGetPersonas was incorrect, got: %q, %v.", personas, err)
+	}
+	for _, count := range []string{"-1", "2000000000"} {
+		counts <- count
+		if _, err := fesl.NuGetPersonas(context.Background()); !errors.Is(err, GameSpy.ErrInvalidFrame) {
+			t.Errorf("NuGetPersonas of %s personas threw the wrong error: %v", count, err)
+		}
+	}
+}
+
+func TestDialTheater(t *testing.T) {
+	pings := make(chan string, 1)
+	listener := startServer(t, GameSpy.FESLFramer{}, func(event GameSpy.SocketEvent) {
+		command, ok := event.Data.(GameSpy.EventClientFESLCommand)
+		if !ok || event.Name == "client.command" {
+			return
+		}
+		client, message := command.Client, command.Command
+
+		switch message.Query {
+		case "CONN":
+			// The server pings before it answers
+			client.WriteFESL("PING", map[string]string{"TID": "0"}, 0)
+			client.WriteFESL("CONN", map[string]string{"TID": message.Message["TID"], "TIME": "1700000000", "activityTimeoutSecs": "240", "PROT": "2"}, 0)
+		case "USER":
+			client.WriteFESL("USER", map[string]string{"TID": message.Message["TID"], "NAME": message.Message["NAME"]}, 0)
+		case "PING":
+			pings <- message.Message["TID"]
+		}
+	})
+
+	theater, err := GameSpy.DialTheater(context.Background(), "theater", GameSpy.TheaterConfig{
+		DialConfig: pipeDialer(listener),
+		LKey:       "lkey",
+		Name:       "Heroes",
+	})
+	if err != nil {
+		t.Fatalf("DialTheater threw an error: %v", err)
+	}
+	defer theater.Close()
+
+	if theater.Conn.Time != "1700000000" || theater.Conn.ActivityTimeout.Seconds() != 240 || theater.User != "Heroes" {
+		t.Errorf("Handshake was incorrect, got: %+v, %q.", theater.Conn, theater.User)
+	}
+	if tid := <-pings; tid != "0" {
+		t.Errorf("PING was answered with TID %q.", tid)
+	}
+}
+
+func TestDialGPCM(t *testing.T) {
+	const serverChallenge = "ABCDEFGHIJ"
+	password := GameSpy.Hash("secret")
+
+	listener := startServer(t, GameSpy.GameSpyFramer{}, func(event GameSpy.SocketEvent) {
+		if newClient, ok := event.Data.(GameSpy.EventNewClient); ok {
+			newClient.Client.Write("\\lc\\1\\challenge\\" + serverChallenge + "\\id\\1\\final\\")
+			return
+		}
+		command, ok := event.Data.(GameSpy.EventClientCommand)
+		if !ok || event.Name == "client.command" {
+			return
+		}
+		client, message := command.Client, command.Command.Message
+
+		switch command.Command.Query {
+		case "login":
+			user, challenge := message["uniquenick"], message["challenge"]
+			if message["response"] != GameSpy.GPCMProof(password, user, challenge, serverChallenge) {
+				client.WriteError("260", "The password provided is incorrect.")
+				return
+			}
+			client.Write("\\lc\\2\\sesskey\\1234\\proof\\" + GameSpy.GPCMProof(password, user, serverChallenge, challenge) +
+				"\\userid\\1\\profileid\\2\\uniquenick\\" + user + "\\lt\\ticket\\id\\1\\final\\")
+		case "getprofile":
+			// A keep alive in between must not get in the way
+			client.Write("\\ka\\\\final\\")
+			client.Write("\\pi\\\\profileid\\" + message["profileid"] + "\\nick\\hero\\uniquenick\\hero\\id\\" + message["id"] + "\\final\\")
+		}
+	})
+
+	gpcm, err := GameSpy.DialGPCM(context.Background(), "gpcm", GameSpy.GPCMConfig{
+		DialConfig: pipeDialer(listener),
+		User:       "hero",
+		Password:   "secret",
+	})
+	if err != nil {
+		t.Fatalf("DialGPCM threw an error: %v", err)
+	}
+	defer gpcm.Close()
+
+	if gpcm.Login.SessionKey != "1234" || gpcm.Login.ProfileID != 2 || gpcm.Login.LoginTicket != "ticket" {
+		t.Errorf("Login was incorrect, got: %+v.", gpcm.Login)
+	}
+
+	profile, err := gpcm.GetProfile(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("GetProfile threw an error: %v", err)
+	}
+	if profile.ProfileID != 2 || profile.UniqueNick != "hero" {
+		t.Errorf("Profile was incorrect, got: %+v.", profile)
+	}
+
+	_, err = GameSpy.DialGPCM(context.Background(), "gpcm", GameSpy.GPCMConfig{
+		DialConfig: pipeDialer(listener),
+		User:       "hero",
+		Password:   "wrong",
+	})
+	var gpcmErr *GameSpy.GPCMError
+	if !errors.As(err, &gpcmErr) || gpcmErr.Code != 260 || !gpcmErr.Fatal {
+		t.Errorf("DialGPCM threw the wrong error: %v", err)
+	}
+}
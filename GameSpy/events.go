@@ -0,0 +1,250 @@
+package GameSpy
+
+import (
+	"net"
+	"runtime/debug"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// EventKind identifies what kind of event a socket fired
+type EventKind string
+
+// Event kinds fired by Socket, SocketTLS and SocketUDP
+const (
+	KindClose          EventKind = "close"
+	KindError          EventKind = "error"
+	KindNewClient      EventKind = "newClient"
+	KindClientClose    EventKind = "client.close"
+	KindClientError    EventKind = "client.error"
+	KindClientCommand  EventKind = "client.command"
+	KindClientData     EventKind = "client.data"
+	KindClientPanic    EventKind = "client.panic"
+	KindCommand        EventKind = "command"
+	KindData           EventKind = "data"
+	KindSessionNew     EventKind = "session.new"
+	KindSessionExpired EventKind = "session.expired"
+)
+
+// Event is implemented by every payload a socket fires
+type Event interface {
+	Kind() EventKind
+}
+
+// SocketEvent is the generic struct for events
+// by this socket
+//
+// Name is the event kind, for commands followed by the query, e.g.
+// "client.command.login". FESL commands are named after their type and
+// transaction, e.g. "client.command.acct.NuLogin". Data always holds the
+// payload matching the kind:
+//
+//	Name                Data
+//	close               EventClose
+//	error               EventError
+//	newClient           EventNewClient
+//	client.close        EventClientClose
+//	client.error        EventClientError
+//	client.command      EventClientCommand, EventClientFESLCommand
+//	client.command.*    EventClientCommand, EventClientFESLCommand
+//	client.data         EventClientData
+//	client.panic        EventClientPanic
+type SocketEvent struct {
+	Name string
+	Data Event
+}
+
+// Kind returns the kind of the payload
+func (event SocketEvent) Kind() EventKind {
+	if event.Data == nil {
+		return EventKind(event.Name)
+	}
+	return event.Data.Kind()
+}
+
+// SocketUDPEvent is the generic struct for events by a SocketUDP
+//
+//	Name                Data
+//	close               EventClose
+//	error               EventUDPError
+//	data                EventUDPData
+//	command             EventUDPCommand, EventUDPFESLCommand
+//	command.*           EventUDPCommand, EventUDPFESLCommand
+//	session.new         EventSessionNew
+//	session.expired     EventSessionExpired
+type SocketUDPEvent struct {
+	Name string
+	Addr *net.UDPAddr
+	Data Event
+}
+
+// Kind returns the kind of the payload
+func (event SocketUDPEvent) Kind() EventKind {
+	if event.Data == nil {
+		return EventKind(event.Name)
+	}
+	return event.Data.Kind()
+}
+
+type EventClose struct{}
+
+type EventError struct {
+	Error error
+}
+type EventNewClient struct {
+	Client *Client
+}
+
+type EventClientClose struct {
+	Client *Client
+}
+type EventClientError struct {
+	Client *Client
+	Error  error
+}
+type EventClientCommand struct {
+	Client  *Client
+	Command *Command
+}
+type EventClientFESLCommand struct {
+	Client  *Client
+	Command *CommandFESL
+}
+type EventClientData struct {
+	Client *Client
+	Data   string
+}
+
+// EventClientPanic has no Client for panics handling a datagram
+type EventClientPanic struct {
+	Client *Client
+	Report *CrashReport
+}
+
+// The TLS events are the same as the ones of any other client, since a
+// ClientTLS is a Client
+type (
+	EventNewClientTLS     = EventNewClient
+	EventClientTLSClose   = EventClientClose
+	EventClientTLSError   = EventClientError
+	EventClientTLSCommand = EventClientFESLCommand
+	EventClientTLSData    = EventClientData
+)
+
+type EventUDPError struct {
+	Addr  *net.UDPAddr
+	Error error
+}
+type EventUDPData struct {
+	Addr    *net.UDPAddr
+	Session *Session
+	Data    string
+}
+type EventUDPCommand struct {
+	Addr    *net.UDPAddr
+	Session *Session
+	Command *Command
+}
+type EventUDPFESLCommand struct {
+	Addr    *net.UDPAddr
+	Session *Session
+	Command *CommandFESL
+}
+type EventSessionNew struct {
+	Addr    *net.UDPAddr
+	Session *Session
+}
+type EventSessionExpired struct {
+	Addr    *net.UDPAddr
+	Session *Session
+}
+
+func (EventClose) Kind() EventKind             { return KindClose }
+func (EventError) Kind() EventKind             { return KindError }
+func (EventNewClient) Kind() EventKind         { return KindNewClient }
+func (EventClientClose) Kind() EventKind       { return KindClientClose }
+func (EventClientError) Kind() EventKind       { return KindClientError }
+func (EventClientCommand) Kind() EventKind     { return KindClientCommand }
+func (EventClientFESLCommand) Kind() EventKind { return KindClientCommand }
+func (EventClientData) Kind() EventKind        { return KindClientData }
+func (EventClientPanic) Kind() EventKind       { return KindClientPanic }
+func (EventUDPError) Kind() EventKind          { return KindError }
+func (EventUDPData) Kind() EventKind           { return KindData }
+func (EventUDPCommand) Kind() EventKind        { return KindCommand }
+func (EventUDPFESLCommand) Kind() EventKind    { return KindCommand }
+func (EventSessionNew) Kind() EventKind        { return KindSessionNew }
+func (EventSessionExpired) Kind() EventKind    { return KindSessionExpired }
+
+// Payload returns the payload of event as T. The second return value
+// reports whether the payload actually was a T.
+func Payload[T Event](event Event) (T, bool) {
+	if socketEvent, ok := event.(SocketEvent); ok {
+		event = socketEvent.Data
+	}
+	if udpEvent, ok := event.(SocketUDPEvent); ok {
+		event = udpEvent.Data
+	}
+	payload, ok := event.(T)
+	return payload, ok
+}
+
+// Handlers dispatches events to handler functions by payload type
+//
+//	handlers := new(GameSpy.Handlers)
+//	GameSpy.On(handlers, func(event GameSpy.EventClientCommand) {
+//		...
+//	})
+//	for event := range eventsChannel {
+//		handlers.Dispatch(event)
+//	}
+type Handlers struct {
+	handlers []func(Event) bool
+}
+
+// On registers handler for every event with a payload of type T
+func On[T Event](handlers *Handlers, handler func(T)) {
+	handlers.handlers = append(handlers.handlers, func(event Event) bool {
+		payload, ok := Payload[T](event)
+		if ok {
+			handler(payload)
+		}
+		return ok
+	})
+}
+
+// Dispatch calls every handler registered for the payload of event and
+// reports whether there was any
+//
+// A panicking handler doesn't take the server down. If the event belongs
+// to a client, that client gets a crash report and is closed.
+func (handlers *Handlers) Dispatch(event Event) bool {
+	handled := false
+	for _, handler := range handlers.handlers {
+		if handlers.call(handler, event) {
+			handled = true
+		}
+	}
+	return handled
+}
+
+func (handlers *Handlers) call(handler func(Event) bool, event Event) (handled bool) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			handled = true
+			if client, ok := eventClient(event); ok {
+				client.panicked(client.crash("handler of "+eventName(event), recovered, &client.State))
+				return
+			}
+			log.Errorln("Panic in handler of", eventName(event), recovered, string(debug.Stack()))
+		}
+	}()
+	return handler(event)
+}
+
+// eventName returns the name of event, or its kind if it has none
+func eventName(event Event) string {
+	if named, ok := event.(NamedEvent); ok {
+		return named.EventName()
+	}
+	return string(event.Kind())
+}
@@ -0,0 +1,62 @@
+package GameSpy_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func TestHandlersDispatch(t *testing.T) {
+	var commands, errs int
+
+	handlers := new(GameSpy.Handlers)
+	GameSpy.On(handlers, func(event GameSpy.EventClientCommand) {
+		if event.Command.Query != "login" {
+			t.Errorf("Dispatch passed the wrong command, got: %s, want: %s.", event.Command.Query, "login")
+		}
+		commands++
+	})
+	GameSpy.On(handlers, func(event GameSpy.EventClientError) {
+		errs++
+	})
+
+	handled := handlers.Dispatch(GameSpy.SocketEvent{
+		Name: "client.command.login",
+		Data: GameSpy.EventClientCommand{
+			Command: &GameSpy.Command{Query: "login"},
+		},
+	})
+	if !handled || commands != 1 || errs != 0 {
+		t.Errorf("Dispatch was incorrect, got: handled %v, %d commands, %d errors.", handled, commands, errs)
+	}
+
+	handled = handlers.Dispatch(GameSpy.EventClientError{Error: errors.New("test")})
+	if !handled || errs != 1 {
+		t.Errorf("Dispatch was incorrect, got: handled %v, %d errors.", handled, errs)
+	}
+
+	if handlers.Dispatch(GameSpy.SocketEvent{Name: "close", Data: GameSpy.EventClose{}}) {
+		t.Errorf("Dispatch handled an event without handlers.")
+	}
+}
+
+func TestPayload(t *testing.T) {
+	event := GameSpy.SocketEvent{
+		Name: "client.data",
+		Data: GameSpy.EventClientData{Data: "\\ka\\\\final\\"},
+	}
+
+	if event.Kind() != GameSpy.KindClientData {
+		t.Errorf("Kind was incorrect, got: %s, want: %s.", event.Kind(), GameSpy.KindClientData)
+	}
+
+	data, ok := GameSpy.Payload[GameSpy.EventClientData](event)
+	if !ok || data.Data != "\\ka\\\\final\\" {
+		t.Errorf("Payload was incorrect, got: %v, %v.", data, ok)
+	}
+
+	if _, ok := GameSpy.Payload[GameSpy.EventClientClose](event); ok {
+		t.Errorf("Payload returned a payload of the wrong type.")
+	}
+}
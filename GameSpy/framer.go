@@ -0,0 +1,382 @@
+package GameSpy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrFrameTooLarge is returned by framers for frames above their limit
+	ErrFrameTooLarge = errors.New("frame is too large")
+	// ErrInvalidFrame is returned by framers for data that can never
+	// become a valid frame
+	ErrInvalidFrame = errors.New("frame is invalid")
+)
+
+// Framer cuts the traffic of a client into frames and turns them into
+// messages. Every protocol is a Framer plus handlers for its events.
+type Framer interface {
+	// Split cuts the first frame off data. It returns how many bytes the
+	// frame took and the frame itself, or 0 and nil if data does not hold
+	// a complete frame yet. An error means data can never become valid.
+	Split(data []byte) (advance int, frame []byte, err error)
+	// Decode parses a single frame. A nil message without an error means
+	// there was nothing in the frame, e.g. an empty GameSpy command.
+	Decode(frame []byte) (Message, error)
+	// Encode prepares an outgoing frame for the wire
+	Encode(frame []byte) []byte
+}
+
+// Message is a decoded frame, either a *Command or a *CommandFESL
+type Message interface {
+	eventName() string
+}
+
+// eventName returns the name events of this command are fired with
+func (command *Command) eventName() string {
+	return command.Query
+}
+
+// GameSpyFramer splits the plain-text GameSpy protocol at \final\
+type GameSpyFramer struct {
+	// MaxFrameSize is the longest command accepted. 0 means 4096.
+	MaxFrameSize int
+	// Limits overrides DefaultLimits["gamespy"]
+	Limits Limits
+}
+
+var gamespyFinal = []byte("\\final\\")
+
+// Split cuts the first command off data
+func (framer GameSpyFramer) Split(data []byte) (int, []byte, error) {
+	maxFrameSize := framer.MaxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = 4096
+	}
+
+	i := bytes.Index(data, gamespyFinal)
+	if i == -1 {
+		if len(data) > maxFrameSize {
+			return 0, nil, ErrFrameTooLarge
+		}
+		return 0, nil, nil
+	}
+
+	advance := i + len(gamespyFinal)
+	if advance > maxFrameSize {
+		return 0, nil, ErrFrameTooLarge
+	}
+	return advance, data[:advance], nil
+}
+
+// Decode parses a command
+func (framer GameSpyFramer) Decode(frame []byte) (Message, error) {
+	command := strings.TrimSpace(strings.TrimSuffix(string(frame), string(gamespyFinal)))
+	if len(command) == 0 {
+		return nil, nil
+	}
+	message, err := ProcessCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate("gamespy", limitsFor("gamespy", framer.Limits), message.Query, message.Message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Encode returns frame as it is
+func (framer GameSpyFramer) Encode(frame []byte) []byte {
+	return frame
+}
+
+// FESLFramer splits FESL and Theater traffic. Every frame starts with a
+// 12 byte header: the type, an id and the length of the whole frame.
+type FESLFramer struct {
+	// MaxFrameSize is the largest frame accepted. 0 means 65536.
+	MaxFrameSize int
+	// Limits overrides DefaultLimits["fesl"]
+	Limits Limits
+}
+
+// Split cuts the first frame off data
+func (framer FESLFramer) Split(data []byte) (int, []byte, error) {
+	maxFrameSize := framer.MaxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = 65536
+	}
+
+	if len(data) < 12 {
+		return 0, nil, nil
+	}
+
+	length := binary.BigEndian.Uint32(data[8:12])
+	if length < 12 {
+		return 0, nil, ErrInvalidFrame
+	}
+	if length > uint32(maxFrameSize) {
+		return 0, nil, ErrFrameTooLarge
+	}
+	if uint32(len(data)) < length {
+		return 0, nil, nil
+	}
+	return int(length), data[:length], nil
+}
+
+// Decode parses a frame
+func (framer FESLFramer) Decode(frame []byte) (Message, error) {
+	if len(frame) < 12 {
+		return nil, ErrInvalidFrame
+	}
+
+	command := &CommandFESL{
+		Query:     string(frame[:4]),
+		PayloadID: binary.BigEndian.Uint32(frame[4:8]),
+		Message:   ProcessFESL(string(frame[12:])),
+	}
+	if !validFESLType(command.Query) {
+		return nil, &ValidationError{Protocol: "fesl", Key: command.Query, Reason: "unprintable type"}
+	}
+	if err := validate("fesl", limitsFor("fesl", framer.Limits), command.Query, command.Message); err != nil {
+		return nil, err
+	}
+	return command, nil
+}
+
+// Encode returns frame as it is
+func (framer FESLFramer) Encode(frame []byte) []byte {
+	return frame
+}
+
+// EncodeFESL builds a FESL frame
+func EncodeFESL(msgType string, msg map[string]string, msgType2 uint32) []byte {
+	var buf bytes.Buffer
+
+	payloadEncoded := SerializeFESL(msg)
+
+	buf.Write([]byte(msgType))
+	binary.Write(&buf, binary.BigEndian, msgType2)
+	binary.Write(&buf, binary.BigEndian, uint32(len(payloadEncoded)+12))
+	buf.Write([]byte(payloadEncoded))
+
+	return buf.Bytes()
+}
+
+// XORFramer wraps another framer into gamespy's XOR, as used by the UDP
+// services. It works on datagrams, every datagram is a single frame.
+type XORFramer struct {
+	Framer Framer
+	// Key is XOR'ed over every datagram. nil means "gamespy".
+	Key []byte
+}
+
+var gamespyXORKey = []byte("gamespy")
+
+// Split decodes the whole datagram
+func (framer XORFramer) Split(data []byte) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	return len(data), xor(data, framer.key()), nil
+}
+
+// Decode hands the decoded datagram to the wrapped framer
+func (framer XORFramer) Decode(frame []byte) (Message, error) {
+	return framer.Framer.Decode(frame)
+}
+
+// Encode encodes frame with the wrapped framer and XOR's it
+func (framer XORFramer) Encode(frame []byte) []byte {
+	return xor(framer.Framer.Encode(frame), framer.key())
+}
+
+func (framer XORFramer) key() []byte {
+	if len(framer.Key) == 0 {
+		return gamespyXORKey
+	}
+	return framer.Key
+}
+
+// xor applies key to a, repeating it as often as needed
+func xor(a []byte, key []byte) []byte {
+	res := make([]byte, len(a))
+	for i := range a {
+		res[i] = a[i] ^ key[i%len(key)]
+	}
+	return res
+}
+
+// QR2Framer decodes the binary query and reporting protocol of the
+// master server on UDP port 27900. Every datagram is a single frame.
+// Clients send the packet type and the instance key in front of every
+// packet, the master server puts 0xFE 0xFD before them.
+type QR2Framer struct {
+	// Limits overrides DefaultLimits["qr2"]
+	Limits Limits
+}
+
+// qr2Types are the names of the QR2 packet types
+var qr2Types = []string{
+	0x00: "query",
+	0x01: "challenge",
+	0x02: "echo",
+	0x03: "heartbeat",
+	0x04: "adderror",
+	0x05: "echo_response",
+	0x06: "client_message",
+	0x07: "client_message_ack",
+	0x08: "keepalive",
+	0x09: "available",
+	0x0A: "client_registered",
+}
+
+// Split takes the whole datagram
+func (framer QR2Framer) Split(data []byte) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	return len(data), data, nil
+}
+
+// Decode parses a packet into a Command. The query is the name of the
+// packet type, the message holds "from", client or server, and the
+// instance "key". The keys of heartbeats are added as they are, with
+// the player and team sections after them in "data" as hex. Challenges,
+// errors and availability replies hold their "value", anything else
+// its payload in "data".
+func (framer QR2Framer) Decode(frame []byte) (Message, error) {
+	from := "client"
+	if len(frame) >= 2 && frame[0] == 0xFE && frame[1] == 0xFD {
+		from = "server"
+		frame = frame[2:]
+	}
+	if len(frame) < 5 {
+		return nil, fmt.Errorf("%w: QR2 packet of %d bytes", ErrInvalidFrame, len(frame))
+	}
+	if int(frame[0]) >= len(qr2Types) {
+		return nil, fmt.Errorf("%w: unknown QR2 packet type %#02x", ErrInvalidFrame, frame[0])
+	}
+
+	command := &Command{
+		Query: qr2Types[frame[0]],
+		Message: map[string]string{
+			"from": from,
+			"key":  fmt.Sprintf("%#08x", binary.BigEndian.Uint32(frame[1:5])),
+		},
+	}
+	payload := frame[5:]
+
+	switch {
+	case frame[0] == 0x03 && from == "client":
+		for len(payload) > 0 {
+			key, rest, ok := bytes.Cut(payload, []byte{0})
+			if !ok || len(key) == 0 {
+				payload = rest
+				break
+			}
+			var value []byte
+			value, payload, _ = bytes.Cut(rest, []byte{0})
+			command.Message[string(key)] = string(value)
+		}
+	case frame[0] == 0x01, frame[0] == 0x04, frame[0] == 0x09:
+		value, rest, _ := bytes.Cut(payload, []byte{0})
+		command.Message["value"] = string(value)
+		payload = rest
+	}
+	if len(payload) > 0 {
+		command.Message["data"] = hex.EncodeToString(payload)
+	}
+
+	if err := validate("qr2", limitsFor("qr2", framer.Limits), command.Query, command.Message); err != nil {
+		return nil, err
+	}
+	return command, nil
+}
+
+// Encode returns frame as it is
+func (framer QR2Framer) Encode(frame []byte) []byte {
+	return frame
+}
+
+// LineFramer splits line-based protocols like IRC, used by the GameSpy
+// chat. Lines end with \n, an optional \r before is dropped.
+type LineFramer struct {
+	// MaxLineLength is the longest line accepted. 0 means 512, the
+	// limit of IRC.
+	MaxLineLength int
+	// Limits overrides DefaultLimits["irc"]
+	Limits Limits
+}
+
+// Split cuts the first line off data
+func (framer LineFramer) Split(data []byte) (int, []byte, error) {
+	maxLineLength := framer.MaxLineLength
+	if maxLineLength <= 0 {
+		maxLineLength = 512
+	}
+
+	i := bytes.IndexByte(data, '\n')
+	if i == -1 {
+		if len(data) > maxLineLength {
+			return 0, nil, ErrFrameTooLarge
+		}
+		return 0, nil, nil
+	}
+	if i+1 > maxLineLength {
+		return 0, nil, ErrFrameTooLarge
+	}
+	return i + 1, data[:i+1], nil
+}
+
+// Decode parses an IRC line into a Command. The query is the IRC
+// command, the message holds its "prefix", the "params" and the
+// "trailing" parameter after the colon.
+func (framer LineFramer) Decode(frame []byte) (Message, error) {
+	line := strings.TrimRight(string(frame), "\r\n")
+	if len(strings.TrimSpace(line)) == 0 {
+		return nil, nil
+	}
+
+	command := &Command{
+		Message: make(map[string]string),
+	}
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i == -1 {
+			return nil, fmt.Errorf("%w: line holds only a prefix", ErrInvalidFrame)
+		}
+		command.Message["prefix"] = line[1:i]
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+
+	if i := strings.Index(line, " :"); i != -1 {
+		command.Message["trailing"] = line[i+2:]
+		line = line[:i]
+	}
+
+	fields := strings.SplitN(line, " ", 2)
+	command.Query = fields[0]
+	command.Message["__query"] = fields[0]
+	if len(fields) == 2 {
+		command.Message["params"] = strings.TrimSpace(fields[1])
+	}
+
+	if err := validate("irc", limitsFor("irc", framer.Limits), command.Query, command.Message); err != nil {
+		return nil, err
+	}
+	return command, nil
+}
+
+// Encode appends the line ending if it's missing
+func (framer LineFramer) Encode(frame []byte) []byte {
+	if bytes.HasSuffix(frame, []byte("\r\n")) {
+		return frame
+	}
+	line := bytes.TrimRight(frame, "\r\n")
+	return append(append(make([]byte, 0, len(line)+2), line...), '\r', '\n')
+}
@@ -0,0 +1,152 @@
+package GameSpy_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+// splitAll runs data through framer like a client does
+func splitAll(t *testing.T, framer GameSpy.Framer, data []byte) ([][]byte, []byte) {
+	var frames [][]byte
+	for len(data) > 0 {
+		advance, frame, err := framer.Split(data)
+		if err != nil {
+			t.Fatalf("Split threw an error: %v", err)
+		}
+		if advance == 0 {
+			break
+		}
+		frames = append(frames, frame)
+		data = data[advance:]
+	}
+	return frames, data
+}
+
+func TestGameSpyFramer(t *testing.T) {
+	framer := GameSpy.GameSpyFramer{}
+
+	frames, rest := splitAll(t, framer, []byte("\\ka\\\\final\\\\login\\\\uniquenick\\test\\final\\\\status\\1"))
+	if len(frames) != 2 || string(rest) != "\\status\\1" {
+		t.Fatalf("Split was incorrect, got: %q, rest: %q.", frames, rest)
+	}
+
+	message, err := framer.Decode(frames[1])
+	if err != nil {
+		t.Fatalf("Decode threw an error: %v", err)
+	}
+	command := message.(*GameSpy.Command)
+	if command.Query != "login" || command.Message["uniquenick"] != "test" {
+		t.Errorf("Decode was incorrect, got: %+v.", command)
+	}
+
+	if message, _ := framer.Decode([]byte("\\final\\")); message != nil {
+		t.Errorf("Decode of an empty command returned a message: %+v.", message)
+	}
+
+	if _, _, err := (GameSpy.GameSpyFramer{MaxFrameSize: 8}).Split([]byte("\\ka\\xxxxxxx")); err != GameSpy.ErrFrameTooLarge {
+		t.Errorf("Split of a too large frame was incorrect, got: %v, want: %v.", err, GameSpy.ErrFrameTooLarge)
+	}
+}
+
+func TestFESLFramer(t *testing.T) {
+	framer := GameSpy.FESLFramer{}
+	data := append(GameSpy.EncodeFESL("fsys", map[string]string{"TXN": "Hello"}, 0xC0000001),
+		GameSpy.EncodeFESL("acct", map[string]string{"TXN": "NuLogin"}, 0xC0000002)...)
+
+	// A partial frame has to wait for more data
+	if advance, _, err := framer.Split(data[:20]); advance != 0 || err != nil {
+		t.Errorf("Split of a partial frame was incorrect, got: %d, %v.", advance, err)
+	}
+
+	frames, rest := splitAll(t, framer, data)
+	if len(frames) != 2 || len(rest) != 0 {
+		t.Fatalf("Split was incorrect, got: %q, rest: %q.", frames, rest)
+	}
+
+	message, err := framer.Decode(frames[1])
+	if err != nil {
+		t.Fatalf("Decode threw an error: %v", err)
+	}
+	command := message.(*GameSpy.CommandFESL)
+	if command.Query != "acct" || command.PayloadID != 0xC0000002 || command.Message["TXN"] != "NuLogin" {
+		t.Errorf("Decode was incorrect, got: %+v.", command)
+	}
+
+	if _, _, err := framer.Split([]byte("fsys\x00\x00\x00\x01\x00\x00\x00\x04")); err != GameSpy.ErrInvalidFrame {
+		t.Errorf("Split of a frame shorter than its header was incorrect, got: %v, want: %v.", err, GameSpy.ErrInvalidFrame)
+	}
+}
+
+func TestXORFramer(t *testing.T) {
+	framer := GameSpy.XORFramer{Framer: GameSpy.GameSpyFramer{}}
+	encoded := framer.Encode([]byte("\\heartbeat\\1\\final\\"))
+
+	advance, frame, err := framer.Split(encoded)
+	if err != nil || advance != len(encoded) || string(frame) != "\\heartbeat\\1\\final\\" {
+		t.Fatalf("Split was incorrect, got: %d, %q, %v.", advance, frame, err)
+	}
+
+	message, _ := framer.Decode(frame)
+	if message.(*GameSpy.Command).Query != "heartbeat" {
+		t.Errorf("Decode was incorrect, got: %+v.", message)
+	}
+}
+
+func TestQR2Framer(t *testing.T) {
+	framer := GameSpy.QR2Framer{}
+	heartbeat := append([]byte{0x03, 0x12, 0x34, 0x56, 0x78}, "gamename\x00heroes\x00hostport\x00\x00\x00\x01"...)
+
+	advance, frame, err := framer.Split(heartbeat)
+	if err != nil || advance != len(heartbeat) || !bytes.Equal(frame, heartbeat) {
+		t.Fatalf("Split was incorrect, got: %d, %q, %v.", advance, frame, err)
+	}
+
+	message, err := framer.Decode(frame)
+	want := map[string]string{"from": "client", "key": "0x12345678", "gamename": "heroes", "hostport": "", "data": "01"}
+	if err != nil || message.(*GameSpy.Command).Query != "heartbeat" || !reflect.DeepEqual(message.(*GameSpy.Command).Message, want) {
+		t.Errorf("Decode of a heartbeat was incorrect, got: %+v, %v.", message, err)
+	}
+
+	message, err = framer.Decode(append([]byte{0xFE, 0xFD, 0x01, 0x12, 0x34, 0x56, 0x78}, "Dw8Ch2\x00"...))
+	want = map[string]string{"from": "server", "key": "0x12345678", "value": "Dw8Ch2"}
+	if err != nil || message.(*GameSpy.Command).Query != "challenge" || !reflect.DeepEqual(message.(*GameSpy.Command).Message, want) {
+		t.Errorf("Decode of a challenge was incorrect, got: %+v, %v.", message, err)
+	}
+
+	for _, invalid := range [][]byte{{0x03, 0x12}, {0x42, 0x12, 0x34, 0x56, 0x78}, {0xFE, 0xFD, 0x01}} {
+		if _, err := framer.Decode(invalid); !errors.Is(err, GameSpy.ErrInvalidFrame) {
+			t.Errorf("Decode of %x threw the wrong error: %v", invalid, err)
+		}
+	}
+}
+
+func TestLineFramer(t *testing.T) {
+	framer := GameSpy.LineFramer{}
+
+	frames, rest := splitAll(t, framer, []byte("NICK test\r\n:test!u@h PRIVMSG #gsp!bfheroes :hello there\r\nPING"))
+	if len(frames) != 2 || string(rest) != "PING" {
+		t.Fatalf("Split was incorrect, got: %q, rest: %q.", frames, rest)
+	}
+
+	message, err := framer.Decode(frames[1])
+	if err != nil {
+		t.Fatalf("Decode threw an error: %v", err)
+	}
+	want := map[string]string{
+		"__query":  "PRIVMSG",
+		"prefix":   "test!u@h",
+		"params":   "#gsp!bfheroes",
+		"trailing": "hello there",
+	}
+	if command := message.(*GameSpy.Command); !reflect.DeepEqual(command.Message, want) {
+		t.Errorf("Decode was incorrect, got: %v, want: %v.", command.Message, want)
+	}
+
+	if string(framer.Encode([]byte("PONG"))) != "PONG\r\n" {
+		t.Errorf("Encode did not terminate the line.")
+	}
+}
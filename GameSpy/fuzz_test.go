@@ -0,0 +1,218 @@
+package GameSpy_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+// The FESL seeds are the frames of the recorded login of the Heroes client,
+// the others are shaped after traffic of the BF2142 and Heroes clients.
+// `go test` runs them and the crashers in testdata/fuzz as regular tests,
+// `go test -fuzz FuzzProcessCommand` and friends keep going from there.
+
+var gamespySeeds = []string{
+	"\\lc\\1\\challenge\\ABCDEFGHIJ\\id\\1\\final\\",
+	"\\login\\\\challenge\\k3VLqc7rUDBpWPkNSzEtO3I5gUv4gvPZ\\uniquenick\\Heroes\\userid\\1\\profileid\\1\\response\\d1b1f4b4b5c1d5f1e3f7a3b0f2b1e6a9\\firewall\\1\\port\\0\\productid\\10493\\gamename\\bfield1942ps2\\namespaceid\\13\\sdkrevision\\3\\quiet\\0\\id\\1\\final\\",
+	"\\ka\\\\final\\",
+	"\\status\\1\\sesskey\\1\\statstring\\Online\\locstring\\\\final\\",
+	"\\getprofile\\\\sesskey\\1\\profileid\\1\\id\\2\\final\\",
+	"\\heartbeat\\\\statechanged\\1\\gamename\\bfield1942\\final\\",
+	"\\",
+	"\\\\\\",
+}
+
+// feslSeeds are edge cases on top of the frames of the login capture
+var feslSeeds = []string{
+	"TXN=Hello\nclientString=bfheroes-pc\nsku=PC\nlocale=en_US\nclientPlatform=PC\nclientVersion=1.42.217478.0\nSDKVersion=5.0.0.0.0\nprotocolVersion=2.0\nfragmentSize=8096\nclientType=server\x00",
+	"TXN=NuLogin\nreturnEncryptedInfo=0\nnuid=heroes@example.com\npassword=secret\nmacAddr=$0a0027000000\x00",
+	"TXN=NuLoginPersona\nname=Heroes\x00",
+	"TXN=MemCheck\nresult=\x00",
+	"TXN=GetSessionId\x00",
+	"TXN=Ping\nlocalizedMessage=\"The password the user specified is incorrect\"\nerrorContainer.[]=0\nerrorCode=122\x00",
+	"encryptedInfo=Q2hhbGxlbmdl==\x00",
+	"\x00",
+	"",
+}
+
+// captureFrames returns the frames of the recorded login in both directions
+func captureFrames(f *testing.F) [][]byte {
+	capture, err := GameSpy.ReadCaptureFile(loginCapture)
+	if err != nil {
+		f.Fatalf("ReadCaptureFile threw an error: %v", err)
+	}
+
+	frames := make([][]byte, 0, len(capture.Records))
+	for _, record := range capture.Records {
+		frames = append(frames, record.Frame)
+	}
+	return frames
+}
+
+func FuzzProcessCommand(f *testing.F) {
+	for _, seed := range gamespySeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, msg string) {
+		command, err := GameSpy.ProcessCommand(msg)
+		if err != nil {
+			return
+		}
+		if command.Message["__query"] != command.Query {
+			t.Errorf("Query %q does not match __query %q", command.Query, command.Message["__query"])
+		}
+	})
+}
+
+func FuzzFESLRoundTrip(f *testing.F) {
+	for _, frame := range captureFrames(f) {
+		f.Add(string(frame[12:]))
+	}
+	for _, seed := range feslSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, payload string) {
+		decoded := GameSpy.ProcessFESL(payload)
+
+		// Whatever was decoded once has to survive encoding unchanged
+		again := GameSpy.ProcessFESL(GameSpy.SerializeFESL(decoded))
+		if !reflect.DeepEqual(decoded, again) {
+			t.Errorf("Round-trip was incorrect, got: %q, want: %q.", again, decoded)
+		}
+	})
+}
+
+func FuzzDecodePassword(f *testing.F) {
+	f.Add("secret")
+	f.Add("")
+	f.Add("\xff\xfe\x00 passw0rd?>")
+	f.Add("aGVyb2Vz")
+	f.Add("c2VjcmV0__")
+
+	f.Fuzz(func(t *testing.T, pass string) {
+		// Garbage must not panic
+		GameSpy.DecodePassword(pass)
+
+		decoded, err := GameSpy.DecodePassword(GameSpy.EncodePassword(pass))
+		if err != nil || decoded != pass {
+			t.Errorf("Round-trip was incorrect, got: %q, %v, want: %q.", decoded, err, pass)
+		}
+	})
+}
+
+func FuzzXOR(f *testing.F) {
+	for _, seed := range gamespySeeds {
+		f.Add([]byte(seed), []byte(""))
+	}
+	f.Add([]byte("\xfe\xfd\x09\x00\x00\x00\x00"), []byte("key"))
+
+	f.Fuzz(func(t *testing.T, data []byte, key []byte) {
+		framer := GameSpy.XORFramer{Framer: GameSpy.GameSpyFramer{}, Key: key}
+
+		encoded := framer.Encode(data)
+		if len(encoded) != len(data) {
+			t.Fatalf("Encode changed the length, got: %d, want: %d.", len(encoded), len(data))
+		}
+
+		advance, decoded, err := framer.Split(encoded)
+		if err != nil || advance != len(encoded) || !bytes.Equal(decoded, data) {
+			t.Errorf("Round-trip was incorrect, got: %q, want: %q.", decoded, data)
+		}
+
+		if len(key) == 0 {
+			socket := new(GameSpy.SocketUDP)
+			if !bytes.Equal(socket.XOr(socket.XOr(data)), data) {
+				t.Errorf("XOr is not its own reverse.")
+			}
+		}
+	})
+}
+
+func FuzzFESLFramer(f *testing.F) {
+	frames := captureFrames(f)
+	for _, frame := range frames {
+		f.Add(frame, uint8(7))
+	}
+	// The whole login as a single stream
+	f.Add(bytes.Join(frames, nil), uint8(64))
+
+	for i, seed := range feslSeeds {
+		frame := make([]byte, 12, 12+len(seed))
+		copy(frame, "fsys")
+		binary.BigEndian.PutUint32(frame[4:8], 0xC0000000+uint32(i))
+		binary.BigEndian.PutUint32(frame[8:12], uint32(12+len(seed)))
+		f.Add(append(frame, seed...), uint8(7))
+	}
+	f.Add([]byte("acct\x00\x00\x00\x01\x00\x00\x00\x04"), uint8(1))
+	f.Add([]byte("acct\x00\x00\x00\x01\xff\xff\xff\xff"), uint8(3))
+
+	f.Fuzz(func(t *testing.T, stream []byte, chunk uint8) {
+		framer := GameSpy.FESLFramer{}
+
+		// The frames must not depend on how the stream is chunked
+		whole := splitStream(t, framer, stream, len(stream))
+		chunked := splitStream(t, framer, stream, int(chunk))
+		if !reflect.DeepEqual(whole, chunked) {
+			t.Fatalf("Chunked split was incorrect, got: %q, want: %q.", chunked, whole)
+		}
+
+		for _, frame := range whole {
+			message, err := framer.Decode(frame)
+			if err != nil {
+				continue
+			}
+			command := message.(*GameSpy.CommandFESL)
+
+			// What we decode we can send back the same way
+			reencoded := GameSpy.EncodeFESL(command.Query, command.Message, command.PayloadID)
+			message, err = framer.Decode(reencoded)
+			if err != nil {
+				t.Fatalf("Decoding the re-encoded frame threw an error: %v", err)
+			}
+			again := message.(*GameSpy.CommandFESL)
+			if again.Query != command.Query || again.PayloadID != command.PayloadID || !reflect.DeepEqual(again.Message, command.Message) {
+				t.Errorf("Re-encoded frame was incorrect, got: %+v, want: %+v.", again, command)
+			}
+		}
+	})
+}
+
+// splitStream feeds stream to framer in chunks like a client reading from
+// the network and returns all frames until the first error
+func splitStream(t *testing.T, framer GameSpy.Framer, stream []byte, chunk int) [][]byte {
+	if chunk <= 0 {
+		chunk = 1
+	}
+
+	var frames [][]byte
+	var buffer []byte
+	for len(stream) > 0 {
+		n := chunk
+		if n > len(stream) {
+			n = len(stream)
+		}
+		buffer = append(buffer, stream[:n]...)
+		stream = stream[n:]
+
+		for len(buffer) > 0 {
+			advance, frame, err := framer.Split(buffer)
+			if err != nil {
+				return frames
+			}
+			if advance == 0 {
+				break
+			}
+			if advance > len(buffer) || len(frame) != advance {
+				t.Fatalf("Split returned a bad frame: advance %d, frame %d bytes, buffer %d bytes.", advance, len(frame), len(buffer))
+			}
+			frames = append(frames, append([]byte(nil), frame...))
+			buffer = buffer[advance:]
+		}
+	}
+	return frames
+}
@@ -0,0 +1,136 @@
+package GameSpy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// listenFdsStart is the first file descriptor passed by systemd
+	listenFdsStart = 3
+)
+
+var (
+	// ErrNoInheritedListener is returned for fd: and systemd: addresses
+	// which don't match a passed file descriptor
+	ErrNoInheritedListener = errors.New("no such inherited listener")
+
+	inheritedOnce  sync.Once
+	inheritedMutex sync.Mutex
+	inherited      map[string]*os.File
+)
+
+// ListenAddress turns a bare port into a listen address. Full addresses
+// are returned as they are.
+func ListenAddress(address string) string {
+	if strings.Contains(address, ":") {
+		return address
+	}
+	return "0.0.0.0:" + address
+}
+
+// Listen opens a stream listener on address. address is either a bare
+// port, which listens on all IPv4 interfaces like GoAwaken always did, or
+// a full listen address:
+//
+//	29900               0.0.0.0:29900, IPv4 only
+//	127.0.0.1:29900     a single interface
+//	[::1]:29900         a single IPv6 interface
+//	[::]:29900          dual-stack, IPv4 and IPv6
+//	:29900              dual-stack as well
+//	fd:3                a listener inherited as file descriptor 3
+//	systemd:fesl        the listener systemd passed under the name "fesl"
+//
+// Inherited listeners follow systemd's socket activation protocol, i.e.
+// LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES.
+func Listen(network string, address string) (net.Listener, error) {
+	if file, ok, err := inheritedFile(address); ok {
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return net.FileListener(file)
+	}
+	return net.Listen(network, ListenAddress(address))
+}
+
+// ListenPacket opens a datagram listener on address, see Listen for the
+// syntax
+func ListenPacket(network string, address string) (net.PacketConn, error) {
+	if file, ok, err := inheritedFile(address); ok {
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return net.FilePacketConn(file)
+	}
+	return net.ListenPacket(network, ListenAddress(address))
+}
+
+// inheritedFile takes the file descriptor an fd: or systemd: address
+// refers to. Every descriptor can be taken only once. The second return
+// value reports whether address refers to an inherited descriptor at all.
+func inheritedFile(address string) (*os.File, bool, error) {
+	if !strings.HasPrefix(address, "fd:") && !strings.HasPrefix(address, "systemd:") {
+		return nil, false, nil
+	}
+
+	inheritedOnce.Do(loadInherited)
+
+	inheritedMutex.Lock()
+	defer inheritedMutex.Unlock()
+
+	file, ok := inherited[address]
+	if !ok {
+		return nil, true, fmt.Errorf("%w: %s", ErrNoInheritedListener, address)
+	}
+
+	// A file is known by its number and its name, drop both
+	for key, other := range inherited {
+		if other == file {
+			delete(inherited, key)
+		}
+	}
+	return file, true, nil
+}
+
+// loadInherited picks up the file descriptors passed by systemd or an
+// upgrading parent process. The environment is cleared, so children don't take
+// them for their own.
+func loadInherited() {
+	inherited = make(map[string]*os.File)
+
+	// Passed by systemd or by an upgrading parent, see Upgrade
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	parent, parentErr := strconv.Atoi(os.Getenv(listenParentEnv))
+	if (err != nil || pid != os.Getpid()) && (parentErr != nil || parent != os.Getppid()) {
+		return
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	os.Unsetenv(listenParentEnv)
+
+	for i := 0; i < count; i++ {
+		fd := listenFdsStart + i
+		name := "fd:" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(fd), name)
+		inherited["fd:"+strconv.Itoa(fd)] = file
+		inherited["systemd:"+name] = file
+	}
+}
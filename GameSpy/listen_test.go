@@ -0,0 +1,44 @@
+package GameSpy_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func TestListenAddress(t *testing.T) {
+	var tests = []struct {
+		address string
+		want    string
+	}{
+		{"29900", "0.0.0.0:29900"},
+		{"127.0.0.1:29900", "127.0.0.1:29900"},
+		{"[::]:29900", "[::]:29900"},
+		{":29900", ":29900"},
+	}
+
+	for _, test := range tests {
+		if got := GameSpy.ListenAddress(test.address); got != test.want {
+			t.Errorf("ListenAddress was incorrect, got: %s, want: %s.", got, test.want)
+		}
+	}
+}
+
+func TestListenIPv6(t *testing.T) {
+	listener, err := GameSpy.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	defer listener.Close()
+
+	if ip := GameSpy.AddrIP(listener.Addr()); !ip.Equal(net.IPv6loopback) {
+		t.Errorf("Listener was bound incorrectly, got: %v.", listener.Addr())
+	}
+}
+
+func TestListenInheritedMissing(t *testing.T) {
+	if _, err := GameSpy.Listen("tcp", "systemd:nothing"); err == nil {
+		t.Errorf("Listening on a listener which was never passed did not fail.")
+	}
+}
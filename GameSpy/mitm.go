@@ -0,0 +1,293 @@
+package GameSpy
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// UpstreamConfig turns a server into a man in the middle: every client,
+// or every UDP peer, gets a connection to the upstream server of its own
+// and the frames are forwarded both ways. TLS is terminated by the
+// Transport of the server and started again towards upstream, so both
+// directions can be logged in decoded form. Events are still fired for
+// inbound frames, handlers shouldn't answer them though.
+type UpstreamConfig struct {
+	// DialConfig opens the upstream connections. Set TLS for upstream
+	// servers behind TLS, e.g. with InsecureSkipVerify for the
+	// certificates of EA.
+	DialConfig
+	// Address of the upstream server. Empty disables the proxy mode.
+	Address string
+	// Rewrite runs for every decoded frame on its way, e.g. to put the
+	// address of the proxy into the theaterIp of the Hello. See
+	// RewriteKeys.
+	Rewrite []Rewrite
+	// Log gets every frame in both directions. nil logs them with the
+	// Log package.
+	Log io.Writer
+}
+
+// Rewrite changes a message on its way through the proxy. It returns
+// true if it changed the message, which is then encoded again. Inbound
+// messages are on their way upstream, outbound ones on their way to the
+// client.
+type Rewrite func(direction Direction, message Message) bool
+
+// RewriteKeys returns a Rewrite setting the keys of messages going in
+// direction to the given values, if the messages hold the keys. A
+// direction of 0 rewrites both directions.
+func RewriteKeys(direction Direction, values map[string]string) Rewrite {
+	return func(messageDirection Direction, message Message) bool {
+		if direction != 0 && direction != messageDirection {
+			return false
+		}
+
+		var fields map[string]string
+		switch message := message.(type) {
+		case *CommandFESL:
+			fields = message.Message
+		case *Command:
+			fields = message.Message
+		}
+
+		changed := false
+		for key, value := range values {
+			if old, ok := fields[key]; ok && old != value {
+				fields[key] = value
+				changed = true
+			}
+		}
+		return changed
+	}
+}
+
+func (config UpstreamConfig) enabled() bool {
+	return config.Address != ""
+}
+
+// upstreamLogLock keeps the lines of concurrent connections apart
+var upstreamLogLock sync.Mutex
+
+// upstreamConn is the upstream end of a single client or UDP peer
+type upstreamConn struct {
+	config UpstreamConfig
+	server string
+	framer Framer
+	peer   net.Addr
+	conn   net.Conn
+	// deliver hands frames from upstream to the client
+	deliver func(frame []byte)
+	// gone is called once upstream hung up
+	gone func()
+
+	writeLock sync.Mutex
+	closeOnce sync.Once
+}
+
+// openUpstream connects to the upstream server on behalf of peer. Call
+// start once the client is ready for what upstream sends.
+func (server *Server) openUpstream(peer net.Addr, network string, deliver func(frame []byte), gone func()) (*upstreamConn, error) {
+	config := server.Upstream
+	ctx, cancel := context.WithTimeout(server.ctx, config.timeout())
+	defer cancel()
+
+	conn, err := config.dial(ctx, network, config.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	upstream := &upstreamConn{
+		config:  config,
+		server:  server.Name,
+		framer:  server.Framer,
+		peer:    peer,
+		conn:    conn,
+		deliver: deliver,
+		gone:    gone,
+	}
+	return upstream, nil
+}
+
+// start forwards what upstream sends to the client
+func (upstream *upstreamConn) start() {
+	if upstream != nil {
+		go upstream.run()
+	}
+}
+
+// forward sends a frame of the client upstream
+func (upstream *upstreamConn) forward(frame []byte) {
+	if upstream == nil {
+		return
+	}
+	frame = upstream.rewrite(Inbound, frame)
+
+	upstream.writeLock.Lock()
+	defer upstream.writeLock.Unlock()
+
+	upstream.conn.SetWriteDeadline(time.Now().Add(upstream.config.timeout()))
+	if _, err := upstream.conn.Write(upstream.framer.Encode(frame)); err != nil {
+		log.Errorln(upstream.server+": Forwarding upstream threw an error.", err)
+		upstream.Close()
+	}
+}
+
+// run hands everything upstream sends to the client
+func (upstream *upstreamConn) run() {
+	defer upstream.gone()
+	defer upstream.Close()
+
+	reader := newFrameReader(upstream.conn, upstream.framer)
+	for {
+		frame, err := reader.next()
+		if err != nil {
+			log.Debugf("%s: Upstream of %v is gone. %v", upstream.server, upstream.peer, err)
+			return
+		}
+		upstream.deliver(upstream.rewrite(Outbound, frame))
+	}
+}
+
+// Close closes the upstream connection
+func (upstream *upstreamConn) Close() {
+	if upstream == nil {
+		return
+	}
+	upstream.closeOnce.Do(func() {
+		upstream.conn.Close()
+	})
+}
+
+// rewrite logs a frame and runs the rewrites over it, returning the
+// frame to send on
+func (upstream *upstreamConn) rewrite(direction Direction, frame []byte) []byte {
+	message, err := upstream.framer.Decode(frame)
+	if err != nil || message == nil {
+		upstream.log(direction, fmt.Sprintf("%v, %s", err, hex.EncodeToString(frame)))
+		return frame
+	}
+
+	changed := false
+	for _, rewrite := range upstream.config.Rewrite {
+		if rewrite(direction, message) {
+			changed = true
+		}
+	}
+	if changed {
+		frame = encodeMessage(upstream.framer, message)
+	}
+
+	line := formatMessage(message)
+	if changed {
+		line += " (rewritten)"
+	}
+	upstream.log(direction, line)
+	return frame
+}
+
+func (upstream *upstreamConn) log(direction Direction, line string) {
+	arrow := "->"
+	if direction == Outbound {
+		arrow = "<-"
+	}
+
+	if upstream.config.Log == nil {
+		log.Noteln(fmt.Sprintf("%s: %v %s %s", upstream.server, upstream.peer, arrow, line))
+		return
+	}
+
+	upstreamLogLock.Lock()
+	defer upstreamLogLock.Unlock()
+	fmt.Fprintf(upstream.config.Log, "%s %s %v %s %s\n", time.Now().Format("15:04:05.000"), upstream.server, upstream.peer, arrow, line)
+}
+
+// formatMessage prints a message on a single line, the TXN first and the
+// other keys sorted
+func formatMessage(message Message) string {
+	var b strings.Builder
+	var fields map[string]string
+
+	switch message := message.(type) {
+	case *CommandFESL:
+		fmt.Fprintf(&b, "%s %#08x", message.Query, message.PayloadID)
+		fields = message.Message
+		if txn, ok := fields["TXN"]; ok {
+			b.WriteString(" TXN=" + txn)
+		}
+	case *Command:
+		b.WriteString(message.Query)
+		fields = message.Message
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if key != "TXN" && key != "__query" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%q", key, fields[key])
+	}
+	return b.String()
+}
+
+// encodeMessage turns a message back into a frame. The keys of GameSpy
+// commands come after the query in sorted order, their original order
+// is lost in decoding.
+func encodeMessage(framer Framer, message Message) []byte {
+	if xorFramer, ok := framer.(XORFramer); ok {
+		framer = xorFramer.Framer
+	}
+
+	switch message := message.(type) {
+	case *CommandFESL:
+		return EncodeFESL(message.Query, message.Message, message.PayloadID)
+	case *Command:
+		if _, ok := framer.(LineFramer); ok {
+			return encodeLine(message)
+		}
+
+		var b strings.Builder
+		b.WriteString("\\" + message.Query + "\\" + message.Message[strings.ToLower(message.Query)])
+		keys := make([]string, 0, len(message.Message))
+		for key := range message.Message {
+			if key != "__query" && key != strings.ToLower(message.Query) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			b.WriteString("\\" + key + "\\" + message.Message[key])
+		}
+		b.WriteString("\\final\\")
+		return []byte(b.String())
+	}
+	return nil
+}
+
+// encodeLine is the reverse of LineFramer.Decode
+func encodeLine(command *Command) []byte {
+	var b strings.Builder
+	if prefix, ok := command.Message["prefix"]; ok {
+		b.WriteString(":" + prefix + " ")
+	}
+	b.WriteString(command.Query)
+	if params, ok := command.Message["params"]; ok {
+		b.WriteString(" " + params)
+	}
+	if trailing, ok := command.Message["trailing"]; ok {
+		b.WriteString(" :" + trailing)
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,180 @@
+package GameSpy_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (buffer *lockedBuffer) Write(p []byte) (int, error) {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	return buffer.buf.Write(p)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	return buffer.buf.String()
+}
+
+func TestUpstream(t *testing.T) {
+	upstream, _ := startLoginServer(t, "", "Heroes")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var transcript lockedBuffer
+	listener := GameSpy.NewPipeListener()
+	proxy := &GameSpy.Server{
+		Name:   "MITM",
+		Framer: GameSpy.FESLFramer{},
+		Upstream: GameSpy.UpstreamConfig{
+			DialConfig: pipeDialer(upstream),
+			Address:    "fesl.example.com:18270",
+			Rewrite:    []GameSpy.Rewrite{GameSpy.RewriteKeys(GameSpy.Outbound, map[string]string{"theaterIp": "10.0.0.1"})},
+			Log:        &transcript,
+		},
+	}
+	events, err := proxy.Listen(ctx, listener)
+	if err != nil {
+		t.Fatalf("Listen threw an error: %v", err)
+	}
+	go func() {
+		for range events {
+		}
+	}()
+
+	fesl, err := GameSpy.DialFESL(ctx, "proxy", GameSpy.FESLConfig{
+		DialConfig: pipeDialer(listener),
+		User:       "heroes@example.com",
+		Password:   "secret",
+		Persona:    "Heroes",
+	})
+	if err != nil {
+		t.Fatalf("DialFESL threw an error: %v", err)
+	}
+	defer fesl.Close()
+
+	if fesl.Hello.TheaterIP != "10.0.0.1" || fesl.Hello.TheaterPort != 18275 {
+		t.Errorf("Hello was not rewritten, got: %+v.", fesl.Hello)
+	}
+	if fesl.Login.DisplayName != "Heroes" || fesl.Persona.ProfileID != 2 {
+		t.Errorf("Login through the proxy was incorrect, got: %+v, %+v.", fesl.Login, fesl.Persona)
+	}
+
+	log := transcript.String()
+	for _, want := range []string{
+		"MITM pipe -> fsys 0xc0000001 TXN=Hello",
+		"MITM pipe <- fsys 0xc0000001 TXN=Hello",
+		`theaterIp="10.0.0.1"`,
+		"(rewritten)",
+		`-> acct 0xc0000002 TXN=NuLogin`,
+		`nuid="heroes@example.com"`,
+		`<- acct 0xc0000003 TXN=NuLoginPersona`,
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("Transcript is missing %q, got:\n%s", want, log)
+		}
+	}
+}
+
+func TestUpstreamDatagrams(t *testing.T) {
+	xor := GameSpy.XORFramer{Framer: GameSpy.GameSpyFramer{}}
+
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket threw an error: %v", err)
+	}
+	defer upstream.Close()
+	received := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, frame, _ := xor.Split(buf[:n])
+			received <- string(frame)
+			upstream.WriteTo(xor.Encode([]byte("\\ack\\\\final\\")), addr)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket threw an error: %v", err)
+	}
+	proxy := &GameSpy.Server{
+		Name:     "MITM",
+		Framer:   xor,
+		Upstream: GameSpy.UpstreamConfig{Address: upstream.LocalAddr().String(), Log: new(lockedBuffer)},
+	}
+	events, err := proxy.ListenPacket(ctx, conn)
+	if err != nil {
+		t.Fatalf("ListenPacket threw an error: %v", err)
+	}
+	go func() {
+		for range events {
+		}
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial threw an error: %v", err)
+	}
+	defer client.Close()
+	client.Write(xor.Encode([]byte("\\heartbeat\\\\gamename\\heroes\\final\\")))
+
+	select {
+	case frame := <-received:
+		if frame != "\\heartbeat\\\\gamename\\heroes\\final\\" {
+			t.Errorf("Upstream received the wrong datagram, got: %q.", frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Upstream received nothing.")
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4096)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("Reading the answer threw an error: %v", err)
+	}
+	if _, frame, _ := xor.Split(buf[:n]); string(frame) != "\\ack\\\\final\\" {
+		t.Errorf("Answer was incorrect, got: %q.", frame)
+	}
+
+	// The session ends with its upstream
+	addr := client.LocalAddr().(*net.UDPAddr)
+	if _, ok := proxy.Session(addr); !ok {
+		t.Fatalf("Session of %v is missing.", addr)
+	}
+	upstream.Close()
+	client.Write(xor.Encode([]byte("\\heartbeat\\\\gamename\\heroes\\final\\")))
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := proxy.Session(addr); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Session outlived its upstream.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,226 @@
+package GameSpy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyConfig enables the PROXY protocol of HAProxy and friends, so
+// clients behind a load balancer show up with their real address
+type ProxyConfig struct {
+	// Trusted lists the networks of the load balancers. Connections from
+	// there have to start with a PROXY protocol v1 or v2 header, all
+	// others are taken as they are. Empty disables the PROXY protocol.
+	Trusted []*net.IPNet
+	// Timeout bounds reading the header. 0 means 5 seconds.
+	Timeout time.Duration
+}
+
+// ErrInvalidProxyHeader is returned for connections of trusted proxies
+// which don't start with a valid PROXY protocol header
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// ParseCIDRs parses a list of networks like "10.0.0.0/8". Single
+// addresses are taken as networks of their own.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// trusts reports whether addr belongs to a trusted proxy
+func (config ProxyConfig) trusts(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range config.Trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn is a connection behind a proxy. It reports the address of
+// the client instead of the proxy.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+func (conn *proxyConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// acceptProxy reads the PROXY protocol header of connections from
+// trusted proxies and returns a connection reporting the real client
+func (config ProxyConfig) acceptProxy(conn net.Conn) (net.Conn, error) {
+	if len(config.Trusted) == 0 || !config.trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	reader := bufio.NewReader(conn)
+	remote, err := readProxyHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Time{})
+
+	// LOCAL connections, e.g. health checks, keep the proxy address
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+
+	return &proxyConn{
+		Conn:   conn,
+		reader: reader,
+		remote: remote,
+	}, nil
+}
+
+// readProxyHeader reads a v1 or v2 header and returns the source address
+// it carries. The address is nil if the header holds none.
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	// Even the shortest header, "PROXY UNKNOWN\r\n", is longer than this
+	signature, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(signature, proxyV2Signature):
+		return readProxyV2(reader)
+	case bytes.HasPrefix(signature, []byte("PROXY ")):
+		return readProxyV1(reader)
+	}
+	return nil, ErrInvalidProxyHeader
+}
+
+// readProxyV1 parses the text header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 18300\r\n"
+func readProxyV1(reader *bufio.Reader) (net.Addr, error) {
+	// The longest possible header is 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses the binary header
+func readProxyV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	version, command := header[12]>>4, header[12]&0x0F
+	if version != 2 || command > 1 {
+		return nil, fmt.Errorf("%w: version %d, command %d", ErrInvalidProxyHeader, version, command)
+	}
+
+	// Addresses and TLVs, we only care about the addresses
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL
+	if command == 0 {
+		return nil, nil
+	}
+
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	}
+
+	// UNSPEC or anything which isn't TCP
+	return nil, nil
+}
@@ -0,0 +1,65 @@
+package GameSpy_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func TestServerProxyProtocol(t *testing.T) {
+	var tests = []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"v1 IPv4", "PROXY TCP4 192.0.2.1 127.0.0.1 56324 29900\r\n", "192.0.2.1:56324"},
+		{"v1 IPv6", "PROXY TCP6 2001:db8::1 ::1 56324 29900\r\n", "[2001:db8::1]:56324"},
+		{"v2 IPv4", "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0C\xC0\x00\x02\x01\x7F\x00\x00\x01\xDC\x04\x74\xCC", "192.0.2.1:56324"},
+	}
+
+	trusted, err := GameSpy.ParseCIDRs([]string{"127.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs threw an error: %v", err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, _ := net.Listen("tcp", "127.0.0.1:0")
+			server := &GameSpy.Server{Name: "test"}
+			server.Proxy.Trusted = trusted
+			events, _ := server.Listen(context.Background(), listener)
+			defer server.Shutdown(context.Background())
+
+			conn, _ := net.Dial("tcp", listener.Addr().String())
+			defer conn.Close()
+			conn.Write([]byte(test.header + "\\ka\\\\final\\"))
+
+			event := nextSocketEvent(t, events, "client.command.ka")
+			client := event.Data.(GameSpy.EventClientCommand).Client
+			if client.IpAddr.String() != test.want || client.State.IpAddress.String() != test.want {
+				t.Errorf("Client address was incorrect, got: %v, want: %s.", client.IpAddr, test.want)
+			}
+		})
+	}
+}
+
+func TestServerProxyProtocolUntrusted(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{Name: "test"}
+	server.Proxy.Trusted, _ = GameSpy.ParseCIDRs([]string{"10.0.0.0/8"})
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	// Nobody but the load balancers may claim another address
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 29900\r\n\\ka\\\\final\\"))
+
+	event := nextSocketEvent(t, events, "client.command.ka")
+	client := event.Data.(GameSpy.EventClientCommand).Client
+	if client.IpAddr.String() != conn.LocalAddr().String() {
+		t.Errorf("Client address was incorrect, got: %v, want: %v.", client.IpAddr, conn.LocalAddr())
+	}
+}
@@ -0,0 +1,131 @@
+package GameSpy
+
+import (
+	"errors"
+	"path"
+	"time"
+)
+
+// tokenBucket allows rate events per second on average and up to burst
+// at once. It's not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// refill adds the tokens earned since the last call
+func (bucket *tokenBucket) refill(now time.Time) {
+	if now.After(bucket.last) {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+		if bucket.tokens > bucket.burst {
+			bucket.tokens = bucket.burst
+		}
+		bucket.last = now
+	}
+}
+
+// allow takes a token if there is one
+func (bucket *tokenBucket) allow(now time.Time) bool {
+	bucket.refill(now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// ready reports whether there is a token, without taking it
+func (bucket *tokenBucket) ready(now time.Time) bool {
+	bucket.refill(now)
+	return bucket.tokens >= 1
+}
+
+// full reports whether the bucket has refilled completely, i.e. it
+// carries no state worth keeping
+func (bucket *tokenBucket) full(now time.Time) bool {
+	bucket.refill(now)
+	return bucket.tokens >= bucket.burst
+}
+
+// RateLimit is a token bucket, Rate messages per second on average and
+// up to Burst at once. A zero Rate disables it.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// CommandRateLimit limits the commands with a name matching Pattern.
+// Patterns use path.Match syntax on the event names, e.g. "acct.*" for
+// every FESL acct transaction or "login" for GameSpy logins.
+type CommandRateLimit struct {
+	Pattern string
+	RateLimit
+}
+
+// RateLimitConfig limits the messages a single client may send. Clients
+// going over any limit get an error and are disconnected. Frames that
+// don't decode count as messages named "error".
+type RateLimitConfig struct {
+	// Messages limits all messages of a client
+	Messages RateLimit
+	// Commands limits single kinds of commands. Every pattern has a
+	// bucket of its own, all matching patterns are applied.
+	Commands []CommandRateLimit
+}
+
+// ErrRateLimited is fired as client error for clients sending too fast
+var ErrRateLimited = errors.New("client exceeded the rate limit")
+
+// messageLimiter applies a RateLimitConfig to a single client
+type messageLimiter struct {
+	messages *tokenBucket
+	commands []*tokenBucket
+	config   RateLimitConfig
+}
+
+func newMessageLimiter(config RateLimitConfig, now time.Time) *messageLimiter {
+	limiter := &messageLimiter{config: config}
+	if config.Messages.Rate > 0 {
+		limiter.messages = newTokenBucket(config.Messages.Rate, config.Messages.Burst, now)
+	}
+	for _, command := range config.Commands {
+		var bucket *tokenBucket
+		if command.Rate > 0 {
+			bucket = newTokenBucket(command.Rate, command.Burst, now)
+		}
+		limiter.commands = append(limiter.commands, bucket)
+	}
+	return limiter
+}
+
+// allow reports whether a message with the event name name may pass
+func (limiter *messageLimiter) allow(name string, now time.Time) bool {
+	allowed := true
+	if limiter.messages != nil && !limiter.messages.allow(now) {
+		allowed = false
+	}
+	for i, command := range limiter.config.Commands {
+		bucket := limiter.commands[i]
+		if bucket == nil {
+			continue
+		}
+		if matched, _ := path.Match(command.Pattern, name); matched && !bucket.allow(now) {
+			allowed = false
+		}
+	}
+	return allowed
+}
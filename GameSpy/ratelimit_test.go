@@ -0,0 +1,127 @@
+package GameSpy_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func TestClientFlood(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{
+		Name: "test",
+		RateLimit: GameSpy.RateLimitConfig{
+			Commands: []GameSpy.CommandRateLimit{
+				{Pattern: "login", RateLimit: GameSpy.RateLimit{Rate: 0.1, Burst: 2}},
+			},
+		},
+	}
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	defer conn.Close()
+
+	// Other commands are not limited
+	conn.Write([]byte(strings.Repeat("\\ka\\\\final\\", 5)))
+	conn.Write([]byte(strings.Repeat("\\login\\\\final\\", 3)))
+
+	logins := 0
+	for event := range events {
+		if event.Name == "client.command.login" {
+			logins++
+		}
+		if event.Name == "client.error" {
+			if err := event.Data.(GameSpy.EventClientError).Error; err != GameSpy.ErrRateLimited {
+				t.Errorf("Client error was incorrect, got: %v, want: %v.", err, GameSpy.ErrRateLimited)
+			}
+		}
+		if event.Name == "client.close" {
+			break
+		}
+	}
+	if logins != 2 {
+		t.Errorf("Logins passed were incorrect, got: %d, want: %d.", logins, 2)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	answer, _ := io.ReadAll(conn)
+	if !strings.HasPrefix(string(answer), "\\error\\") {
+		t.Errorf("Client got no error before the disconnect, got: %q.", answer)
+	}
+}
+
+func TestClientFloodFESL(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{
+		Name:      "test",
+		Framer:    GameSpy.FESLFramer{},
+		RateLimit: GameSpy.RateLimitConfig{Messages: GameSpy.RateLimit{Rate: 0.1, Burst: 1}},
+	}
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+	go func() {
+		for range events {
+		}
+	}()
+
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	defer conn.Close()
+	conn.Write(GameSpy.EncodeFESL("fsys", map[string]string{"TXN": "Hello"}, 0xC0000001))
+	conn.Write(GameSpy.EncodeFESL("acct", map[string]string{"TXN": "NuLogin"}, 0xC0000002))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	answer, _ := io.ReadAll(conn)
+	framer := GameSpy.FESLFramer{}
+	_, frame, err := framer.Split(answer)
+	if err != nil || frame == nil {
+		t.Fatalf("Client got no FESL error, got: %q.", answer)
+	}
+	message, _ := framer.Decode(frame)
+	command := message.(*GameSpy.CommandFESL)
+	if command.Query != "acct" || command.PayloadID != 0xC0000002 || command.Message["TXN"] != "NuLogin" || command.Message["errorCode"] == "" {
+		t.Errorf("FESL error was incorrect, got: %+v.", command)
+	}
+}
+
+func TestClientFloodInvalid(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{
+		Name:   "test",
+		Framer: GameSpy.FESLFramer{},
+		RateLimit: GameSpy.RateLimitConfig{
+			Commands: []GameSpy.CommandRateLimit{
+				{Pattern: "error", RateLimit: GameSpy.RateLimit{Rate: 0.1, Burst: 2}},
+			},
+		},
+	}
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	defer conn.Close()
+
+	// Frames with an unprintable type don't decode
+	frame := GameSpy.EncodeFESL("\x01\x02\x03\x04", map[string]string{"TXN": "Hello"}, 0xC0000001)
+	for i := 0; i < 3; i++ {
+		conn.Write(frame)
+	}
+
+	var errs []error
+	for event := range events {
+		if event.Name == "client.error" {
+			errs = append(errs, event.Data.(GameSpy.EventClientError).Error)
+		}
+		if event.Name == "client.close" {
+			break
+		}
+	}
+	if len(errs) != 3 || errs[2] != GameSpy.ErrRateLimited {
+		t.Errorf("Client errors were incorrect, got: %v, want 2 decode errors and %v.", errs, GameSpy.ErrRateLimited)
+	}
+}
@@ -0,0 +1,309 @@
+package GameSpy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrListenerClosed is returned by a PipeListener once it's closed
+var ErrListenerClosed = errors.New("listener closed")
+
+// PipeListener is a net.Listener over in-memory connections. Hand it to
+// Server.Listen and connect with Dial, no sockets involved.
+type PipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewPipeListener returns a listener ready to be dialed
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept waits for the next Dial
+func (listener *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close stops accepting, connections already made stay open
+func (listener *PipeListener) Close() error {
+	listener.once.Do(func() {
+		close(listener.done)
+	})
+	return nil
+}
+
+// Addr returns a placeholder address
+func (listener *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// Dial connects to the listener and returns the client end
+func (listener *PipeListener) Dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+
+	select {
+	case listener.conns <- server:
+		return client, nil
+	case <-listener.done:
+		return nil, ErrListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// Replayer feeds recorded captures back into a server and compares the
+// responses with the recorded ones. Together with a PipeListener this
+// makes golden tests of whole flows:
+//
+//	listener := GameSpy.NewPipeListener()
+//	events, _ := server.Listen(ctx, listener)
+//	go handle(events)
+//
+//	replayer := GameSpy.Replayer{Dial: listener.Dial, Ignore: []string{"lkey"}}
+//	result, err := replayer.Replay(ctx, capture)
+//
+// Inbound frames are sent in order. Before every inbound frame, the
+// replayer waits for the responses recorded ahead of it, so the timing
+// of the capture doesn't matter.
+type Replayer struct {
+	// Dial connects to the server
+	Dial func(ctx context.Context) (net.Conn, error)
+	// Framer splits the responses. nil means FramerFor the protocol of
+	// the capture.
+	Framer Framer
+	// Timeout is how long to wait for a single response. 0 means 5s.
+	Timeout time.Duration
+	// Ignore lists keys which differ between runs, e.g. session keys or
+	// timestamps. They are not compared.
+	Ignore []string
+}
+
+// ReplayDiff is a response which differs from the recorded one
+type ReplayDiff struct {
+	// Record is the index of the recorded frame in the capture
+	Record int
+	Want   []byte
+	Got    []byte
+	Reason string
+}
+
+func (diff ReplayDiff) String() string {
+	return fmt.Sprintf("record %d: %s\n  want: %q\n  got:  %q", diff.Record, diff.Reason, diff.Want, diff.Got)
+}
+
+// ReplayResult is the outcome of a replay
+type ReplayResult struct {
+	Sent     int
+	Received int
+	Diffs    []ReplayDiff
+}
+
+// Ok reports whether every response matched
+func (result *ReplayResult) Ok() bool {
+	return len(result.Diffs) == 0
+}
+
+func (result *ReplayResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sent %d frames, received %d, %d differ", result.Sent, result.Received, len(result.Diffs))
+	for _, diff := range result.Diffs {
+		b.WriteString("\n")
+		b.WriteString(diff.String())
+	}
+	return b.String()
+}
+
+// Replay plays capture against the server. Differing responses end up in
+// the result, errors are only returned if the replay could not run.
+func (replayer *Replayer) Replay(ctx context.Context, capture *Capture) (*ReplayResult, error) {
+	if capture.Datagram {
+		return nil, errors.New("replaying datagram captures is not supported")
+	}
+
+	framer := replayer.Framer
+	if framer == nil {
+		var err error
+		framer, err = FramerFor(capture.Protocol)
+		if err != nil {
+			return nil, err
+		}
+	}
+	timeout := replayer.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	conn, err := replayer.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	responses := make(chan []byte, 64)
+	done := make(chan struct{})
+	defer close(done)
+	go readFrames(conn, framer, responses, done)
+
+	result := new(ReplayResult)
+	for i, record := range capture.Records {
+		switch record.Direction {
+		case Inbound:
+			if _, err := conn.Write(framer.Encode(record.Frame)); err != nil {
+				return result, fmt.Errorf("sending record %d: %w", i, err)
+			}
+			result.Sent++
+		case Outbound:
+			timer := time.NewTimer(timeout)
+			select {
+			case got, ok := <-responses:
+				timer.Stop()
+				if !ok {
+					result.Diffs = append(result.Diffs, ReplayDiff{Record: i, Want: record.Frame, Reason: "connection closed"})
+					return result, nil
+				}
+				result.Received++
+				if reason := replayer.compare(framer, record.Frame, got); reason != "" {
+					result.Diffs = append(result.Diffs, ReplayDiff{Record: i, Want: record.Frame, Got: got, Reason: reason})
+				}
+			case <-timer.C:
+				result.Diffs = append(result.Diffs, ReplayDiff{Record: i, Want: record.Frame, Reason: "no response"})
+			case <-ctx.Done():
+				timer.Stop()
+				return result, ctx.Err()
+			}
+		}
+	}
+
+	// Whatever already came in beyond the capture is a difference too
+	for {
+		select {
+		case got, ok := <-responses:
+			if !ok {
+				return result, nil
+			}
+			result.Received++
+			result.Diffs = append(result.Diffs, ReplayDiff{Record: len(capture.Records), Got: got, Reason: "unexpected response"})
+		default:
+			return result, nil
+		}
+	}
+}
+
+// compare returns why got differs from want, or "" if it doesn't.
+// Frames which decode are compared by their messages, so the order of
+// keys doesn't matter.
+func (replayer *Replayer) compare(framer Framer, want []byte, got []byte) string {
+	wantMessage, wantErr := framer.Decode(want)
+	gotMessage, gotErr := framer.Decode(got)
+	if wantErr != nil || gotErr != nil || wantMessage == nil || gotMessage == nil {
+		if !bytes.Equal(bytes.TrimSpace(want), bytes.TrimSpace(got)) {
+			return "frames differ"
+		}
+		return ""
+	}
+
+	var wantQuery, gotQuery string
+	var wantKeys, gotKeys map[string]string
+	switch wantMessage := wantMessage.(type) {
+	case *Command:
+		wantQuery, wantKeys = wantMessage.Query, wantMessage.Message
+	case *CommandFESL:
+		wantQuery, wantKeys = wantMessage.Query, wantMessage.Message
+		gotFESL, ok := gotMessage.(*CommandFESL)
+		if ok && gotFESL.PayloadID != wantMessage.PayloadID {
+			return fmt.Sprintf("payload ID %#x instead of %#x", gotFESL.PayloadID, wantMessage.PayloadID)
+		}
+	}
+	switch gotMessage := gotMessage.(type) {
+	case *Command:
+		gotQuery, gotKeys = gotMessage.Query, gotMessage.Message
+	case *CommandFESL:
+		gotQuery, gotKeys = gotMessage.Query, gotMessage.Message
+	}
+
+	if wantQuery != gotQuery {
+		return fmt.Sprintf("query %q instead of %q", gotQuery, wantQuery)
+	}
+	wantKeys, gotKeys = replayer.strip(wantKeys), replayer.strip(gotKeys)
+	if !reflect.DeepEqual(wantKeys, gotKeys) {
+		for key, value := range wantKeys {
+			if gotValue, ok := gotKeys[key]; !ok {
+				return fmt.Sprintf("key %q missing", key)
+			} else if gotValue != value {
+				return fmt.Sprintf("key %q is %q instead of %q", key, gotValue, value)
+			}
+		}
+		for key := range gotKeys {
+			if _, ok := wantKeys[key]; !ok {
+				return fmt.Sprintf("unexpected key %q", key)
+			}
+		}
+	}
+	return ""
+}
+
+// strip returns message without the ignored keys
+func (replayer *Replayer) strip(message map[string]string) map[string]string {
+	stripped := make(map[string]string, len(message))
+	for key, value := range message {
+		stripped[key] = value
+	}
+	for _, key := range replayer.Ignore {
+		delete(stripped, key)
+	}
+	return stripped
+}
+
+// readFrames hands every frame read from conn to frames until conn is
+// closed or unreadable, or done is closed
+func readFrames(conn net.Conn, framer Framer, frames chan []byte, done chan struct{}) {
+	defer close(frames)
+
+	var buffer []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		buffer = append(buffer, buf[:n]...)
+
+		for len(buffer) > 0 {
+			advance, frame, err := framer.Split(buffer)
+			if err != nil {
+				return
+			}
+			if advance == 0 {
+				break
+			}
+			select {
+			case frames <- append([]byte(nil), frame...):
+			case <-done:
+				return
+			}
+			buffer = buffer[advance:]
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package GameSpy_test
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+	"github.com/HeroesAwaken/GoAwaken/core"
+)
+
+var update = flag.Bool("update", false, "Record the golden captures in testdata again")
+
+const loginCapture = "testdata/fesl_login.gacap"
+
+// startLoginServer runs a FESL server answering the login flow of the
+// Heroes client, recording to dir if it's set
+func startLoginServer(t *testing.T, dir string, displayName string) (*GameSpy.PipeListener, *GameSpy.Server) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	listener := GameSpy.NewPipeListener()
+	server := &GameSpy.Server{
+		Name:   "FESL",
+		Framer: GameSpy.FESLFramer{},
+		Record: GameSpy.RecordConfig{Dir: dir},
+	}
+	events, err := server.Listen(ctx, listener)
+	if err != nil {
+		t.Fatalf("Listen threw an error: %v", err)
+	}
+
+	go func() {
+		for event := range events {
+			command, ok := event.Data.(GameSpy.EventClientFESLCommand)
+			if !ok || event.Name == "client.command" {
+				continue
+			}
+			client, message := command.Client, command.Command
+
+			// Session keys differ every time
+			lkey := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+			switch event.Name {
+			case "client.command.fsys.Hello":
+				client.WriteFESL("fsys", map[string]string{
+					"TXN":                       "Hello",
+					"domainPartition.domain":    "eagames",
+					"domainPartition.subDomain": "bfwest-dedicated",
+					"theaterIp":                 "127.0.0.1",
+					"theaterPort":               "18275",
+					"activityTimeoutSecs":       "0",
+				}, message.PayloadID)
+			case "client.command.acct.NuLogin":
+				if message.Message["password"] != "secret" {
+					client.WriteNuLoginError(core.ErrWrongPassword, message.PayloadID)
+					continue
+				}
+				client.WriteFESL("acct", map[string]string{
+					"TXN":         "NuLogin",
+					"lkey":        lkey,
+					"nuid":        message.Message["nuid"],
+					"userId":      "1",
+					"profileId":   "1",
+					"displayName": displayName,
+				}, message.PayloadID)
+			case "client.command.acct.NuLoginPersona":
+				client.WriteFESL("acct", map[string]string{
+					"TXN":       "NuLoginPersona",
+					"lkey":      lkey,
+					"profileId": "2",
+					"userId":    "1",
+				}, message.PayloadID)
+			}
+		}
+	}()
+
+	return listener, server
+}
+
+// recordLogin runs the login flow against a recording server and returns
+// the capture
+func recordLogin(t *testing.T) *GameSpy.Capture {
+	dir := t.TempDir()
+	listener, server := startLoginServer(t, dir, "Heroes")
+	closed, _ := server.Events().Subscribe(string(GameSpy.KindClientClose), GameSpy.SubscribeOptions{Buffer: 1})
+
+	conn, err := listener.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("Dial threw an error: %v", err)
+	}
+	defer conn.Close()
+
+	for i, request := range []map[string]string{
+		{"TXN": "Hello", "clientString": "bfheroes-pc", "sku": "PC", "locale": "en_US", "clientType": "server", "protocolVersion": "2.0"},
+		{"TXN": "NuLogin", "returnEncryptedInfo": "0", "nuid": "heroes@example.com", "password": "secret", "macAddr": "$0a0027000000"},
+		{"TXN": "NuLoginPersona", "name": "Heroes"},
+	} {
+		msgType := "acct"
+		if i == 0 {
+			msgType = "fsys"
+		}
+		conn.Write(GameSpy.EncodeFESL(msgType, request, 0xC0000001+uint32(i)))
+
+		// Wait for the answer, so the capture alternates
+		var buffer []byte
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("Reading the answer to %s threw an error: %v", request["TXN"], err)
+			}
+			buffer = append(buffer, buf[:n]...)
+			if advance, _, _ := (GameSpy.FESLFramer{}).Split(buffer); advance == len(buffer) {
+				break
+			}
+		}
+	}
+	conn.Close()
+
+	select {
+	case <-closed.C:
+	case <-time.After(time.Second):
+		t.Fatalf("Client did not go away.")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.gacap"))
+	if len(files) != 1 {
+		t.Fatalf("Recorded %d captures instead of 1.", len(files))
+	}
+	capture, err := GameSpy.ReadCaptureFile(files[0])
+	if err != nil {
+		t.Fatalf("Reading the capture threw an error: %v", err)
+	}
+	return capture
+}
+
+func TestRecordLogin(t *testing.T) {
+	capture := recordLogin(t)
+
+	if capture.Protocol != "fesl" || capture.Server != "FESL" || capture.ClientID != 1 || capture.Datagram {
+		t.Errorf("Header was incorrect, got: %+v.", capture.CaptureHeader)
+	}
+
+	directions := ""
+	for _, record := range capture.Records {
+		directions += record.Direction.String() + " "
+	}
+	if directions != "in out in out in out " {
+		t.Errorf("Records were incorrect, got: %s.", directions)
+	}
+
+	if *update {
+		os.MkdirAll(filepath.Dir(loginCapture), 0755)
+		file, err := os.Create(loginCapture)
+		if err != nil {
+			t.Fatalf("Creating the golden capture threw an error: %v", err)
+		}
+		defer file.Close()
+		if err := GameSpy.WriteCapture(file, capture); err != nil {
+			t.Fatalf("Writing the golden capture threw an error: %v", err)
+		}
+	}
+}
+
+func TestReplayLogin(t *testing.T) {
+	capture, err := GameSpy.ReadCaptureFile(loginCapture)
+	if err != nil {
+		t.Fatalf("Reading the golden capture threw an error: %v", err)
+	}
+
+	listener, _ := startLoginServer(t, "", "Heroes")
+	replayer := GameSpy.Replayer{Dial: listener.Dial, Timeout: time.Second, Ignore: []string{"lkey"}}
+	result, err := replayer.Replay(context.Background(), capture)
+	if err != nil {
+		t.Fatalf("Replay threw an error: %v", err)
+	}
+	if !result.Ok() || result.Sent != 3 || result.Received != 3 {
+		t.Errorf("Replay was incorrect: %v", result)
+	}
+
+	// A changed response has to show up
+	listener, _ = startLoginServer(t, "", "Villains")
+	replayer.Dial = listener.Dial
+	result, err = replayer.Replay(context.Background(), capture)
+	if err != nil {
+		t.Fatalf("Replay threw an error: %v", err)
+	}
+	if len(result.Diffs) != 1 || result.Diffs[0].Record != 3 || result.Diffs[0].Reason != `key "displayName" is "Villains" instead of "Heroes"` {
+		t.Errorf("Replay found the wrong differences: %v", result)
+	}
+}
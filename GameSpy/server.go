@@ -0,0 +1,723 @@
+package GameSpy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// Server is the protocol-agnostic core behind Socket, SocketTLS and
+// SocketUDP. It accepts clients over a Transport, cuts their traffic
+// into frames with a Framer and publishes everything as events.
+//
+// A new service is a Framer plus handlers for its events:
+//
+//	server := &GameSpy.Server{Name: "GPCM", Framer: GameSpy.GameSpyFramer{}}
+//	eventsChannel, err := server.Listen(ctx, listener)
+type Server struct {
+	// Name shows up in the logs
+	Name string
+	// Framer cuts the traffic into frames. Defaults to GameSpyFramer.
+	Framer Framer
+	// Transport prepares accepted connections. Defaults to TCPTransport.
+	Transport Transport
+	// Clients holds all connected clients
+	Clients []*Client
+
+	// Goodbye is called for every connected client when the server
+	// shuts down, before waiting for the clients to disconnect
+	Goodbye func(client *Client)
+
+	// Writer configures the outbound queue of every client. Zero values
+	// take those of DefaultWriterConfig.
+	Writer WriterConfig
+
+	// Proxy enables the PROXY protocol for connections from trusted
+	// load balancers. The header is read before the Transport handshake.
+	Proxy ProxyConfig
+
+	// RateLimit limits the messages every client may send
+	RateLimit RateLimitConfig
+
+	// Crashes configures the crash reports written when handling a
+	// client or a datagram panics
+	Crashes CrashConfig
+
+	// Admission decides which connections and UDP peers are accepted.
+	// nil accepts everybody.
+	Admission *Admission
+
+	// Record writes the traffic of every client and UDP session to a
+	// capture file, see Replayer
+	Record RecordConfig
+
+	// Upstream turns the server into a proxy to another server, logging
+	// the traffic in both directions
+	Upstream UpstreamConfig
+
+	// Sessions configures the session table of servers handling
+	// datagrams. The zero value means DefaultSessionConfig.
+	Sessions SessionConfig
+
+	listen   net.Listener
+	packets  net.PacketConn
+	sessions *sessionTable
+	events   *EventBus[SocketEvent]
+	publish  func(event SocketEvent)
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mutex    sync.Mutex
+	handlers sync.WaitGroup
+	closing  bool
+	// shutDown is set once Shutdown or Drain ran, Close only sets closing
+	shutDown bool
+	lastID   uint64
+}
+
+// Listen accepts clients on listener until ctx is done or the server is
+// shut down. The returned channel receives every event.
+func (server *Server) Listen(ctx context.Context, listener net.Listener) (chan SocketEvent, error) {
+	events, err := server.subscribeAll()
+	if err != nil {
+		return nil, err
+	}
+
+	server.serve(ctx, listener)
+
+	return events.C, nil
+}
+
+// ListenPacket handles datagrams on conn until ctx is done or the server
+// is shut down. The returned channel receives every event.
+func (server *Server) ListenPacket(ctx context.Context, conn net.PacketConn) (chan SocketEvent, error) {
+	events, err := server.subscribeAll()
+	if err != nil {
+		return nil, err
+	}
+
+	server.servePacket(ctx, conn)
+
+	return events.C, nil
+}
+
+// Events returns the event bus of the server. Subscribe to it for more
+// consumers than the channel returned by Listen.
+func (server *Server) Events() *EventBus[SocketEvent] {
+	server.init()
+	return server.events
+}
+
+// Addr returns the address the server is listening on, or nil if it
+// isn't listening yet
+func (server *Server) Addr() net.Addr {
+	if server.listen != nil {
+		return server.listen.Addr()
+	}
+	if server.packets != nil {
+		return server.packets.LocalAddr()
+	}
+	return nil
+}
+
+// Close fires a close-event and closes the socket. The clients stay
+// connected, Shutdown or Drain still wait for them and release the
+// server afterwards.
+func (server *Server) Close() {
+	// Fire closing event
+	log.Noteln(server.Name + " closing.")
+	server.publish(SocketEvent{
+		Name: string(KindClose),
+		Data: EventClose{},
+	})
+
+	// Close socket
+	server.mutex.Lock()
+	server.closing = true
+	server.mutex.Unlock()
+	server.closeListener()
+}
+
+// Shutdown gracefully shuts the server down. It stops accepting new
+// connections, says goodbye to every client and waits for the clients
+// to disconnect. When ctx is done before that, all remaining clients
+// are closed forcefully and ctx.Err() is returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	return server.shutdown(ctx, true)
+}
+
+// Drain stops accepting new connections and waits for the connected
+// clients to leave on their own, e.g. after handing the listener over to
+// a new process. Clients still there when ctx is done are closed.
+func (server *Server) Drain(ctx context.Context) error {
+	return server.shutdown(ctx, false)
+}
+
+func (server *Server) shutdown(ctx context.Context, goodbye bool) error {
+	server.mutex.Lock()
+	if server.shutDown || server.cancel == nil {
+		server.mutex.Unlock()
+		return nil
+	}
+	server.shutDown = true
+	// Close fired the close-event already
+	closed := server.closing
+	server.closing = true
+	clients := make([]*Client, len(server.Clients))
+	copy(clients, server.Clients)
+	server.mutex.Unlock()
+
+	log.Noteln(server.Name + " shutting down.")
+	server.closeListener()
+
+	if goodbye && server.Goodbye != nil {
+		for _, client := range clients {
+			server.Goodbye(client)
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		server.handlers.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Notef("%s: Shutdown deadline reached, closing remaining clients. %v", server.Name, err)
+
+		server.mutex.Lock()
+		for _, client := range server.Clients {
+			client.conn.Close()
+		}
+		server.mutex.Unlock()
+	}
+
+	// Fire closing event, unless nobody is listening anymore
+	if !closed {
+		published := make(chan struct{})
+		go func() {
+			server.publish(SocketEvent{Name: string(KindClose), Data: EventClose{}})
+			close(published)
+		}()
+		select {
+		case <-published:
+		case <-ctx.Done():
+		}
+	}
+
+	server.cancel()
+	return err
+}
+
+// WriteTo sends a single frame to addr. Only works for servers handling
+// datagrams.
+func (server *Server) WriteTo(frame []byte, addr net.Addr) error {
+	if server.packets == nil {
+		return errors.New("server is not handling datagrams")
+	}
+
+	if session, ok := server.Session(udpAddr(addr)); ok {
+		session.capture.record(Outbound, frame)
+	}
+
+	_, err := server.packets.WriteTo(server.Framer.Encode(frame), addr)
+	if err != nil {
+		log.Errorf("%s: Error writing to %v. %v", server.Name, addr, err)
+		server.publish(SocketEvent{
+			Name: string(KindError),
+			Data: EventUDPError{
+				Addr:  udpAddr(addr),
+				Error: err,
+			},
+		})
+	}
+	return err
+}
+
+func (server *Server) init() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.events == nil {
+		server.events = new(EventBus[SocketEvent])
+	}
+	if server.publish == nil {
+		server.publish = server.events.Publish
+	}
+	if server.Framer == nil {
+		server.Framer = GameSpyFramer{}
+	}
+	if server.Transport == nil {
+		server.Transport = TCPTransport{}
+	}
+}
+
+func (server *Server) subscribeAll() (*Subscription[SocketEvent], error) {
+	return server.Events().Subscribe("*", SubscribeOptions{
+		Buffer: 1000,
+		Policy: OverflowBlock,
+	})
+}
+
+func (server *Server) start(ctx context.Context) {
+	server.init()
+	server.ctx, server.cancel = context.WithCancel(ctx)
+
+	// Tear everything down right away once our context is gone
+	go func() {
+		<-server.ctx.Done()
+		server.Shutdown(server.ctx)
+	}()
+}
+
+// serve accepts clients on listener in the background
+func (server *Server) serve(ctx context.Context, listener net.Listener) {
+	server.listen = listener
+	server.start(ctx)
+
+	// Accept new connections in a new Goroutine("thread")
+	go server.run()
+}
+
+// servePacket handles datagrams on conn in the background
+func (server *Server) servePacket(ctx context.Context, conn net.PacketConn) {
+	server.packets = conn
+	server.sessions = newSessionTable(server.Sessions)
+	server.start(ctx)
+
+	server.handlers.Add(1)
+	go server.runPacket()
+	go server.expireSessions()
+}
+
+func (server *Server) closeListener() {
+	if server.listen != nil {
+		server.listen.Close()
+	}
+	if server.packets != nil {
+		server.packets.Close()
+	}
+}
+
+// nextID hands out the IDs of clients and sessions
+func (server *Server) nextID() uint64 {
+	return atomic.AddUint64(&server.lastID, 1)
+}
+
+func (server *Server) isClosing() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.closing
+}
+
+func (server *Server) run() {
+	for {
+		// Listen for an incoming connection.
+		conn, err := server.listen.Accept()
+		if err != nil {
+			if server.isClosing() {
+				return
+			}
+			log.Errorf("%s: A new client connecting threw an error.\n%v", server.Name, err)
+			server.publish(SocketEvent{
+				Name: string(KindError),
+				Data: EventError{
+					Error: err,
+				},
+			})
+			continue
+		}
+
+		// The handshake counts as in-flight, so a shutdown waits for it.
+		// It runs for every client on its own, so a slow client can't
+		// hold up the others.
+		server.handlers.Add(1)
+		go server.accept(conn)
+	}
+}
+
+func (server *Server) accept(conn net.Conn) {
+	defer server.handlers.Done()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Errorln(server.Name+": Panic accepting a client, closing it.", recovered, string(debug.Stack()))
+			conn.Close()
+		}
+	}()
+
+	raw := conn
+	conn, err := server.Proxy.acceptProxy(conn)
+	if err != nil {
+		raw.Close()
+		log.Errorf("%s: Reading the PROXY protocol header threw an error.\n%v", server.Name, err)
+		return
+	}
+
+	// Checked after the PROXY protocol, so limits apply to real clients,
+	// but before the handshake, so rejected clients are cheap
+	if server.Admission != nil {
+		if err := server.Admission.Admit(conn.RemoteAddr()); err != nil {
+			raw.Close()
+			return
+		}
+	}
+
+	remote := conn.RemoteAddr()
+	conn, err = server.Transport.Handshake(conn)
+	if err != nil {
+		raw.Close()
+		if server.Admission != nil {
+			server.Admission.Release(remote)
+		}
+		log.Errorf("%s: A new client connecting threw an error.\n%v", server.Name, err)
+		server.publish(SocketEvent{
+			Name: string(KindError),
+			Data: EventError{
+				Error: err,
+			},
+		})
+		return
+	}
+
+	// Create a new Client and add it to our slice
+	newClient := new(Client)
+	if server.Upstream.enabled() {
+		newClient.upstream, err = server.openUpstream(remote, "tcp", func(frame []byte) {
+			newClient.capture.record(Outbound, frame)
+			newClient.writer.Write(newClient.framer.Encode(frame))
+		}, newClient.disconnect)
+		if err != nil {
+			raw.Close()
+			if server.Admission != nil {
+				server.Admission.Release(remote)
+			}
+			log.Errorf("%s: Connecting to upstream %s threw an error.\n%v", server.Name, server.Upstream.Address, err)
+			server.publish(SocketEvent{
+				Name: string(KindError),
+				Data: EventError{
+					Error: err,
+				},
+			})
+			return
+		}
+	}
+	newClient.writerConfig = server.Writer
+	newClient.framer = server.Framer
+	newClient.rateLimit = server.RateLimit
+	newClient.crashConfig = server.Crashes
+	newClient.ID = server.nextID()
+	newClient.capture = server.Record.open(server.Name, server.Framer, newClient.ID, conn.RemoteAddr(), false)
+	_, newClient.FESL = server.Framer.(FESLFramer)
+	clientEventSocket, err := newClient.New(server.Name, conn)
+	if err != nil {
+		newClient.capture.Close()
+		newClient.upstream.Close()
+		if server.Admission != nil {
+			server.Admission.Release(remote)
+		}
+		log.Errorf("%s: Creating the new client threw an error.\n%v", server.Name, err)
+		server.publish(SocketEvent{
+			Name: string(KindError),
+			Data: EventError{
+				Error: err,
+			},
+		})
+		conn.Close()
+		return
+	}
+
+	// Added before anything can close it, so removeClient finds it
+	log.Noteln(server.Name + ": A new client connected")
+	server.mutex.Lock()
+	server.Clients = append(server.Clients, newClient)
+	server.mutex.Unlock()
+
+	server.handlers.Add(1)
+	go server.handleClientEvents(newClient, clientEventSocket)
+	newClient.upstream.start()
+
+	// Fire newClient event
+	server.publish(SocketEvent{
+		Name: string(KindNewClient),
+		Data: EventNewClient{
+			Client: newClient,
+		},
+	})
+}
+
+func (server *Server) removeClient(client *Client) error {
+	var indexToRemove = 0
+	var foundClient = false
+
+	log.Debugln("Removing client ", client)
+
+	client.active.Store(false)
+	// Send out what's still queued, e.g. a final error, before closing
+	client.writer.Close()
+	client.conn.Close()
+
+	// Whatever the client holds is given back even if it's not listed
+	client.releaseOnce.Do(func() {
+		client.markClosed()
+		client.capture.Close()
+		client.upstream.Close()
+
+		if server.Admission != nil {
+			server.Admission.Release(client.IpAddr)
+		}
+	})
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for i := range server.Clients {
+		if server.Clients[i] == client {
+			indexToRemove = i
+			foundClient = true
+			break
+		}
+	}
+
+	if !foundClient {
+		return errors.New("could not find client to remove")
+	}
+
+	log.Debugln("Found client as ", indexToRemove)
+
+	if len(server.Clients) == 1 {
+		// We have only one element, so create a new one
+		server.Clients = []*Client{}
+		return nil
+	}
+
+	// Replace our client set to remove with the last client in the array
+	// and then cut the last element of the array
+	server.Clients[indexToRemove] = server.Clients[len(server.Clients)-1]
+	server.Clients = server.Clients[:len(server.Clients)-1]
+
+	log.Debugln("Client removed")
+	return nil
+}
+
+// handleClientEvents publishes the events of client until it closes and
+// removes it from the server afterwards
+func (server *Server) handleClientEvents(client *Client, eventsChannel chan ClientEvent) {
+	defer server.handlers.Done()
+
+	for {
+		event := <-eventsChannel
+		server.handleClientEvent(client, event)
+		if event.Name == "close" {
+			break
+		}
+	}
+
+	if err := server.removeClient(client); err != nil {
+		log.Errorln("Could not remove client", err)
+	}
+}
+
+// handleClientEvent publishes a single event of client. A panic only
+// closes that client.
+func (server *Server) handleClientEvent(client *Client, event ClientEvent) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			server.publishPanic(client, client.crash("event "+event.Name, recovered, nil))
+			client.disconnect()
+		}
+	}()
+
+	switch {
+	case event.Name == "close":
+		server.publish(SocketEvent{
+			Name: "client." + event.Name,
+			Data: EventClientClose{
+				Client: client,
+			},
+		})
+	case event.Name == "panic":
+		server.publishPanic(client, event.Data.(*CrashReport))
+	case strings.Index(event.Name, "command") != -1:
+		switch command := event.Data.(type) {
+		case *CommandFESL:
+			server.publish(SocketEvent{
+				Name: "client." + event.Name,
+				Data: EventClientFESLCommand{
+					Client:  client,
+					Command: command,
+				},
+			})
+		case *Command:
+			server.publish(SocketEvent{
+				Name: "client." + event.Name,
+				Data: EventClientCommand{
+					Client:  client,
+					Command: command,
+				},
+			})
+		}
+	case event.Name == "data":
+		server.publish(SocketEvent{
+			Name: "client." + event.Name,
+			Data: EventClientData{
+				Client: client,
+				Data:   event.Data.(string),
+			},
+		})
+	case event.Name == "error":
+		server.publish(SocketEvent{
+			Name: string(KindClientError),
+			Data: EventClientError{
+				Client: client,
+				Error:  event.Data.(error),
+			},
+		})
+	default:
+		log.Debugf("%s: Dropping unknown client event %s", server.Name, event.Name)
+	}
+}
+
+func (server *Server) publishPanic(client *Client, report *CrashReport) {
+	server.publish(SocketEvent{
+		Name: string(KindClientPanic),
+		Data: EventClientPanic{
+			Client: client,
+			Report: report,
+		},
+	})
+}
+
+func (server *Server) runPacket() {
+	defer server.handlers.Done()
+	defer server.closeSessions()
+
+	buf := make([]byte, 4096)
+
+	for {
+		n, addr, err := server.packets.ReadFrom(buf)
+		if err != nil {
+			if server.isClosing() {
+				return
+			}
+			log.Errorf("%s: Error reading from UDP.%v", server.Name, err)
+			server.publish(SocketEvent{
+				Name: string(KindError),
+				Data: EventUDPError{
+					Addr:  udpAddr(addr),
+					Error: err,
+				},
+			})
+			continue
+		}
+
+		server.handlePacket(buf[:n], udpAddr(addr))
+	}
+}
+
+// handlePacket runs a single datagram through the framer. Datagrams are
+// never buffered, whatever doesn't make up a whole frame is dropped.
+// Peers get a session with their first readable message.
+func (server *Server) handlePacket(datagram []byte, addr *net.UDPAddr) {
+	data := datagram
+
+	// A panic only drops the datagram, the peers carry on
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			server.publishPanic(nil, server.packetCrash(addr, datagram, recovered))
+		}
+	}()
+
+	var session *Session
+
+	for len(data) > 0 {
+		advance, frame, err := server.Framer.Split(data)
+		if err == nil && advance == 0 {
+			err = ErrInvalidFrame
+		}
+		if err != nil {
+			log.Errorf("%s: Dropping unreadable datagram from %v. %v", server.Name, addr, err)
+			server.publish(SocketEvent{
+				Name: string(KindError),
+				Data: EventUDPError{
+					Addr:  addr,
+					Error: err,
+				},
+			})
+			return
+		}
+		data = data[advance:]
+
+		message, err := server.Framer.Decode(frame)
+		if err != nil {
+			log.Errorf("%s: Error processing datagram from %v.\n%v", server.Name, addr, err)
+			server.publish(SocketEvent{
+				Name: string(KindError),
+				Data: EventUDPError{
+					Addr:  addr,
+					Error: err,
+				},
+			})
+			continue
+		}
+		if message == nil {
+			continue
+		}
+
+		if session == nil {
+			session = server.peerSession(addr)
+			if session == nil {
+				return
+			}
+		}
+		session.capture.record(Inbound, frame)
+		session.forwardUpstream(frame)
+
+		var payload Event
+		switch command := message.(type) {
+		case *Command:
+			log.Debugln("Got UDP message:", string(frame))
+			server.publish(SocketEvent{
+				Name: string(KindData),
+				Data: EventUDPData{
+					Addr:    addr,
+					Session: session,
+					Data:    strings.TrimSpace(string(frame)),
+				},
+			})
+			payload = EventUDPCommand{
+				Addr:    addr,
+				Session: session,
+				Command: command,
+			}
+		case *CommandFESL:
+			payload = EventUDPFESLCommand{
+				Addr:    addr,
+				Session: session,
+				Command: command,
+			}
+		}
+
+		server.publish(SocketEvent{
+			Name: string(KindCommand) + "." + message.eventName(),
+			Data: payload,
+		})
+		server.publish(SocketEvent{
+			Name: string(KindCommand),
+			Data: payload,
+		})
+	}
+}
+
+func udpAddr(addr net.Addr) *net.UDPAddr {
+	udpAddr, _ := addr.(*net.UDPAddr)
+	return udpAddr
+}
@@ -0,0 +1,146 @@
+package GameSpy_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func nextSocketEvent(t *testing.T, events chan GameSpy.SocketEvent, name string) GameSpy.SocketEvent {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Name == name {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("Server fired no %s event.", name)
+		}
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening threw an error: %v", err)
+	}
+
+	server := &GameSpy.Server{Name: "test"}
+	server.Goodbye = func(client *GameSpy.Client) {
+		client.Write("\\bye\\final\\")
+	}
+	events, err := server.Listen(context.Background(), listener)
+	if err != nil {
+		t.Fatalf("Listen threw an error: %v", err)
+	}
+
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	conn.Write([]byte("\\ka\\\\final\\"))
+	event := nextSocketEvent(t, events, "client.command.ka")
+	if _, ok := event.Data.(GameSpy.EventClientCommand); !ok {
+		t.Errorf("Command event had the wrong payload: %T.", event.Data)
+	}
+
+	// Hang up as soon as the server says goodbye
+	go func() {
+		io.ReadFull(conn, make([]byte, len("\\bye\\final\\")))
+		conn.Close()
+	}()
+	go func() {
+		for range events {
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown did not drain the clients: %v", err)
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{Name: "test"}
+	events, _ := server.Listen(context.Background(), listener)
+
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	defer conn.Close()
+	nextSocketEvent(t, events, "newClient")
+	go func() {
+		for range events {
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown was incorrect, got: %v, want: %v.", err, context.DeadlineExceeded)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Shutdown did not close the client, got: %v.", err)
+	}
+}
+
+func TestServerShutdownAfterClose(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{Name: "test"}
+	events, _ := server.Listen(context.Background(), listener)
+
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	defer conn.Close()
+	nextSocketEvent(t, events, "newClient")
+	go func() {
+		for range events {
+		}
+	}()
+
+	// Close leaves the clients alone, Shutdown still has to wait for them
+	server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown after Close was incorrect, got: %v, want: %v.", err, context.DeadlineExceeded)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Shutdown did not close the client, got: %v.", err)
+	}
+}
+
+func TestServerPacket(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening threw an error: %v", err)
+	}
+
+	framer := GameSpy.XORFramer{Framer: GameSpy.GameSpyFramer{}}
+	server := &GameSpy.Server{Name: "test", Framer: framer}
+	events, _ := server.ListenPacket(context.Background(), packetConn)
+	defer server.Shutdown(context.Background())
+
+	conn, _ := net.Dial("udp", packetConn.LocalAddr().String())
+	defer conn.Close()
+	conn.Write(framer.Encode([]byte("\\heartbeat\\1\\statechanged\\1")))
+
+	event := nextSocketEvent(t, events, "command.heartbeat")
+	command, ok := event.Data.(GameSpy.EventUDPCommand)
+	if !ok || command.Command.Message["statechanged"] != "1" {
+		t.Fatalf("Command event was incorrect, got: %+v.", event.Data)
+	}
+
+	server.WriteTo([]byte("\\ok\\"), command.Addr)
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _ := conn.Read(buf)
+	if _, reply, _ := framer.Split(buf[:n]); string(reply) != "\\ok\\" {
+		t.Errorf("Reply was incorrect, got: %q.", reply)
+	}
+}
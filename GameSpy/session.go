@@ -0,0 +1,411 @@
+package GameSpy
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// SessionConfig configures the session table of servers handling
+// datagrams
+type SessionConfig struct {
+	// IdleTimeout is how long a session lives without a datagram from
+	// its peer
+	IdleTimeout time.Duration
+	// MaxSessions caps the number of tracked peers. Datagrams of new
+	// peers are dropped while the table is full, so a flood of spoofed
+	// sources can't push out the sessions of real players.
+	MaxSessions int
+}
+
+// ErrSessionTableFull is returned when a new peer shows up while the
+// session table is full
+var ErrSessionTableFull = errors.New("session table is full")
+
+// DefaultSessionConfig is used by servers which got no SessionConfig
+var DefaultSessionConfig = SessionConfig{
+	IdleTimeout: 2 * time.Minute,
+	MaxSessions: 10000,
+}
+
+// Session is the state of a single UDP peer. It lives as long as the
+// peer keeps sending datagrams.
+type Session struct {
+	// ID is unique among the clients and sessions of a server
+	ID      uint64
+	Addr    *net.UDPAddr
+	Created time.Time
+
+	capture *CaptureWriter
+	mutex   sync.Mutex
+	// upstream is dialled once the session is in the table. ended is set
+	// when the session is released, an upstream dialled after that is
+	// closed right away.
+	upstream *upstreamConn
+	ended    bool
+	lastSeen time.Time
+	state    map[string]interface{}
+}
+
+// LastSeen returns when the last datagram of the peer came in
+func (session *Session) LastSeen() time.Time {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.lastSeen
+}
+
+// Get returns the value stored under key
+func (session *Session) Get(key string) (interface{}, bool) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	value, ok := session.state[key]
+	return value, ok
+}
+
+// Set stores value under key
+func (session *Session) Set(key string, value interface{}) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.state == nil {
+		session.state = make(map[string]interface{})
+	}
+	session.state[key] = value
+}
+
+// Delete removes key from the session
+func (session *Session) Delete(key string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	delete(session.state, key)
+}
+
+// setUpstream attaches the upstream connection to the session. It
+// reports false if the session ended meanwhile.
+func (session *Session) setUpstream(upstream *upstreamConn) bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.ended {
+		return false
+	}
+	session.upstream = upstream
+	return true
+}
+
+// forwardUpstream sends a frame of the peer upstream, if there is an
+// upstream connection
+func (session *Session) forwardUpstream(frame []byte) {
+	session.mutex.Lock()
+	upstream := session.upstream
+	session.mutex.Unlock()
+	upstream.forward(frame)
+}
+
+// end closes the recording and the upstream connection of the session
+func (session *Session) end() {
+	session.mutex.Lock()
+	session.ended = true
+	upstream := session.upstream
+	session.mutex.Unlock()
+
+	session.capture.Close()
+	upstream.Close()
+}
+
+// SessionStats are the counters of a session table
+type SessionStats struct {
+	Active   int
+	Created  uint64
+	Expired  uint64
+	Rejected uint64
+}
+
+// sessionTable tracks the peers of a server handling datagrams
+type sessionTable struct {
+	config   SessionConfig
+	mutex    sync.Mutex
+	sessions map[string]*Session
+	created  uint64
+	expired  uint64
+	rejected uint64
+}
+
+func newSessionTable(config SessionConfig) *sessionTable {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultSessionConfig.IdleTimeout
+	}
+	if config.MaxSessions <= 0 {
+		config.MaxSessions = DefaultSessionConfig.MaxSessions
+	}
+
+	return &sessionTable{
+		config:   config,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// get returns the session of addr, if there is one
+func (table *sessionTable) get(addr *net.UDPAddr) (*Session, bool) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	session, ok := table.sessions[addr.String()]
+	return session, ok
+}
+
+// touch returns the session of addr and marks it as seen. A new session
+// is created for unknown peers, unless the table is full, and handed to
+// init before anybody else can see it. The second return value reports
+// whether the session is new.
+func (table *sessionTable) touch(addr *net.UDPAddr, now time.Time, init func(session *Session)) (*Session, bool, error) {
+	key := addr.String()
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	if session, ok := table.sessions[key]; ok {
+		session.mutex.Lock()
+		session.lastSeen = now
+		session.mutex.Unlock()
+		return session, false, nil
+	}
+
+	if len(table.sessions) >= table.config.MaxSessions {
+		atomic.AddUint64(&table.rejected, 1)
+		return nil, false, ErrSessionTableFull
+	}
+
+	session := &Session{
+		Addr:     addr,
+		Created:  now,
+		lastSeen: now,
+	}
+	init(session)
+	table.sessions[key] = session
+	atomic.AddUint64(&table.created, 1)
+
+	return session, true, nil
+}
+
+// remove drops the session of addr and returns it
+func (table *sessionTable) remove(addr *net.UDPAddr) (*Session, bool) {
+	key := addr.String()
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	session, ok := table.sessions[key]
+	if ok {
+		delete(table.sessions, key)
+	}
+	return session, ok
+}
+
+// removeSession drops session unless it's gone already. A later session
+// of the same peer is left alone.
+func (table *sessionTable) removeSession(session *Session) bool {
+	key := session.Addr.String()
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	if table.sessions[key] != session {
+		return false
+	}
+	delete(table.sessions, key)
+	return true
+}
+
+// expire removes and returns all sessions idle since before now minus
+// the idle timeout
+func (table *sessionTable) expire(now time.Time) []*Session {
+	deadline := now.Add(-table.config.IdleTimeout)
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	var expired []*Session
+	for key, session := range table.sessions {
+		if session.LastSeen().Before(deadline) {
+			delete(table.sessions, key)
+			expired = append(expired, session)
+		}
+	}
+	atomic.AddUint64(&table.expired, uint64(len(expired)))
+
+	return expired
+}
+
+// removeAll removes and returns all sessions
+func (table *sessionTable) removeAll() []*Session {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	sessions := make([]*Session, 0, len(table.sessions))
+	for key, session := range table.sessions {
+		delete(table.sessions, key)
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+func (table *sessionTable) stats() SessionStats {
+	table.mutex.Lock()
+	active := len(table.sessions)
+	table.mutex.Unlock()
+
+	return SessionStats{
+		Active:   active,
+		Created:  atomic.LoadUint64(&table.created),
+		Expired:  atomic.LoadUint64(&table.expired),
+		Rejected: atomic.LoadUint64(&table.rejected),
+	}
+}
+
+// expireSessions drops idle sessions until the server is gone
+// closeSessions releases all sessions left once the server stops reading
+func (server *Server) closeSessions() {
+	for _, session := range server.sessions.removeAll() {
+		server.releaseSession(session)
+	}
+}
+
+func (server *Server) expireSessions() {
+	interval := server.sessions.config.IdleTimeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, session := range server.sessions.expire(now) {
+				log.Debugf("%s: Session of %v expired.", server.Name, session.Addr)
+				server.releaseSession(session)
+				server.publish(SocketEvent{
+					Name: string(KindSessionExpired),
+					Data: EventSessionExpired{
+						Addr:    session.Addr,
+						Session: session,
+					},
+				})
+			}
+		}
+	}
+}
+
+// Session returns the session of addr. Only works for servers handling
+// datagrams.
+func (server *Server) Session(addr *net.UDPAddr) (*Session, bool) {
+	if server.sessions == nil {
+		return nil, false
+	}
+	return server.sessions.get(addr)
+}
+
+// EndSession drops the session of addr right away, e.g. once a NAT
+// negotiation is done. No expired event is fired for it.
+func (server *Server) EndSession(addr *net.UDPAddr) {
+	if server.sessions == nil {
+		return
+	}
+	if session, ok := server.sessions.remove(addr); ok {
+		server.releaseSession(session)
+	}
+}
+
+// endSession drops session once its upstream connection hung up, like
+// EndSession
+func (server *Server) endSession(session *Session) {
+	if server.sessions.removeSession(session) {
+		log.Debugf("%s: Upstream of session %v is gone, ending it.", server.Name, session.Addr)
+		server.releaseSession(session)
+	}
+}
+
+// releaseSession hands the slot of a gone session back to the admission
+// and ends its recording and upstream connection
+func (server *Server) releaseSession(session *Session) {
+	session.end()
+	if server.Admission != nil {
+		server.Admission.Release(session.Addr)
+	}
+}
+
+// SessionStats returns the counters of the session table
+func (server *Server) SessionStats() SessionStats {
+	if server.sessions == nil {
+		return SessionStats{}
+	}
+	return server.sessions.stats()
+}
+
+// peerSession returns the session of addr for an incoming datagram and fires
+// the new-event for new peers. It returns nil when the table is full.
+func (server *Server) peerSession(addr *net.UDPAddr) *Session {
+	// New peers have to pass the admission like any connection
+	admitted := false
+	if server.Admission != nil {
+		if _, ok := server.sessions.get(addr); !ok {
+			if err := server.Admission.Admit(addr); err != nil {
+				return nil
+			}
+			admitted = true
+		}
+	}
+
+	session, created, err := server.sessions.touch(addr, time.Now(), func(session *Session) {
+		session.ID = server.nextID()
+		session.capture = server.Record.open(server.Name, server.Framer, session.ID, addr, true)
+	})
+	if err != nil {
+		log.Debugf("%s: Dropping datagram from %v. %v", server.Name, addr, err)
+		if admitted {
+			server.Admission.Release(addr)
+		}
+		return nil
+	}
+
+	if created {
+		if server.Upstream.enabled() {
+			server.connectUpstream(session)
+		}
+		log.Debugf("%s: New session for %v.", server.Name, addr)
+		server.publish(SocketEvent{
+			Name: string(KindSessionNew),
+			Data: EventSessionNew{
+				Addr:    addr,
+				Session: session,
+			},
+		})
+	}
+	return session
+}
+
+// connectUpstream dials the upstream connection of a new session. Every
+// peer gets a socket of its own, so the answers find their way back. The
+// session table isn't locked meanwhile.
+func (server *Server) connectUpstream(session *Session) {
+	addr := session.Addr
+	upstream, err := server.openUpstream(addr, "udp", func(frame []byte) {
+		server.WriteTo(frame, addr)
+	}, func() {
+		server.endSession(session)
+	})
+	if err != nil {
+		log.Errorf("%s: Connecting to upstream %s threw an error.\n%v", server.Name, server.Upstream.Address, err)
+		return
+	}
+	if !session.setUpstream(upstream) {
+		upstream.Close()
+		return
+	}
+	upstream.start()
+}
@@ -0,0 +1,77 @@
+package GameSpy_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func nextUDPEvent(t *testing.T, events chan GameSpy.SocketUDPEvent, name string) GameSpy.SocketUDPEvent {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Name == name {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("Socket fired no %s event.", name)
+		}
+	}
+}
+
+func TestSocketUDPSessions(t *testing.T) {
+	socket := &GameSpy.SocketUDP{}
+	socket.Framer = GameSpy.GameSpyFramer{}
+	socket.Sessions = GameSpy.SessionConfig{
+		IdleTimeout: 50 * time.Millisecond,
+		MaxSessions: 1,
+	}
+	events, err := socket.NewContext(context.Background(), "test", "0", false)
+	if err != nil {
+		t.Fatalf("NewContext threw an error: %v", err)
+	}
+	defer socket.Shutdown(context.Background())
+
+	// The socket is bound to a random port, ask it which one
+	addr := socket.Addr().String()
+	conn, _ := net.Dial("udp", addr)
+	defer conn.Close()
+	conn.Write([]byte("\\heartbeat\\1\\final\\"))
+
+	event := nextUDPEvent(t, events, "session.new")
+	session, ok := GameSpy.Payload[GameSpy.EventSessionNew](event)
+	if !ok || event.Addr.String() != conn.LocalAddr().String() {
+		t.Fatalf("New session event was incorrect, got: %+v.", event)
+	}
+	session.Session.Set("challenge", "abc")
+
+	conn.Write([]byte("\\heartbeat\\2\\final\\"))
+	// Skip the command of the first datagram
+	nextUDPEvent(t, events, "command.heartbeat")
+	command, _ := GameSpy.Payload[GameSpy.EventUDPCommand](nextUDPEvent(t, events, "command.heartbeat"))
+	if challenge, _ := command.Session.Get("challenge"); challenge != "abc" {
+		t.Errorf("Session state was lost, got: %v.", challenge)
+	}
+
+	// The table is full, a second peer is ignored
+	other, _ := net.Dial("udp", addr)
+	defer other.Close()
+	other.Write([]byte("\\heartbeat\\1\\final\\"))
+
+	event = nextUDPEvent(t, events, "session.expired")
+	if event.Addr.String() != conn.LocalAddr().String() {
+		t.Errorf("Wrong session expired, got: %v.", event.Addr)
+	}
+	if _, ok := socket.Session(event.Addr); ok {
+		t.Errorf("Expired session is still tracked.")
+	}
+
+	stats := socket.SessionStats()
+	if stats.Created != 1 || stats.Expired != 1 || stats.Rejected != 1 {
+		t.Errorf("Session stats were incorrect, got: %+v.", stats)
+	}
+}
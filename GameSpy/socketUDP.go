@@ -1,226 +1,103 @@
 package GameSpy
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
+	"context"
 	"net"
-	"strings"
 
 	log "github.com/HeroesAwaken/GoAwaken/Log"
 )
 
-// Socket is a basic event-based TCP-Server
+// SocketUDP is a basic event-based UDP-Server
 type SocketUDP struct {
-	Clients   []*Client
-	name      string
-	port      string
-	listen    *net.UDPConn
-	eventChan chan SocketUDPEvent
-	fesl      bool
+	Server
+	address   string
+	udpEvents *EventBus[SocketUDPEvent]
 }
 
-type SocketUDPEvent struct {
-	Name string
-	Addr *net.UDPAddr
-	Data interface{}
+// New starts to listen on a new Socket. address is a port or a full
+// listen address, see Listen.
+func (socket *SocketUDP) New(name string, address string, fesl bool) (chan SocketUDPEvent, error) {
+	return socket.NewContext(context.Background(), name, address, fesl)
 }
 
-// New starts to listen on a new Socket
-func (socket *SocketUDP) New(name string, port string, fesl bool) (chan SocketUDPEvent, error) {
-	var err error
+// NewContext starts to listen on a new Socket which is closed as soon
+// as ctx is done
+func (socket *SocketUDP) NewContext(ctx context.Context, name string, address string, fesl bool) (chan SocketUDPEvent, error) {
+	socket.Name = name
+	socket.address = address
+
+	if socket.Framer == nil {
+		if fesl {
+			socket.Framer = FESLFramer{}
+		} else {
+			socket.Framer = XORFramer{Framer: GameSpyFramer{}}
+		}
+	}
 
-	socket.name = name
-	socket.port = port
-	socket.eventChan = make(chan SocketUDPEvent, 1000)
-	socket.fesl = fesl
+	socket.udpEvents = new(EventBus[SocketUDPEvent])
+	events, _ := socket.udpEvents.Subscribe("*", SubscribeOptions{
+		Buffer: 1000,
+		Policy: OverflowBlock,
+	})
+	socket.publish = socket.publishUDP
 
 	// Listen for incoming connections.
-	ServerAddr, err := net.ResolveUDPAddr("udp", "0.0.0.0:"+socket.port)
+	listen, err := ListenPacket("udp", socket.address)
 	if err != nil {
-		log.Errorf("%s: Listening on 0.0.0.0:%s threw an error.\n%v", socket.name, socket.port, err)
+		log.Errorf("%s: Listening on %s threw an error.\n%v", socket.Name, socket.address, err)
 		return nil, err
 	}
+	log.Noteln(socket.Name + ": Listening on " + listen.LocalAddr().String())
 
-	socket.listen, err = net.ListenUDP("udp", ServerAddr)
-	if err != nil {
-		log.Errorf("%s: Listening on 0.0.0.0:%s threw an error.\n%v", socket.name, socket.port, err)
-		return nil, err
-	}
-	log.Noteln(socket.name + ": Listening on 0.0.0.0:" + socket.port)
-
-	// Accept new connections in a new Goroutine("thread")
-	go socket.run()
+	socket.servePacket(ctx, listen)
 
-	return socket.eventChan, nil
+	return events.C, nil
 }
 
-// Close fires a close-event and closes the socket
-func (socket *SocketUDP) Close() {
-	// Fire closing event
-	log.Noteln(socket.name + " closing. Port " + socket.port)
-	socket.eventChan <- SocketUDPEvent{
-		Name: "close",
-		Addr: nil,
-		Data: nil,
-	}
-
-	// Close socket
-	socket.listen.Close()
+// Events returns the event bus of the socket. Subscribe to it for more
+// consumers than the channel returned by New.
+func (socket *SocketUDP) Events() *EventBus[SocketUDPEvent] {
+	return socket.udpEvents
 }
 
-func (socket *SocketUDP) readFESL(data []byte, addr *net.UDPAddr) {
-	outCommand := new(CommandFESL)
-
-	p := bytes.NewBuffer(data)
-	var payloadId uint32
-	var payloadLen uint32
-
-	payloadType := string(data[:4])
-	p.Next(4)
-
-	binary.Read(p, binary.BigEndian, &payloadId)
-	binary.Read(p, binary.BigEndian, &payloadLen)
-
-	payloadRaw := data[12:]
-	payload := ProcessFESL(string(payloadRaw))
-
-	outCommand.Query = payloadType
-	outCommand.PayloadID = payloadId
-	outCommand.Message = payload
-
-	socket.eventChan <- SocketUDPEvent{
-		Name: "command." + payloadType,
-		Addr: addr,
-		Data: outCommand,
-	}
-	socket.eventChan <- SocketUDPEvent{
-		Name: "command",
-		Addr: addr,
-		Data: outCommand,
-	}
-
-}
-
-func (socket *SocketUDP) processCommand(command string, addr *net.UDPAddr) {
-	gsPacket, err := ProcessCommand(command)
-	if err != nil {
-		log.Errorf("%s: Error processing command %s.\n%v", socket.name, command, err)
-		socket.eventChan <- SocketUDPEvent{
-			Name: "error",
-			Addr: addr,
-			Data: err,
-		}
-		return
+// publishUDP hands events of the server over as SocketUDPEvents
+func (socket *SocketUDP) publishUDP(event SocketEvent) {
+	udpEvent := SocketUDPEvent{
+		Name: event.Name,
+		Data: event.Data,
 	}
 
-	socket.eventChan <- SocketUDPEvent{
-		Name: "command." + gsPacket.Query,
-		Addr: addr,
-		Data: gsPacket,
-	}
-	socket.eventChan <- SocketUDPEvent{
-		Name: "command",
-		Addr: addr,
-		Data: gsPacket,
+	switch payload := event.Data.(type) {
+	case EventUDPError:
+		udpEvent.Addr = payload.Addr
+	case EventUDPData:
+		udpEvent.Addr = payload.Addr
+	case EventUDPCommand:
+		udpEvent.Addr = payload.Addr
+	case EventUDPFESLCommand:
+		udpEvent.Addr = payload.Addr
+	case EventSessionNew:
+		udpEvent.Addr = payload.Addr
+	case EventSessionExpired:
+		udpEvent.Addr = payload.Addr
 	}
-}
-
-func (socket *SocketUDP) run() {
-	buf := make([]byte, 4096)
-
-	for {
-		n, addr, err := socket.listen.ReadFromUDP(buf)
-		if err != nil {
-			log.Errorf("%s: Error reading from UDP.%v", socket.name, err)
-			socket.eventChan <- SocketUDPEvent{
-				Name: "error",
-				Addr: addr,
-				Data: err,
-			}
-			continue
-		}
 
-		if socket.fesl {
-			socket.readFESL(buf[:n], addr)
-			continue
-		}
-
-		message := strings.TrimSpace(string(socket.XOr(buf[0:n])))
-
-		log.Debugln("Got UDP message:", message)
-
-		socket.eventChan <- SocketUDPEvent{
-			Name: "data",
-			Addr: addr,
-			Data: message,
-		}
-
-		socket.processCommand(message, addr)
-	}
+	socket.udpEvents.Publish(udpEvent)
 }
 
 func (socket *SocketUDP) WriteFESL(msgType string, msg map[string]string, msgType2 uint32, addr *net.UDPAddr) error {
-	var lena int32
-	var buf bytes.Buffer
-
-	payloadEncoded := SerializeFESL(msg)
-	baselen := len(payloadEncoded)
-	lena = int32(baselen + 12)
-
-	buf.Write([]byte(msgType))
-
-	err := binary.Write(&buf, binary.BigEndian, &msgType2)
-	if err != nil {
-		fmt.Println("binary.Write failed:", err)
-	}
-
-	err = binary.Write(&buf, binary.BigEndian, &lena)
-	if err != nil {
-		fmt.Println("binary.Write failed:", err)
-	}
-
-	buf.Write([]byte(payloadEncoded))
-
 	log.Debugln("Write message:", msg, msgType, msgType2)
 
-	n, err := socket.listen.WriteToUDP(buf.Bytes(), addr)
-	if err != nil {
-		fmt.Println("Writing failed:", n, err)
-	}
-	return nil
+	return socket.WriteTo(EncodeFESL(msgType, msg, msgType2), addr)
 }
 
 func (socket *SocketUDP) Write(message string, addr *net.UDPAddr) {
 	log.Debugln("Sending message:", message)
-	xOrMessage := socket.XOr([]byte(message))
 
-	_, err := socket.listen.WriteToUDP(xOrMessage, addr)
-	if err != nil {
-		log.Errorf("%s: Error writing to UDP. Message:%s Client:%v %v", socket.name, message, addr, err)
-		socket.eventChan <- SocketUDPEvent{
-			Name: "error",
-			Addr: addr,
-			Data: err,
-		}
-	}
+	socket.WriteTo([]byte(message), addr)
 }
 
 // XOr applies the gamespy XOr
 func (socket *SocketUDP) XOr(a []byte) []byte {
-	b := []byte("gamespy")
-	var res []byte
-
-	var k = 0
-	var i = 0
-	for i < len(a) {
-		if k > (len(b) - 1) {
-			k = 0
-		}
-		res = append(res, a[i]^b[k])
-		k++
-		i++
-	}
-
-	return res
+	return xor(a, gamespyXORKey)
 }
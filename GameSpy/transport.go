@@ -0,0 +1,73 @@
+package GameSpy
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// Transport prepares an accepted connection before a Client takes it
+// over. Clients work on any net.Conn, so a transport is all it takes to
+// serve them over something new, e.g. WebSockets or Unix sockets.
+type Transport interface {
+	Handshake(conn net.Conn) (net.Conn, error)
+}
+
+// TCPTransport hands connections over as they are
+type TCPTransport struct{}
+
+// Handshake returns conn
+func (TCPTransport) Handshake(conn net.Conn) (net.Conn, error) {
+	return conn, nil
+}
+
+// TLSTransport runs the server side of a TLS handshake
+type TLSTransport struct {
+	Config *tls.Config
+	// Timeout bounds the handshake. 0 disables it.
+	Timeout time.Duration
+}
+
+// Handshake wraps conn into TLS and runs the handshake
+func (transport TLSTransport) Handshake(conn net.Conn) (net.Conn, error) {
+	tlsConn := tls.Server(conn, transport.Config)
+
+	if transport.Timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(transport.Timeout))
+	}
+
+	err := tlsConn.Handshake()
+	if err != nil {
+		return nil, err
+	}
+
+	state := tlsConn.ConnectionState()
+	log.Debugf("Connection handshake complete %v, %v", state.HandshakeComplete, state)
+
+	// reset deadline after handshake
+	tlsConn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
+}
+
+// NewLegacyTLSConfig returns the TLS configuration the old EA clients
+// need, SSLv3 with RC4
+func NewLegacyTLSConfig(tlsCert string, tlsKey string) (*tls.Config, error) {
+	cer, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cer},
+		ClientAuth:         tls.NoClientCert,
+		MinVersion:         tls.VersionSSL30,
+		InsecureSkipVerify: true,
+		//MaxVersion:   tls.VersionSSL30,
+		CipherSuites: []uint16{
+			tls.TLS_RSA_WITH_RC4_128_SHA,
+		},
+	}, nil
+}
@@ -0,0 +1,206 @@
+package GameSpy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// A zero-downtime upgrade starts the new binary with the listeners of
+// the running one. Both share the sockets, so nobody gets turned away
+// while the old process drains its clients:
+//
+//	process, err := GameSpy.Upgrade(ctx, map[string]GameSpy.Filer{
+//		"fesl": &feslSocket.Server,
+//	})
+//	...
+//	feslSocket.Drain(ctx)
+//
+// The new process picks the listeners up by name and reports it's ready
+// once it serves all of them:
+//
+//	feslSocket.New("FESL", GameSpy.InheritedOr("fesl", "18270"), ...)
+//	GameSpy.Ready()
+
+// listenParentEnv carries the pid of the upgrading process. systemd sets
+// LISTEN_PID to the pid of the new process, which we can't know before
+// starting it, so children check their parent instead.
+const listenParentEnv = "GOAWAKEN_LISTEN_PARENT"
+
+// readyFdEnv carries the file descriptor an upgraded process reports
+// on that it's ready, see Ready
+const readyFdEnv = "GOAWAKEN_READY_FD"
+
+// ErrNotListening is returned for servers without a listener to hand over
+var ErrNotListening = errors.New("server is not listening")
+
+// Filer is a listener which can be handed over by Upgrade. It's
+// implemented by *Server, *net.TCPListener, *net.UDPConn and friends.
+type Filer interface {
+	File() (*os.File, error)
+}
+
+// File returns a duplicate of the file descriptor the server listens on
+func (server *Server) File() (*os.File, error) {
+	var listener interface{}
+	switch {
+	case server.listen != nil:
+		listener = server.listen
+	case server.packets != nil:
+		listener = server.packets
+	default:
+		return nil, ErrNotListening
+	}
+
+	f, ok := listener.(Filer)
+	if !ok {
+		return nil, errors.New("listener has no file descriptor")
+	}
+	return f.File()
+}
+
+// Upgrade starts the running binary again with the same arguments and
+// hands it the listeners under their names. It waits for the new process
+// to call Ready, the listeners keep on serving meanwhile. A process which
+// exits before or isn't ready once ctx is done gets killed, and Upgrade
+// returns an error. Drain the servers once Upgrade returned without one.
+func Upgrade(ctx context.Context, listeners map[string]Filer) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	// Sorted, so the file descriptors don't depend on map order
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		if strings.Contains(name, ":") {
+			return nil, errors.New("listener names must not contain a colon: " + name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []*os.File
+	closeFiles := func() {
+		// The child has its own copies
+		for _, file := range files {
+			file.Close()
+		}
+		files = nil
+	}
+	defer closeFiles()
+
+	for _, name := range names {
+		file, err := listeners[name].File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	// The child writes to the pipe once it's ready. It comes after the
+	// listeners, which is why it's not part of LISTEN_FDS.
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer ready.Close()
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(upgradeEnviron(),
+		"LISTEN_FDS="+strconv.Itoa(len(names)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		listenParentEnv+"="+strconv.Itoa(os.Getpid()),
+		readyFdEnv+"="+strconv.Itoa(listenFdsStart+len(names)),
+	)
+
+	err = cmd.Start()
+	for _, file := range files[:len(names)] {
+		if err := setNonblock(file); err != nil {
+			log.Warningln("Couldn't put listener", file.Name(), "back into non-blocking mode.", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Without our end of the pipe the read fails once the child exits
+	closeFiles()
+	log.Notef("Started upgraded process %d with listeners %s", cmd.Process.Pid, strings.Join(names, ", "))
+
+	readies := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		readies <- err
+	}()
+
+	select {
+	case err = <-readies:
+		if err == nil {
+			log.Notef("Upgraded process %d is ready", cmd.Process.Pid)
+			return cmd.Process, nil
+		}
+		err = fmt.Errorf("upgraded process %d exited before it was ready", cmd.Process.Pid)
+	case <-ctx.Done():
+		err = fmt.Errorf("upgraded process %d wasn't ready in time: %w", cmd.Process.Pid, ctx.Err())
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	return nil, err
+}
+
+// Ready tells the process which started this one by Upgrade that all
+// listeners are served, after which it drains its clients. Processes
+// not started by Upgrade have nobody to tell, Ready does nothing there.
+func Ready() error {
+	fd, err := strconv.Atoi(os.Getenv(readyFdEnv))
+	if err != nil {
+		return nil
+	}
+	os.Unsetenv(readyFdEnv)
+
+	file := os.NewFile(uintptr(fd), "ready")
+	defer file.Close()
+	_, err = file.Write([]byte{1})
+	return err
+}
+
+// InheritedOr returns the address of the listener inherited under name,
+// or address if there is none
+func InheritedOr(name string, address string) string {
+	inheritedOnce.Do(loadInherited)
+
+	inheritedMutex.Lock()
+	defer inheritedMutex.Unlock()
+
+	if _, ok := inherited["systemd:"+name]; ok {
+		return "systemd:" + name
+	}
+	return address
+}
+
+// upgradeEnviron returns our environment without the variables of the
+// socket activation we got started with
+func upgradeEnviron() []string {
+	var env []string
+	for _, variable := range os.Environ() {
+		switch strings.SplitN(variable, "=", 2)[0] {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", listenParentEnv, readyFdEnv:
+			continue
+		}
+		env = append(env, variable)
+	}
+	return env
+}
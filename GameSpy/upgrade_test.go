@@ -0,0 +1,140 @@
+//go:build !windows
+
+package GameSpy_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+const upgradeChildEnv = "GOAWAKEN_TEST_UPGRADE_CHILD"
+
+// TestMain turns the test binary into the upgraded process when
+// TestUpgrade starts it again
+func TestMain(m *testing.M) {
+	switch os.Getenv(upgradeChildEnv) {
+	case "1":
+		upgradeChild()
+		os.Exit(0)
+	case "exit":
+		// Dies before it's ready
+		os.Exit(1)
+	case "hang":
+		// Never gets ready
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// upgradeChild greets a single client on the inherited listener
+func upgradeChild() {
+	listener, err := GameSpy.Listen("tcp", GameSpy.InheritedOr("test", "127.0.0.1:0"))
+	if err != nil {
+		os.Exit(1)
+	}
+	if err := GameSpy.Ready(); err != nil {
+		os.Exit(1)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		os.Exit(1)
+	}
+	conn.Write([]byte("\\child\\\\final\\"))
+	conn.Close()
+}
+
+func TestUpgrade(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{Name: "test"}
+	events, _ := server.Listen(context.Background(), listener)
+
+	// A client of the old process survives the upgrade
+	old, _ := net.Dial("tcp", listener.Addr().String())
+	defer old.Close()
+	nextSocketEvent(t, events, "newClient")
+	go func() {
+		for range events {
+		}
+	}()
+
+	os.Setenv(upgradeChildEnv, "1")
+	process, err := GameSpy.Upgrade(context.Background(), map[string]GameSpy.Filer{"test": server})
+	os.Unsetenv(upgradeChildEnv)
+	if err != nil {
+		t.Fatalf("Upgrade threw an error: %v", err)
+	}
+
+	// The drain waits for the client of the old process
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		old.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := server.Drain(ctx); err != nil {
+		t.Errorf("Drain threw an error: %v", err)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Errorf("Drain did not wait for the old client.")
+	}
+
+	// New clients end up at the child
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Connecting after the upgrade threw an error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	greeting, _ := io.ReadAll(conn)
+	if string(greeting) != "\\child\\\\final\\" {
+		t.Errorf("Greeting was incorrect, got: %q.", greeting)
+	}
+
+	state, err := process.Wait()
+	if err != nil || !state.Success() {
+		t.Errorf("Upgraded process failed: %v, %v", state, err)
+	}
+}
+
+func TestUpgradeNotReady(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{Name: "test"}
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	// Accept waits for the next client before the upgrade
+	first, _ := net.Dial("tcp", listener.Addr().String())
+	defer first.Close()
+	nextSocketEvent(t, events, "newClient")
+
+	for _, child := range []string{"exit", "hang"} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		os.Setenv(upgradeChildEnv, child)
+		start := time.Now()
+		process, err := GameSpy.Upgrade(ctx, map[string]GameSpy.Filer{"test": server})
+		os.Unsetenv(upgradeChildEnv)
+		cancel()
+		if err == nil || process != nil {
+			t.Errorf("Upgrade to a child which is never ready (%s) was incorrect, got: %v, %v.", child, process, err)
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Errorf("Upgrade waited for the child (%s) too long.", child)
+		}
+	}
+
+	// The old process keeps serving
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Connecting after the failed upgrade threw an error: %v", err)
+	}
+	defer conn.Close()
+	nextSocketEvent(t, events, "newClient")
+}
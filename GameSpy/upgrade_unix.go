@@ -0,0 +1,27 @@
+//go:build !windows
+
+package GameSpy
+
+import (
+	"os"
+	"syscall"
+)
+
+// setNonblock puts the descriptor of file back into non-blocking mode.
+// os/exec takes the descriptors of ExtraFiles out of it, and a listener
+// shares the mode with its duplicates: its Accept would tie up a thread
+// and Close would wait for the next client.
+func setNonblock(file *os.File) error {
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var nonblockErr error
+	err = raw.Control(func(fd uintptr) {
+		nonblockErr = syscall.SetNonblock(int(fd), true)
+	})
+	if err != nil {
+		return err
+	}
+	return nonblockErr
+}
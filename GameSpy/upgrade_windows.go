@@ -0,0 +1,10 @@
+//go:build windows
+
+package GameSpy
+
+import "os"
+
+// setNonblock does nothing, Windows has no descriptors to pass on
+func setNonblock(file *os.File) error {
+	return nil
+}
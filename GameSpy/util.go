@@ -77,6 +77,15 @@ func ShortHash(str string) string {
 	return hash[0:12]
 }
 
+// GPCMProof returns the proof of the GPCM login: the MD5 of the password
+// hash, 48 spaces, the user, both challenges and the password hash again.
+// passwordHash is Hash(password). Clients prove themselves with their
+// own challenge first, the server answers with the server challenge
+// first.
+func GPCMProof(passwordHash string, user string, challenge1 string, challenge2 string) string {
+	return Hash(passwordHash + strings.Repeat(" ", 48) + user + challenge1 + challenge2 + passwordHash)
+}
+
 // ProcessCommand turns gamespy's command string to the
 // command struct
 func ProcessCommand(msg string) (*Command, error) {
@@ -119,6 +128,16 @@ func DecodePassword(pass string) (string, error) {
 	return string(decodedPass), err
 }
 
+// EncodePassword encodes a cleantext password to gamespy's base64 string.
+// It's the reverse of DecodePassword.
+func EncodePassword(pass string) string {
+	encodedPass := base64.StdEncoding.EncodeToString([]byte(pass))
+	encodedPass = strings.Replace(encodedPass, "=", "_", -1)
+	encodedPass = strings.Replace(encodedPass, "+", "[", -1)
+	encodedPass = strings.Replace(encodedPass, "/", "]", -1)
+	return encodedPass
+}
+
 // BF2RandomUnsafe is a not thread-safe version of BF2Random
 // For thread-safety you should use BF2Random with your own seed
 func BF2RandomUnsafe(randomLen int) string {
@@ -145,12 +164,17 @@ func BF2Random(randomLen int, source rand.Source) string {
 	return string(b)
 }
 
+// ProcessFESL turns a FESL payload into its keys and values. It's the
+// reverse of SerializeFESL.
 func ProcessFESL(data string) map[string]string {
 	out := make(map[string]string)
+	// Drop the terminator SerializeFESL adds
+	data = strings.TrimSuffix(data, string(byte(0x00)))
 	dataMap := strings.Split(data, "\n")
 
 	for i := 0; i < len(dataMap); i++ {
-		objectMap := strings.Split(dataMap[i], "=")
+		// Values may hold '=' themselves, e.g. base64
+		objectMap := strings.SplitN(dataMap[i], "=", 2)
 		if len(objectMap) != 2 {
 			continue
 		}
@@ -166,7 +190,8 @@ func SerializeFESL(data map[string]string) string {
 	for key, value := range data {
 		out += key + "=" + value + "\n"
 	}
-	newOut := out[:len(out)-1]
+	// An empty message is just the terminator
+	newOut := strings.TrimSuffix(out, "\n")
 	newOut = newOut + string(byte(0x00))
 	return newOut
 }
@@ -180,3 +205,66 @@ func Inet_ntoa(ipnr int64) net.IP {
 
 	return net.IPv4(bytes[0], bytes[1], bytes[2], bytes[3])
 }
+
+// Inet_aton is the reverse of Inet_ntoa. IPv6 addresses don't fit and
+// return 0, use IPBytes for them.
+func Inet_aton(ip net.IP) int64 {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0
+	}
+
+	return int64(ip4[0]) | int64(ip4[1])<<8 | int64(ip4[2])<<16 | int64(ip4[3])<<24
+}
+
+// IPBytes returns ip in network byte order, 4 bytes for IPv4 (including
+// IPv4-mapped IPv6) and 16 bytes for IPv6
+func IPBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return []byte(ip4)
+	}
+	return []byte(ip.To16())
+}
+
+// ParseIPBytes is the reverse of IPBytes
+func ParseIPBytes(b []byte) (net.IP, error) {
+	switch len(b) {
+	case net.IPv4len:
+		return net.IPv4(b[0], b[1], b[2], b[3]), nil
+	case net.IPv6len:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, b)
+		return ip, nil
+	}
+	return nil, errors.New("address has to be 4 or 16 bytes long")
+}
+
+// AddrIP returns the IP of a TCP or UDP address. IPv4 clients of
+// dual-stack listeners come back as plain IPv4.
+func AddrIP(addr net.Addr) net.IP {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return nil
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// AddrPort returns the port of a TCP or UDP address
+func AddrPort(addr net.Addr) int {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.Port
+	case *net.UDPAddr:
+		return addr.Port
+	}
+	return 0
+}
@@ -1,6 +1,7 @@
 package GameSpy_test
 
 import (
+	"net"
 	"reflect"
 	"testing"
 
@@ -60,3 +61,39 @@ func TestBF2RandomUnsafe(t *testing.T) {
 	}
 
 }
+
+func TestIPHelpers(t *testing.T) {
+	var tests = []struct {
+		ip    string
+		bytes int
+	}{
+		{"192.0.2.1", 4},
+		{"::ffff:192.0.2.1", 4},
+		{"2001:db8::1", 16},
+	}
+
+	for _, test := range tests {
+		ip := net.ParseIP(test.ip)
+		b := GameSpy.IPBytes(ip)
+		if len(b) != test.bytes {
+			t.Errorf("IPBytes of %s was incorrect, got: %d bytes, want: %d.", test.ip, len(b), test.bytes)
+		}
+		parsed, err := GameSpy.ParseIPBytes(b)
+		if err != nil || !parsed.Equal(ip) {
+			t.Errorf("ParseIPBytes was incorrect, got: %v, want: %v.", parsed, ip)
+		}
+	}
+
+	ip := net.ParseIP("192.0.2.1")
+	if back := GameSpy.Inet_ntoa(GameSpy.Inet_aton(ip)); !back.Equal(ip) {
+		t.Errorf("Inet_ntoa(Inet_aton) was incorrect, got: %v, want: %v.", back, ip)
+	}
+
+	addr := &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 29900}
+	if got := GameSpy.AddrIP(addr); got.String() != "192.0.2.1" || len(got) != net.IPv4len {
+		t.Errorf("AddrIP was incorrect, got: %v.", got)
+	}
+	if GameSpy.AddrPort(addr) != 29900 {
+		t.Errorf("AddrPort was incorrect, got: %d.", GameSpy.AddrPort(addr))
+	}
+}
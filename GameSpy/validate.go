@@ -0,0 +1,101 @@
+package GameSpy
+
+import "fmt"
+
+// Limits caps what a single message may hold. Messages over any limit
+// are rejected with a *ValidationError.
+type Limits struct {
+	// MaxKeys is the maximum number of keys of a message
+	MaxKeys int
+	// MaxKeyLength is the maximum length of a key, or of the query
+	MaxKeyLength int
+	// MaxValueLength is the maximum length of a single value
+	MaxValueLength int
+}
+
+// DefaultLimits is the table of limits per protocol. Framers use the
+// entry of their protocol for every limit they don't set themselves.
+// Change it before starting any socket to apply other limits everywhere.
+var DefaultLimits = map[string]Limits{
+	"gamespy": {MaxKeys: 64, MaxKeyLength: 64, MaxValueLength: 1024},
+	"fesl":    {MaxKeys: 512, MaxKeyLength: 128, MaxValueLength: 8192},
+	"irc":     {MaxKeys: 4, MaxKeyLength: 64, MaxValueLength: 512},
+	"qr2":     {MaxKeys: 128, MaxKeyLength: 64, MaxValueLength: 8192},
+}
+
+// ValidationError is returned by framers for messages which break the
+// limits of their protocol. It matches ErrInvalidFrame with errors.Is.
+type ValidationError struct {
+	Protocol string
+	Key      string
+	Reason   string
+}
+
+func (err *ValidationError) Error() string {
+	if err.Key == "" {
+		return fmt.Sprintf("invalid %s message: %s", err.Protocol, err.Reason)
+	}
+	return fmt.Sprintf("invalid %s message: %s %q", err.Protocol, err.Reason, truncate(err.Key, 32))
+}
+
+// Is makes errors.Is(err, ErrInvalidFrame) true
+func (err *ValidationError) Is(target error) bool {
+	return target == ErrInvalidFrame
+}
+
+// limitsFor fills the unset fields of limits from the table
+func limitsFor(protocol string, limits Limits) Limits {
+	defaults := DefaultLimits[protocol]
+	if limits.MaxKeys <= 0 {
+		limits.MaxKeys = defaults.MaxKeys
+	}
+	if limits.MaxKeyLength <= 0 {
+		limits.MaxKeyLength = defaults.MaxKeyLength
+	}
+	if limits.MaxValueLength <= 0 {
+		limits.MaxValueLength = defaults.MaxValueLength
+	}
+	return limits
+}
+
+// validate checks a decoded message against limits. Unset limits are
+// not checked.
+func validate(protocol string, limits Limits, query string, message map[string]string) error {
+	invalid := func(key string, reason string) error {
+		return &ValidationError{Protocol: protocol, Key: key, Reason: reason}
+	}
+
+	if limits.MaxKeyLength > 0 && len(query) > limits.MaxKeyLength {
+		return invalid(query, "query too long")
+	}
+	if limits.MaxKeys > 0 && len(message) > limits.MaxKeys {
+		return invalid("", fmt.Sprintf("%d keys, at most %d allowed", len(message), limits.MaxKeys))
+	}
+
+	for key, value := range message {
+		if limits.MaxKeyLength > 0 && len(key) > limits.MaxKeyLength {
+			return invalid(key, "key too long")
+		}
+		if limits.MaxValueLength > 0 && len(value) > limits.MaxValueLength {
+			return invalid(key, "value too long for key")
+		}
+	}
+	return nil
+}
+
+// validFESLType reports whether a FESL type like "acct" is printable
+func validFESLType(msgType string) bool {
+	for i := 0; i < len(msgType); i++ {
+		if msgType[i] < 0x20 || msgType[i] > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
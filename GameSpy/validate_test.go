@@ -0,0 +1,59 @@
+package GameSpy_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func TestFramerLimits(t *testing.T) {
+	var tests = []struct {
+		name   string
+		framer GameSpy.Framer
+		frame  []byte
+	}{
+		{"too many keys", GameSpy.GameSpyFramer{Limits: GameSpy.Limits{MaxKeys: 3}}, []byte("\\a\\1\\b\\2\\c\\3\\final\\")},
+		{"value too long", GameSpy.GameSpyFramer{Limits: GameSpy.Limits{MaxValueLength: 8}}, []byte("\\login\\\\user\\123456789\\final\\")},
+		{"query too long", GameSpy.GameSpyFramer{}, []byte("\\" + strings.Repeat("q", 65) + "\\\\final\\")},
+		{"unprintable FESL type", GameSpy.FESLFramer{}, []byte("\x00\x01\x02\x03\x00\x00\x00\x01\x00\x00\x00\x0C")},
+		{"FESL key too long", GameSpy.FESLFramer{}, GameSpy.EncodeFESL("acct", map[string]string{strings.Repeat("k", 129): "v"}, 1)},
+		{"IRC params too long", GameSpy.LineFramer{Limits: GameSpy.Limits{MaxValueLength: 4}}, []byte("JOIN #channel\r\n")},
+	}
+
+	for _, test := range tests {
+		_, err := test.framer.Decode(test.frame)
+		var validationError *GameSpy.ValidationError
+		if !errors.As(err, &validationError) || !errors.Is(err, GameSpy.ErrInvalidFrame) {
+			t.Errorf("%s: Decode was incorrect, got: %v.", test.name, err)
+		}
+	}
+}
+
+func TestSerializeFESLEmpty(t *testing.T) {
+	if serialized := GameSpy.SerializeFESL(map[string]string{}); serialized != "\x00" {
+		t.Errorf("SerializeFESL was incorrect, got: %q.", serialized)
+	}
+}
+
+func TestClientOversizedFrame(t *testing.T) {
+	server, conn := net.Pipe()
+	defer conn.Close()
+
+	client := new(GameSpy.Client)
+	client.FESL = true
+	events, _ := client.New("test", server)
+
+	// A FESL header announcing more than 64KiB
+	go conn.Write([]byte("acct\x00\x00\x00\x01\x7F\xFF\xFF\xFF"))
+
+	event := nextClientEvent(t, events)
+	if event.Name != "error" || !errors.Is(event.Data.(error), GameSpy.ErrFrameTooLarge) {
+		t.Errorf("Client event was incorrect, got: %+v.", event)
+	}
+	if event := nextClientEvent(t, events); event.Name != "close" {
+		t.Errorf("Client was not closed, got: %+v.", event)
+	}
+}
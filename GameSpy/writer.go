@@ -0,0 +1,230 @@
+package GameSpy
+
+import (
+	"errors"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+)
+
+// WriterConfig configures the outbound queue every client gets. Zero
+// values take those of DefaultWriterConfig.
+type WriterConfig struct {
+	// QueueSize is the number of frames which may wait to be sent
+	QueueSize int
+	// WriteTimeout is the deadline for a single write. A negative one
+	// disables it.
+	WriteTimeout time.Duration
+	// NoCoalesce sends every frame on its own instead of merging queued
+	// frames into as few writes as possible
+	NoCoalesce bool
+	// CoalesceLimit is the maximum size of a merged write
+	CoalesceLimit int
+	// Overflow is applied when the queue is full. OverflowDisconnect
+	// closes the connection, OverflowDropOldest drops the oldest queued
+	// frame and OverflowBlock waits for room.
+	Overflow OverflowPolicy
+}
+
+// DefaultWriterConfig is used by clients which got no WriterConfig
+var DefaultWriterConfig = WriterConfig{
+	QueueSize:     256,
+	WriteTimeout:  10 * time.Second,
+	CoalesceLimit: 16384,
+	Overflow:      OverflowDisconnect,
+}
+
+// withDefaults returns the config with its zero values replaced by those
+// of DefaultWriterConfig
+func (config WriterConfig) withDefaults() WriterConfig {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultWriterConfig.QueueSize
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = DefaultWriterConfig.WriteTimeout
+	}
+	if config.CoalesceLimit <= 0 {
+		config.CoalesceLimit = DefaultWriterConfig.CoalesceLimit
+	}
+	if config.Overflow == OverflowDefault {
+		config.Overflow = DefaultWriterConfig.Overflow
+	}
+	return config
+}
+
+var (
+	// ErrWriterClosed is returned when writing to a client which is gone
+	ErrWriterClosed = errors.New("client is not active. Can't send message")
+	// ErrWriteQueueFull is returned when a client does not keep up
+	ErrWriteQueueFull = errors.New("write queue of client is full")
+)
+
+// writer sends frames to a connection from its own goroutine, so frames
+// never interleave and a stalled peer can't block the caller
+type writer struct {
+	name    string
+	conn    net.Conn
+	config  WriterConfig
+	queue   chan []byte
+	pending []byte
+	closing chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func newWriter(name string, conn net.Conn, config WriterConfig) *writer {
+	config = config.withDefaults()
+
+	w := &writer{
+		name:    name,
+		conn:    conn,
+		config:  config,
+		queue:   make(chan []byte, config.QueueSize),
+		closing: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+
+	return w
+}
+
+// Write queues frame to be sent
+func (w *writer) Write(frame []byte) error {
+	select {
+	case <-w.closing:
+		return ErrWriterClosed
+	default:
+	}
+
+	select {
+	case w.queue <- frame:
+		return nil
+	default:
+	}
+
+	switch w.config.Overflow {
+	case OverflowBlock:
+		select {
+		case w.queue <- frame:
+			return nil
+		case <-w.closing:
+			return ErrWriterClosed
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-w.queue:
+				log.Debugf("%s: Write queue full, dropped oldest frame.", w.name)
+			default:
+			}
+
+			select {
+			case w.queue <- frame:
+				return nil
+			default:
+			}
+		}
+	default:
+		log.Notef("%s: Write queue full, disconnecting %v.", w.name, w.conn.RemoteAddr())
+		w.conn.Close()
+		return ErrWriteQueueFull
+	}
+}
+
+// Close sends everything still queued and stops the writer. It returns
+// once the queue is flushed or a write failed.
+func (w *writer) Close() {
+	w.once.Do(func() {
+		close(w.closing)
+	})
+	<-w.stopped
+}
+
+func (w *writer) run() {
+	defer close(w.stopped)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Errorln(w.name+": Panic in the writer, closing the connection.", recovered, string(debug.Stack()))
+			w.conn.Close()
+		}
+	}()
+	// Writes after a failed one are pointless, refuse them right away
+	defer w.once.Do(func() {
+		close(w.closing)
+	})
+
+	for {
+		frame, ok := w.next()
+		if !ok {
+			return
+		}
+
+		if err := w.send(w.batch(frame)); err != nil {
+			return
+		}
+	}
+}
+
+// next waits for the next frame. Once the writer is closing it only
+// returns what is left in the queue.
+func (w *writer) next() ([]byte, bool) {
+	if w.pending != nil {
+		frame := w.pending
+		w.pending = nil
+		return frame, true
+	}
+
+	select {
+	case frame := <-w.queue:
+		return frame, true
+	case <-w.closing:
+		select {
+		case frame := <-w.queue:
+			return frame, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// batch appends queued frames to frame as long as they fit into a
+// single write
+func (w *writer) batch(frame []byte) []byte {
+	if w.config.NoCoalesce || len(frame) >= w.config.CoalesceLimit {
+		return frame
+	}
+
+	merged := append(make([]byte, 0, w.config.CoalesceLimit), frame...)
+	for len(merged) < w.config.CoalesceLimit {
+		select {
+		case next := <-w.queue:
+			if len(merged)+len(next) > w.config.CoalesceLimit {
+				// Goes out with the next write
+				w.pending = next
+				return merged
+			}
+			merged = append(merged, next...)
+		default:
+			return merged
+		}
+	}
+	return merged
+}
+
+func (w *writer) send(frame []byte) error {
+	if w.config.WriteTimeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.config.WriteTimeout))
+	}
+
+	n, err := w.conn.Write(frame)
+	if err != nil {
+		log.Errorf("%s: Writing failed after %d bytes. %v", w.name, n, err)
+		// The read loop notices the closed connection and cleans up
+		w.conn.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package GameSpy_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+func TestClientWriteOrdered(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	client := new(GameSpy.Client)
+	client.New("test", conn)
+	if !client.Active() {
+		t.Errorf("New client is not active.")
+	}
+
+	want := ""
+	for i := 0; i < 50; i++ {
+		command := "\\ka\\" + strings.Repeat("x", i) + "\\final\\"
+		want += command
+		if err := client.Write(command); err != nil {
+			t.Fatalf("Write threw an error: %v", err)
+		}
+	}
+
+	got := make([]byte, len(want))
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(peer, got); err != nil {
+		t.Fatalf("Reading from peer threw an error: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("Frames were reordered or mangled, got: %q.", got)
+	}
+}
+
+func TestClientWriteQueueOverflow(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	client := new(GameSpy.Client)
+	client.New("test", conn)
+
+	// Nobody reads from peer, so the queue has to run full without
+	// blocking us
+	frame := string(bytes.Repeat([]byte("x"), 100))
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			if err := client.Write(frame); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != GameSpy.ErrWriteQueueFull {
+			t.Errorf("Write to a stalled client was incorrect, got: %v, want: %v.", err, GameSpy.ErrWriteQueueFull)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Write to a stalled client blocked.")
+	}
+}
+
+func TestClientWriteConfigDefaults(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &GameSpy.Server{
+		Name: "test",
+		// The queue size and the rest are taken from the defaults
+		Writer: GameSpy.WriterConfig{WriteTimeout: 50 * time.Millisecond, Overflow: GameSpy.OverflowBlock},
+	}
+	events, _ := server.Listen(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	defer conn.Close()
+	client := nextSocketEvent(t, events, "newClient").Data.(GameSpy.EventNewClient).Client
+
+	// Nobody reads from conn. Blocking writes wait for room until the
+	// write deadline gives up on the client.
+	frame := string(bytes.Repeat([]byte("x"), 16384))
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 10000; i++ {
+			if err := client.Write(frame); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || err == GameSpy.ErrWriteQueueFull {
+			t.Errorf("Write to a stalled client was incorrect, got: %v, want the client closed.", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Write to a stalled client blocked without a write deadline.")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a tool run instead of the server when it's named as the
+// first argument, e.g. "goawaken pcap capture.pcapng"
+type command struct {
+	usage string
+	run   func(flags *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]command{
+	"decode": {
+		usage: "[flags] [file]  Decode packets given as hex, base64 or binary, read from stdin by default",
+		run:   runDecode,
+	},
+	"loadtest": {
+		usage: "[flags]  Simulate players against a FESL and Theater server and report the latencies",
+		run:   runLoadTest,
+	},
+	"migrate": {
+		usage: "[flags]  Apply the pending schema migrations to the database",
+		run:   runMigrate,
+	},
+	"pcap": {
+		usage: "[flags] file.pcap  Import a packet capture into transcripts and replay captures",
+		run:   runImportPcap,
+	},
+}
+
+// runCommand runs the command named by args[0] and exits. It returns
+// false if there is no such command.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if args[0] == "help" {
+		printCommands()
+		os.Exit(0)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n", os.Args[0], args[0], cmd.usage)
+		flags.PrintDefaults()
+	}
+	if err := cmd.run(flags, args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+	return true
+}
+
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("Usage: %s [flags]  Run the server\n", os.Args[0])
+	for _, name := range names {
+		fmt.Printf("       %s %s %s\n", os.Args[0], name, commands[name].usage)
+	}
+}
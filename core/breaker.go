@@ -0,0 +1,151 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrUnavailable is returned instead of asking a database which failed
+// again and again, until the Breaker lets a query through to try again.
+// Login servers answer it with a "service unavailable" error.
+var ErrUnavailable = errors.New("core: database unavailable")
+
+// BreakerState is the state of a Breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every query through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every query with ErrUnavailable
+	BreakerOpen
+	// BreakerHalfOpen lets a single query through to find out whether
+	// the database is back
+	BreakerHalfOpen
+)
+
+func (state BreakerState) String() string {
+	switch state {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerStats are the counters of a Breaker
+type BreakerStats struct {
+	State BreakerState
+	// Trips counts how often the breaker opened
+	Trips uint64
+	// Rejected counts the queries failed with ErrUnavailable
+	Rejected uint64
+}
+
+// Breaker is a circuit breaker. It opens after a number of outages in a
+// row, fails fast for a cooldown and then lets a single query through to
+// try again.
+type Breaker struct {
+	failures int
+	cooldown time.Duration
+
+	mutex    sync.Mutex
+	state    BreakerState
+	inRow    int
+	openedAt time.Time
+	trips    uint64
+	rejected uint64
+}
+
+// NewBreaker returns a Breaker opening after failures outages in a row
+// for cooldown
+func NewBreaker(failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{failures: failures, cooldown: cooldown}
+}
+
+// Allow returns ErrUnavailable if the query shouldn't be tried. Every
+// allowed query has to be followed by Done.
+func (breaker *Breaker) Allow() error {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	switch breaker.state {
+	case BreakerOpen:
+		if time.Since(breaker.openedAt) >= breaker.cooldown {
+			breaker.state = BreakerHalfOpen
+			return nil
+		}
+	case BreakerHalfOpen:
+		// The trial is still running
+	default:
+		return nil
+	}
+	breaker.rejected++
+	return ErrUnavailable
+}
+
+// Done records the outcome of an allowed query
+func (breaker *Breaker) Done(err error) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	switch {
+	case isOutage(err):
+		breaker.inRow++
+		if breaker.state == BreakerHalfOpen || breaker.inRow >= breaker.failures {
+			if breaker.state != BreakerOpen {
+				breaker.trips++
+			}
+			breaker.state = BreakerOpen
+			breaker.openedAt = time.Now()
+		}
+	case errors.Is(err, context.Canceled):
+		// The caller gave up, which tells nothing about the database.
+		// A trial goes again with the next query.
+		if breaker.state == BreakerHalfOpen {
+			breaker.state = BreakerOpen
+		}
+	default:
+		breaker.inRow = 0
+		breaker.state = BreakerClosed
+	}
+}
+
+// Stats returns the counters of the breaker
+func (breaker *Breaker) Stats() BreakerStats {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	return BreakerStats{
+		State:    breaker.state,
+		Trips:    breaker.trips,
+		Rejected: breaker.rejected,
+	}
+}
+
+// isOutage tells the errors of a database which is down or stalling apart
+// from those of the query, like ErrNotFound
+func isOutage(err error) bool {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, mysql.ErrInvalidConn),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.As(err, &netErr):
+		return true
+	}
+	return strings.HasPrefix(err.Error(), "database is locked")
+}
@@ -0,0 +1,81 @@
+package core_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/core"
+)
+
+func TestBreaker(t *testing.T) {
+	breaker := core.NewBreaker(2, 20*time.Millisecond)
+
+	for _, err := range []error{context.DeadlineExceeded, core.ErrNotFound, context.DeadlineExceeded} {
+		if allowed := breaker.Allow(); allowed != nil {
+			t.Fatalf("Breaker opened too early: %v", allowed)
+		}
+		breaker.Done(err)
+	}
+
+	breaker.Allow()
+	breaker.Done(context.DeadlineExceeded)
+	if err := breaker.Allow(); err != core.ErrUnavailable {
+		t.Errorf("Breaker didn't open, got: %v", err)
+	}
+	if stats := breaker.Stats(); stats.State != core.BreakerOpen || stats.Trips != 1 || stats.Rejected != 1 {
+		t.Errorf("Stats were incorrect, got: %+v.", stats)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("Breaker didn't let a trial through: %v", err)
+	}
+	if err := breaker.Allow(); err != core.ErrUnavailable {
+		t.Errorf("Breaker let a second trial through: %v", err)
+	}
+	breaker.Done(nil)
+	if err := breaker.Allow(); err != nil || breaker.Stats().State != core.BreakerClosed {
+		t.Errorf("Breaker didn't close after the trial: %v", err)
+	}
+}
+
+// metricsSink collects the metrics added to it
+type metricsSink chan map[string]interface{}
+
+func (sink metricsSink) AddMetric(name string, tags map[string]string, fields map[string]interface{}) error {
+	sink <- fields
+	return nil
+}
+
+func TestDBDo(t *testing.T) {
+	db := new(core.DB)
+	db.SetOptions(core.DBOptions{QueryTimeout: 20 * time.Millisecond, BreakerFailures: 1, BreakerCooldown: time.Hour})
+
+	err := db.Do(context.Background(), func(ctx context.Context) error {
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > 20*time.Millisecond {
+			t.Errorf("Query had the wrong deadline, got: %v.", deadline)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Do threw the wrong error: %v", err)
+	}
+
+	err = db.Do(context.Background(), func(ctx context.Context) error {
+		t.Errorf("Do ran a query while the breaker was open.")
+		return nil
+	})
+	if err != core.ErrUnavailable {
+		t.Errorf("Do threw the wrong error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := make(metricsSink, 1)
+	go db.ReportMetrics(ctx, sink, time.Millisecond)
+	if fields := <-sink; fields["breakerState"] != "open" || fields["breakerRejected"] != int64(1) {
+		t.Errorf("Metrics were incorrect, got: %v.", fields)
+	}
+}
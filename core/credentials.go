@@ -0,0 +1,337 @@
+package core
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/HeroesAwaken/GoAwaken/Log"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrWrongPassword is returned by Credentials if the password doesn't
+// match the one of the account
+var ErrWrongPassword = errors.New("core: wrong password")
+
+// Algorithms of PasswordOptions
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+)
+
+// PasswordOptions choose the key derivation of new password hashes. Zero
+// values take those of DefaultPasswordOptions.
+type PasswordOptions struct {
+	// Algorithm is PasswordArgon2id or PasswordBcrypt
+	Algorithm  string
+	BcryptCost int
+	// Argon2Memory is in KiB
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+// DefaultPasswordOptions are the second recommendation of RFC 9106 for
+// argon2id
+var DefaultPasswordOptions = PasswordOptions{
+	Algorithm:     PasswordArgon2id,
+	BcryptCost:    12,
+	Argon2Time:    3,
+	Argon2Memory:  64 * 1024,
+	Argon2Threads: 4,
+}
+
+func (options PasswordOptions) withDefaults() PasswordOptions {
+	if options.Algorithm == "" {
+		options.Algorithm = DefaultPasswordOptions.Algorithm
+	}
+	if options.BcryptCost == 0 {
+		options.BcryptCost = DefaultPasswordOptions.BcryptCost
+	}
+	if options.Argon2Time == 0 {
+		options.Argon2Time = DefaultPasswordOptions.Argon2Time
+	}
+	if options.Argon2Memory == 0 {
+		options.Argon2Memory = DefaultPasswordOptions.Argon2Memory
+	}
+	if options.Argon2Threads == 0 {
+		options.Argon2Threads = DefaultPasswordOptions.Argon2Threads
+	}
+	return options
+}
+
+// argon2Params are the parameters of an argon2id hash
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// HashPassword derives the hash of a password to store, in the PHC
+// string format for argon2id
+func HashPassword(password string, options PasswordOptions) (string, error) {
+	options = options.withDefaults()
+
+	switch options.Algorithm {
+	case PasswordBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), options.BcryptCost)
+		return string(hash), err
+	case PasswordArgon2id:
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, options.Argon2Time, options.Argon2Memory, options.Argon2Threads, 32)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+			options.Argon2Memory, options.Argon2Time, options.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", fmt.Errorf("core: unknown password algorithm %q", options.Algorithm)
+}
+
+// CheckPassword reports whether password matches hash. Besides the hashes
+// of HashPassword it knows the legacy ones, the bare MD5 hex the old
+// servers stored.
+func CheckPassword(hash string, password string) (bool, error) {
+	switch {
+	case isLegacyHash(hash):
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(hash)), []byte(ProofHash(password))) == 1, nil
+	case isBcryptHash(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, err := parseArgon2(hash)
+		if err != nil {
+			return false, err
+		}
+		key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+		return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+	}
+	return false, errors.New("core: unknown password hash")
+}
+
+// NeedsRehash reports whether hash should be replaced by one of the
+// options: legacy hashes, those of the other algorithm and those weaker
+// than the options.
+func (options PasswordOptions) NeedsRehash(hash string) bool {
+	options = options.withDefaults()
+
+	switch {
+	case isBcryptHash(hash):
+		cost, err := bcrypt.Cost([]byte(hash))
+		return options.Algorithm != PasswordBcrypt || err != nil || cost < options.BcryptCost
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, err := parseArgon2(hash)
+		return options.Algorithm != PasswordArgon2id || err != nil ||
+			params.time < options.Argon2Time || params.memory < options.Argon2Memory || params.threads < options.Argon2Threads
+	}
+	return true
+}
+
+// ProofHash returns what the GPCM proof is built from, the MD5 hex of the
+// password. It's what GameSpy.GPCMProof takes as passwordHash.
+func ProofHash(password string) string {
+	sum := md5.Sum([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isLegacyHash(hash string) bool {
+	if len(hash) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// parseArgon2 reads a hash like
+// $argon2id$v=19$m=65536,t=3,p=4$salt$key
+func parseArgon2(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("core: malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("core: unsupported argon2id version %q", parts[2])
+	}
+
+	params := new(argon2Params)
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, fmt.Errorf("core: malformed argon2id parameters %q", parts[3])
+	}
+	// argon2.IDKey panics on these
+	if params.time < 1 || params.threads < 1 || params.memory < 8*uint32(params.threads) {
+		return nil, fmt.Errorf("core: invalid argon2id parameters %q", parts[3])
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(params.key) == 0 {
+		return nil, errors.New("core: malformed argon2id hash")
+	}
+	return params, nil
+}
+
+// ProofKeyEnv is the environment variable servers read the ProofKey of
+// their Credentials from, see ParseProofKey
+const ProofKeyEnv = "GOAWAKEN_PROOF_KEY"
+
+// sealedProofPrefix marks a ProofHash encrypted with the ProofKey
+const sealedProofPrefix = "aesgcm$"
+
+// ParseProofKey decodes a ProofKey from hex. It has to be 16, 24 or 32
+// bytes for AES-128, AES-192 or AES-256.
+func ParseProofKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("core: malformed proof key: %w", err)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("core: proof key of %d bytes, expected 16, 24 or 32", len(key))
+	}
+	return key, nil
+}
+
+// Credentials checks and stores the passwords of accounts. Next to the
+// hash of the password it stores the MD5 the GPCM proof needs, which
+// isn't good for logging in with FESL. The MD5 is encrypted with the
+// ProofKey.
+type Credentials struct {
+	Accounts Accounts
+	Options  PasswordOptions
+	// ProofKey is the AES key of the ProofHash of the accounts. It's
+	// kept out of the database, e.g. in ProofKeyEnv. Passwords can't be
+	// set without it.
+	ProofKey []byte
+}
+
+// aead returns the AES-GCM of the ProofKey
+func (credentials *Credentials) aead() (cipher.AEAD, error) {
+	if len(credentials.ProofKey) == 0 {
+		return nil, errors.New("core: Credentials have no ProofKey")
+	}
+	block, err := aes.NewCipher(credentials.ProofKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealProof encrypts the ProofHash of a password
+func sealProof(aead cipher.AEAD, password string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(ProofHash(password)), nil)
+	return sealedProofPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// GPCMPasswordHash returns the MD5 hex of the password the GPCM proof of
+// the account is built from, or "" if the account has none. It's only
+// decrypted for building the proof and never stored in the clear.
+func (credentials *Credentials) GPCMPasswordHash(account *Account) (string, error) {
+	switch {
+	case strings.HasPrefix(account.ProofHash, sealedProofPrefix):
+		aead, err := credentials.aead()
+		if err != nil {
+			return "", err
+		}
+		sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(account.ProofHash, sealedProofPrefix))
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return "", errors.New("core: malformed proof hash")
+		}
+		proofHash, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			return "", fmt.Errorf("core: proof hash of account %d doesn't open with the ProofKey", account.ID)
+		}
+		return string(proofHash), nil
+	case account.ProofHash != "":
+		// Stored in the clear by an earlier build, sealed on the next
+		// NuLogin
+		return strings.ToLower(account.ProofHash), nil
+	case isLegacyHash(account.PasswordHash):
+		return strings.ToLower(account.PasswordHash), nil
+	}
+	return "", nil
+}
+
+// VerifyNuLogin checks the nuid and password of a FESL NuLogin. It
+// returns ErrNotFound for unknown accounts and ErrWrongPassword for
+// wrong passwords. Legacy and outdated hashes are replaced once the
+// password matched, if there is a ProofKey to seal the proof hash with.
+func (credentials *Credentials) VerifyNuLogin(ctx context.Context, nuid string, password string) (*Account, error) {
+	account, err := credentials.Accounts.AccountByEmail(ctx, nuid)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := CheckPassword(account.PasswordHash, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrWrongPassword
+	}
+
+	// Without a ProofKey the upgrade can't be stored, don't pay for
+	// hashing it on every login
+	if len(credentials.ProofKey) == 0 {
+		return account, nil
+	}
+	if credentials.Options.NeedsRehash(account.PasswordHash) || !strings.HasPrefix(account.ProofHash, sealedProofPrefix) {
+		// The login goes on with the old hash if this fails, it's
+		// upgraded with the next one
+		if err := credentials.SetPassword(ctx, account, password); err != nil {
+			log.Warningln("Upgrading the password hash of account", account.ID, "threw an error.", err)
+		}
+	}
+	return account, nil
+}
+
+// SetPassword stores a new password of the account
+func (credentials *Credentials) SetPassword(ctx context.Context, account *Account, password string) error {
+	// Checked first, the password hash is the expensive part
+	aead, err := credentials.aead()
+	if err != nil {
+		return err
+	}
+	passwordHash, err := HashPassword(password, credentials.Options)
+	if err != nil {
+		return err
+	}
+
+	proofHash, err := sealProof(aead, password)
+	if err != nil {
+		return err
+	}
+	if err := credentials.Accounts.SetPasswordHash(ctx, account.ID, passwordHash, proofHash); err != nil {
+		return err
+	}
+	account.PasswordHash, account.ProofHash = passwordHash, proofHash
+	return nil
+}
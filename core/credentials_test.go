@@ -0,0 +1,204 @@
+package core_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+	"github.com/HeroesAwaken/GoAwaken/core"
+)
+
+// cheap keeps the tests fast, the hashes are as good as the defaults
+// otherwise
+var cheap = core.PasswordOptions{Argon2Time: 1, Argon2Memory: 64, Argon2Threads: 1, BcryptCost: 4}
+
+var proofKey, _ = core.ParseProofKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+
+// memoryAccounts stores accounts in a map by email
+type memoryAccounts map[string]*core.Account
+
+func (accounts memoryAccounts) CreateAccount(ctx context.Context, account *core.Account) error {
+	account.ID = len(accounts) + 1
+	accounts[account.Email] = account
+	return nil
+}
+
+func (accounts memoryAccounts) AccountByID(ctx context.Context, id int) (*core.Account, error) {
+	for _, account := range accounts {
+		if account.ID == id {
+			copied := *account
+			return &copied, nil
+		}
+	}
+	return nil, core.ErrNotFound
+}
+
+func (accounts memoryAccounts) AccountByEmail(ctx context.Context, email string) (*core.Account, error) {
+	account, ok := accounts[email]
+	if !ok {
+		return nil, core.ErrNotFound
+	}
+	copied := *account
+	return &copied, nil
+}
+
+func (accounts memoryAccounts) SetPasswordHash(ctx context.Context, id int, passwordHash string, proofHash string) error {
+	for _, account := range accounts {
+		if account.ID == id {
+			account.PasswordHash, account.ProofHash = passwordHash, proofHash
+			return nil
+		}
+	}
+	return core.ErrNotFound
+}
+
+// countingAccounts counts the password hashes stored
+type countingAccounts struct {
+	memoryAccounts
+	updates int
+}
+
+func (accounts *countingAccounts) SetPasswordHash(ctx context.Context, id int, passwordHash string, proofHash string) error {
+	accounts.updates++
+	return accounts.memoryAccounts.SetPasswordHash(ctx, id, passwordHash, proofHash)
+}
+
+func (accounts memoryAccounts) SetLastLogin(ctx context.Context, id int, at time.Time) error {
+	return nil
+}
+
+func TestHashPassword(t *testing.T) {
+	for _, algorithm := range []string{core.PasswordArgon2id, core.PasswordBcrypt} {
+		options := cheap
+		options.Algorithm = algorithm
+
+		hash, err := core.HashPassword("secret", options)
+		if err != nil {
+			t.Fatalf("HashPassword(%s) threw an error: %v", algorithm, err)
+		}
+		if strings.Contains(hash, "secret") || strings.Contains(hash, core.ProofHash("secret")) {
+			t.Errorf("HashPassword(%s) leaked the password, got: %s.", algorithm, hash)
+		}
+		if ok, err := core.CheckPassword(hash, "secret"); !ok || err != nil {
+			t.Errorf("CheckPassword(%s) refused the password: %v", algorithm, err)
+		}
+		if ok, err := core.CheckPassword(hash, "wrong"); ok || err != nil {
+			t.Errorf("CheckPassword(%s) took the wrong password: %v", algorithm, err)
+		}
+		if options.NeedsRehash(hash) {
+			t.Errorf("NeedsRehash(%s) wanted a fresh hash rehashed.", algorithm)
+		}
+	}
+
+	argon2, _ := core.HashPassword("secret", cheap)
+	for _, options := range []core.PasswordOptions{
+		{Algorithm: core.PasswordBcrypt, BcryptCost: 4},
+		{Argon2Time: 2, Argon2Memory: 64, Argon2Threads: 1},
+	} {
+		if !options.NeedsRehash(argon2) {
+			t.Errorf("NeedsRehash(%+v) kept %s.", options, argon2)
+		}
+	}
+
+	if ok, err := core.CheckPassword("5EBE2294ECD0E0F08EAB7690D2A6EE69", "secret"); !ok || err != nil {
+		t.Errorf("CheckPassword refused the legacy hash: %v", err)
+	}
+	if !cheap.NeedsRehash("5ebe2294ecd0e0f08eab7690d2a6ee69") {
+		t.Errorf("NeedsRehash kept the legacy hash.")
+	}
+	if _, err := core.CheckPassword("$argon2id$v=19$m=64", "secret"); err == nil {
+		t.Errorf("CheckPassword took a malformed hash.")
+	}
+	for _, params := range []string{"m=64,t=0,p=1", "m=64,t=1,p=0", "m=15,t=1,p=2"} {
+		if _, err := core.CheckPassword("$argon2id$v=19$"+params+"$c2FsdHNhbHRzYWx0$a2V5", "secret"); err == nil {
+			t.Errorf("CheckPassword took a hash with %s.", params)
+		}
+	}
+}
+
+func TestVerifyNuLogin(t *testing.T) {
+	accounts := memoryAccounts{}
+	accounts.CreateAccount(context.Background(), &core.Account{Email: "heroes@example.com", PasswordHash: GameSpy.Hash("secret")})
+	credentials := &core.Credentials{Accounts: accounts, Options: cheap, ProofKey: proofKey}
+	ctx := context.Background()
+
+	if _, err := credentials.VerifyNuLogin(ctx, "nobody@example.com", "secret"); err != core.ErrNotFound {
+		t.Errorf("VerifyNuLogin threw the wrong error: %v", err)
+	}
+	if _, err := credentials.VerifyNuLogin(ctx, "heroes@example.com", "wrong"); err != core.ErrWrongPassword {
+		t.Errorf("VerifyNuLogin threw the wrong error: %v", err)
+	}
+	if stored := accounts["heroes@example.com"]; stored.PasswordHash != GameSpy.Hash("secret") {
+		t.Errorf("A failed login upgraded the hash, got: %+v.", stored)
+	}
+
+	// The legacy hash is the GPCM password hash until it's upgraded
+	legacy, _ := accounts.AccountByEmail(ctx, "heroes@example.com")
+	if hash, err := credentials.GPCMPasswordHash(legacy); hash != GameSpy.Hash("secret") || err != nil {
+		t.Errorf("GPCMPasswordHash of the legacy account was incorrect, got: %s, %v.", hash, err)
+	}
+
+	account, err := credentials.VerifyNuLogin(ctx, "heroes@example.com", "secret")
+	if err != nil {
+		t.Fatalf("VerifyNuLogin threw an error: %v", err)
+	}
+	stored := accounts["heroes@example.com"]
+	if !strings.HasPrefix(stored.PasswordHash, "$argon2id$") || stored.PasswordHash != account.PasswordHash {
+		t.Errorf("VerifyNuLogin didn't upgrade the hash, got: %+v.", stored)
+	}
+	if stored.ProofHash == "" || strings.Contains(stored.ProofHash, GameSpy.Hash("secret")) {
+		t.Errorf("VerifyNuLogin stored the proof hash in the clear, got: %+v.", stored)
+	}
+
+	// The proof of a GPCM login checks out against the stored hash
+	hash, err := credentials.GPCMPasswordHash(account)
+	if err != nil || hash != GameSpy.Hash("secret") {
+		t.Fatalf("GPCMPasswordHash was incorrect, got: %s, %v.", hash, err)
+	}
+	proof := GameSpy.GPCMProof(GameSpy.Hash("secret"), "Heroes", "client", "server")
+	if GameSpy.GPCMProof(hash, "Heroes", "client", "server") != proof {
+		t.Errorf("GPCM proof didn't match.")
+	}
+
+	if _, err := credentials.VerifyNuLogin(ctx, "heroes@example.com", "secret"); err != nil || accounts["heroes@example.com"].PasswordHash != stored.PasswordHash {
+		t.Errorf("VerifyNuLogin rehashed an upgraded hash: %v", err)
+	}
+
+	otherKey, _ := core.ParseProofKey("ffffffffffffffffffffffffffffffff")
+	other := &core.Credentials{Accounts: accounts, Options: cheap, ProofKey: otherKey}
+	if _, err := other.GPCMPasswordHash(account); err == nil {
+		t.Errorf("GPCMPasswordHash opened the proof hash with the wrong key.")
+	}
+	if err := (&core.Credentials{Accounts: accounts, Options: cheap}).SetPassword(ctx, account, "secret"); err == nil {
+		t.Errorf("SetPassword stored a password without a ProofKey.")
+	}
+}
+
+func TestVerifyNuLoginWithoutProofKey(t *testing.T) {
+	accounts := &countingAccounts{memoryAccounts: memoryAccounts{}}
+	accounts.CreateAccount(context.Background(), &core.Account{Email: "heroes@example.com", PasswordHash: GameSpy.Hash("secret")})
+	credentials := &core.Credentials{Accounts: accounts, Options: cheap}
+
+	// The legacy hash keeps working, it's just not upgraded
+	for i := 0; i < 3; i++ {
+		if _, err := credentials.VerifyNuLogin(context.Background(), "heroes@example.com", "secret"); err != nil {
+			t.Fatalf("VerifyNuLogin threw an error: %v", err)
+		}
+	}
+	if accounts.updates != 0 || accounts.memoryAccounts["heroes@example.com"].PasswordHash != GameSpy.Hash("secret") {
+		t.Errorf("SetPasswordHash calls were incorrect, got: %d, want: %d.", accounts.updates, 0)
+	}
+}
+
+func TestParseProofKey(t *testing.T) {
+	if len(proofKey) != 32 {
+		t.Errorf("ParseProofKey was incorrect, got: %x.", proofKey)
+	}
+	for _, invalid := range []string{"", "0001", "not hex"} {
+		if _, err := core.ParseProofKey(invalid); err == nil {
+			t.Errorf("ParseProofKey accepted %q.", invalid)
+		}
+	}
+}
@@ -1,19 +1,85 @@
 package core
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
+	log "github.com/HeroesAwaken/GoAwaken/Log"
 	// Needed since we are using this for opening the connection
 	_ "github.com/go-sql-driver/mysql"
 )
 
-// DB class to work with MySQL database
+// DB class to work with a MySQL database, or an embedded SQLite one.
+// SQLite needs the driver registered as "sqlite3", e.g. by importing
+// github.com/mattn/go-sqlite3, which takes cgo.
 type DB struct {
 	DBConnection *sql.DB
 	mysqlServer  string
 	mysqlUser    string
 	mysqlDB      string
 	mysqlPw      string
+	sqliteFile   string
+	options      DBOptions
+	breaker      *Breaker
+	// repository is the connection of the repository and the
+	// migrations. It's DBConnection, except for MySQL, see RepositoryDSN.
+	repository *sql.DB
+}
+
+// DBOptions tune the connection pool, the deadlines and the circuit
+// breaker of a DB. Zero values take those of DefaultDBOptions.
+type DBOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// QueryTimeout is the deadline of queries whose context has none
+	QueryTimeout time.Duration
+	// The breaker opens after BreakerFailures outages in a row and fails
+	// fast with ErrUnavailable for BreakerCooldown
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+// DefaultDBOptions keep a login waiting on a stalled database for
+// seconds, not forever
+var DefaultDBOptions = DBOptions{
+	MaxOpenConns:    25,
+	MaxIdleConns:    25,
+	ConnMaxLifetime: 5 * time.Minute,
+	ConnMaxIdleTime: time.Minute,
+	QueryTimeout:    5 * time.Second,
+	BreakerFailures: 5,
+	BreakerCooldown: 10 * time.Second,
+}
+
+// SetOptions allows tuning the database, before connecting to it
+func (db *DB) SetOptions(options DBOptions) {
+	if options.MaxOpenConns <= 0 {
+		options.MaxOpenConns = DefaultDBOptions.MaxOpenConns
+	}
+	if options.MaxIdleConns <= 0 {
+		options.MaxIdleConns = DefaultDBOptions.MaxIdleConns
+	}
+	if options.ConnMaxLifetime <= 0 {
+		options.ConnMaxLifetime = DefaultDBOptions.ConnMaxLifetime
+	}
+	if options.ConnMaxIdleTime <= 0 {
+		options.ConnMaxIdleTime = DefaultDBOptions.ConnMaxIdleTime
+	}
+	if options.QueryTimeout <= 0 {
+		options.QueryTimeout = DefaultDBOptions.QueryTimeout
+	}
+	if options.BreakerFailures <= 0 {
+		options.BreakerFailures = DefaultDBOptions.BreakerFailures
+	}
+	if options.BreakerCooldown <= 0 {
+		options.BreakerCooldown = DefaultDBOptions.BreakerCooldown
+	}
+
+	db.options = options
+	db.breaker = NewBreaker(options.BreakerFailures, options.BreakerCooldown)
 }
 
 // SetMysqlServer allows setting the MySQL server to use
@@ -36,15 +102,101 @@ func (db *DB) SetMysqlPw(mysqlPw string) {
 	db.mysqlPw = mysqlPw
 }
 
-// Connect to the MySQL server
+// SetSQLiteFile makes Connect open the SQLite database in the file
+// instead of connecting to MySQL. ":memory:" is a database in memory,
+// gone once it's closed.
+func (db *DB) SetSQLiteFile(sqliteFile string) {
+	db.sqliteFile = sqliteFile
+}
+
+// Dialect returns the SQL the database speaks
+func (db *DB) Dialect() Dialect {
+	if db.sqliteFile != "" {
+		return SQLite
+	}
+	return MySQL
+}
+
+// MySQLDSN returns the data source name Connect opens DBConnection with
+func (db *DB) MySQLDSN() string {
+	return db.mysqlUser + ":" + db.mysqlPw + "@tcp(" + db.mysqlServer + ")/" + db.mysqlDB
+}
+
+// RepositoryDSN returns the data source name of the MySQL connection of
+// the repository and the migrations. It scans DATETIME columns into
+// time.Time and asks for the rows an UPDATE matched instead of those it
+// changed, as SQLite counts them, so updating a row to the values it
+// already has isn't taken for a missing row. DBConnection goes without
+// both, so queries of its own keep their strings and counts.
+func (db *DB) RepositoryDSN() string {
+	return db.MySQLDSN() + "?parseTime=true&clientFoundRows=true"
+}
+
+// Connect to the MySQL server or open the SQLite database
 func (db *DB) Connect() error {
+	if db.breaker == nil {
+		db.SetOptions(DBOptions{})
+	}
+
 	var err error
-	db.DBConnection, err = sql.Open("mysql", db.mysqlUser+":"+db.mysqlPw+"@tcp("+db.mysqlServer+")/"+db.mysqlDB)
+	if db.sqliteFile != "" {
+		db.DBConnection, err = sql.Open("sqlite3", "file:"+db.sqliteFile+"?_foreign_keys=on&_busy_timeout=5000")
+		if err != nil {
+			return err
+		}
+		// SQLite takes one writer at a time, and every connection to
+		// ":memory:" would be a database of its own, which is why the
+		// connection is kept forever
+		db.DBConnection.SetMaxOpenConns(1)
+		db.repository = db.DBConnection
+	} else {
+		db.DBConnection, err = db.openMySQL(db.MySQLDSN())
+		if err != nil {
+			return err
+		}
+		db.repository, err = db.openMySQL(db.RepositoryDSN())
+		if err != nil {
+			db.DBConnection.Close()
+			return err
+		}
+	}
+
+	ctx, cancel := db.WithTimeout(context.Background())
+	defer cancel()
+	err = db.DBConnection.PingContext(ctx)
+	return err
+}
+
+// openMySQL opens a pool on MySQL with the options of the DB
+func (db *DB) openMySQL(dsn string) (*sql.DB, error) {
+	conn, err := sql.Open("mysql", dsn)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	conn.SetMaxOpenConns(db.options.MaxOpenConns)
+	conn.SetMaxIdleConns(db.options.MaxIdleConns)
+	conn.SetConnMaxLifetime(db.options.ConnMaxLifetime)
+	conn.SetConnMaxIdleTime(db.options.ConnMaxIdleTime)
+	return conn, nil
+}
+
+// repositoryConnection returns the connection of the repository and the
+// migrations
+func (db *DB) repositoryConnection() *sql.DB {
+	if db.repository != nil {
+		return db.repository
+	}
+	return db.DBConnection
+}
 
-	err = db.DBConnection.Ping()
+// Close closes the connections to the database
+func (db *DB) Close() error {
+	err := db.DBConnection.Close()
+	if db.repository != nil && db.repository != db.DBConnection {
+		if repositoryErr := db.repository.Close(); err == nil {
+			err = repositoryErr
+		}
+	}
 	return err
 }
 
@@ -57,3 +209,157 @@ func (db *DB) New(mysqlServer string, mysqlDB string, mysqlUser string, mysqlPw
 	err := db.Connect()
 	return db.DBConnection, err
 }
+
+// NewSQLite will open the SQLite database in the file and return the
+// sql.DB
+func (db *DB) NewSQLite(sqliteFile string) (*sql.DB, error) {
+	db.SetSQLiteFile(sqliteFile)
+	err := db.Connect()
+	return db.DBConnection, err
+}
+
+// Migrate applies the pending Migrations to the database
+func (db *DB) Migrate(ctx context.Context) ([]Migration, error) {
+	return Migrate(ctx, db.repositoryConnection(), db.Dialect(), Migrations)
+}
+
+// AppliedMigrations returns when the applied migrations were applied by
+// their version
+func (db *DB) AppliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+	return AppliedMigrations(ctx, db.repositoryConnection())
+}
+
+// Repository returns the accounts, personas, sessions and bans stored in
+// the database. Its queries go through Do of the DB, on a connection of
+// their own.
+func (db *DB) Repository() Repository {
+	return &SQLRepository{db: &DB{
+		DBConnection: db.repositoryConnection(),
+		sqliteFile:   db.sqliteFile,
+		options:      db.options,
+		breaker:      db.breaker,
+	}}
+}
+
+// WithTimeout returns ctx with the QueryTimeout as deadline, unless it
+// has an earlier one
+func (db *DB) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := db.options.QueryTimeout
+	if timeout <= 0 {
+		timeout = DefaultDBOptions.QueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Do runs queries with the deadline of WithTimeout, through the circuit
+// breaker. It returns ErrUnavailable without running fn while the
+// breaker is open.
+func (db *DB) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if db.breaker != nil {
+		if err := db.breaker.Allow(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	err := fn(ctx)
+	if db.breaker != nil {
+		db.breaker.Done(err)
+	}
+	return err
+}
+
+// Exec runs a statement through Do
+func (db *DB) Exec(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	err = db.Do(ctx, func(ctx context.Context) error {
+		result, err = db.DBConnection.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// QueryRow runs a query through Do and scans its first row into dest. It
+// returns sql.ErrNoRows if there is none.
+func (db *DB) QueryRow(ctx context.Context, query string, args []interface{}, dest ...interface{}) error {
+	return db.Do(ctx, func(ctx context.Context) error {
+		return db.DBConnection.QueryRowContext(ctx, query, args...).Scan(dest...)
+	})
+}
+
+// Query runs a query through Do and calls scan for every row
+func (db *DB) Query(ctx context.Context, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	return db.Do(ctx, func(ctx context.Context) error {
+		rows, err := db.DBConnection.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// DBStats are the counters of the connection pool and the circuit
+// breaker of a DB
+type DBStats struct {
+	sql.DBStats
+	Breaker BreakerStats
+}
+
+// Stats returns the counters of the database
+func (db *DB) Stats() DBStats {
+	var stats DBStats
+	if db.DBConnection != nil {
+		stats.DBStats = db.DBConnection.Stats()
+	}
+	if db.breaker != nil {
+		stats.Breaker = db.breaker.Stats()
+	}
+	return stats
+}
+
+// MetricsSink takes metrics, e.g. InfluxDB
+type MetricsSink interface {
+	AddMetric(name string, tags map[string]string, fields map[string]interface{}) error
+}
+
+// ReportMetrics adds the Stats to metrics as "db" every interval until
+// ctx is done
+func (db *DB) ReportMetrics(ctx context.Context, metrics MetricsSink, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats := db.Stats()
+		err := metrics.AddMetric("db", map[string]string{"dialect": string(db.Dialect())}, map[string]interface{}{
+			"maxOpenConnections": stats.MaxOpenConnections,
+			"openConnections":    stats.OpenConnections,
+			"inUse":              stats.InUse,
+			"idle":               stats.Idle,
+			"waitCount":          stats.WaitCount,
+			"waitDurationMs":     stats.WaitDuration.Milliseconds(),
+			"maxIdleClosed":      stats.MaxIdleClosed,
+			"maxIdleTimeClosed":  stats.MaxIdleTimeClosed,
+			"maxLifetimeClosed":  stats.MaxLifetimeClosed,
+			"breakerState":       stats.Breaker.State.String(),
+			"breakerTrips":       int64(stats.Breaker.Trips),
+			"breakerRejected":    int64(stats.Breaker.Rejected),
+		})
+		if err != nil {
+			log.Errorln("Adding the database metrics threw an error.", err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Dialect is the flavour of SQL a database speaks
+type Dialect string
+
+const (
+	// MySQL is the dialect of MySQL and MariaDB
+	MySQL Dialect = "mysql"
+	// SQLite is the dialect of the embedded SQLite database
+	SQLite Dialect = "sqlite3"
+)
+
+// Migration is a versioned change of the schema. Released migrations are
+// never changed, a new one is added instead.
+type Migration struct {
+	Version int
+	Name    string
+	// Statements of each dialect run in order, one at a time since the
+	// MySQL driver doesn't run several statements at once
+	Statements map[Dialect][]string
+}
+
+// Migrations of the schema of the repository, oldest first
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "accounts",
+		Statements: map[Dialect][]string{
+			MySQL: {`CREATE TABLE accounts (
+				id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+				email VARCHAR(255) NOT NULL,
+				password_hash VARCHAR(255) NOT NULL,
+				country CHAR(2) NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				last_login DATETIME NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY accounts_email (email)
+			) DEFAULT CHARSET=utf8mb4`},
+			SQLite: {`CREATE TABLE accounts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				email TEXT NOT NULL COLLATE NOCASE,
+				password_hash TEXT NOT NULL,
+				country TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				last_login DATETIME NULL
+			)`,
+				"CREATE UNIQUE INDEX accounts_email ON accounts (email)",
+			},
+		},
+	},
+	{
+		Version: 2,
+		Name:    "personas",
+		Statements: map[Dialect][]string{
+			MySQL: {`CREATE TABLE personas (
+				id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+				account_id INT UNSIGNED NOT NULL,
+				name VARCHAR(32) NOT NULL,
+				created_at DATETIME NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY personas_name (name),
+				CONSTRAINT personas_account FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+			) DEFAULT CHARSET=utf8mb4`},
+			SQLite: {`CREATE TABLE personas (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				account_id INTEGER NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+				name TEXT NOT NULL COLLATE NOCASE,
+				created_at DATETIME NOT NULL
+			)`,
+				"CREATE UNIQUE INDEX personas_name ON personas (name)",
+				"CREATE INDEX personas_account_id ON personas (account_id)",
+			},
+		},
+	},
+	{
+		Version: 3,
+		Name:    "sessions",
+		Statements: map[Dialect][]string{
+			MySQL: {`CREATE TABLE sessions (
+				session_key VARCHAR(64) NOT NULL,
+				account_id INT UNSIGNED NOT NULL,
+				persona_id INT UNSIGNED NOT NULL DEFAULT 0,
+				address VARCHAR(64) NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL,
+				PRIMARY KEY (session_key),
+				KEY sessions_expires_at (expires_at),
+				CONSTRAINT sessions_account FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+			) DEFAULT CHARSET=utf8mb4`},
+			SQLite: {`CREATE TABLE sessions (
+				session_key TEXT NOT NULL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+				persona_id INTEGER NOT NULL DEFAULT 0,
+				address TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL
+			)`,
+				"CREATE INDEX sessions_expires_at ON sessions (expires_at)",
+			},
+		},
+	},
+	{
+		Version: 4,
+		Name:    "bans",
+		Statements: map[Dialect][]string{
+			MySQL: {`CREATE TABLE bans (
+				id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+				account_id INT UNSIGNED NOT NULL DEFAULT 0,
+				address VARCHAR(64) NOT NULL DEFAULT '',
+				reason VARCHAR(255) NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NULL,
+				PRIMARY KEY (id),
+				KEY bans_account_id (account_id),
+				KEY bans_address (address)
+			) DEFAULT CHARSET=utf8mb4`},
+			SQLite: {`CREATE TABLE bans (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				account_id INTEGER NOT NULL DEFAULT 0,
+				address TEXT NOT NULL DEFAULT '',
+				reason TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NULL
+			)`,
+				"CREATE INDEX bans_account_id ON bans (account_id)",
+				"CREATE INDEX bans_address ON bans (address)",
+			},
+		},
+	},
+	{
+		Version: 5,
+		Name:    "accounts_proof_hash",
+		Statements: map[Dialect][]string{
+			MySQL:  {"ALTER TABLE accounts ADD COLUMN proof_hash VARCHAR(255) NOT NULL DEFAULT '' AFTER password_hash"},
+			SQLite: {"ALTER TABLE accounts ADD COLUMN proof_hash TEXT NOT NULL DEFAULT ''"},
+		},
+	},
+}
+
+// createSchemaMigrations is understood by both dialects
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	applied_at DATETIME NOT NULL,
+	PRIMARY KEY (version)
+)`
+
+// AppliedMigrations returns when each version of the schema was applied
+func AppliedMigrations(ctx context.Context, db *sql.DB) (map[int]time.Time, error) {
+	if _, err := db.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Migrate applies the migrations the database is missing, each within a
+// transaction, and returns those it applied. It refuses to touch a
+// schema newer than the newest migration it knows of, which is a
+// database upgraded by a later version of the server.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect, migrations []Migration) ([]Migration, error) {
+	latest := 0
+	for _, migration := range migrations {
+		if migration.Version <= latest {
+			return nil, fmt.Errorf("core: migration %d %s is out of order", migration.Version, migration.Name)
+		}
+		if len(migration.Statements[dialect]) == 0 {
+			return nil, fmt.Errorf("core: migration %d %s has no statements for %s", migration.Version, migration.Name, dialect)
+		}
+		latest = migration.Version
+	}
+
+	applied, err := AppliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for version := range applied {
+		if version > latest {
+			return nil, fmt.Errorf("core: schema version %d is newer than this build knows of (%d)", version, latest)
+		}
+	}
+
+	var done []Migration
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := applyMigration(ctx, db, dialect, migration); err != nil {
+			return done, fmt.Errorf("core: migration %d %s failed: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// applyMigration runs the statements of a migration and records it.
+// MySQL commits DDL right away, so a failing statement may leave the ones
+// before applied. SQLite rolls them back.
+func applyMigration(ctx context.Context, db *sql.DB, dialect Dialect, migration Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range migration.Statements[dialect] {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, now())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,144 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/HeroesAwaken/GoAwaken/core"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestMigrate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New threw an error: %v", err)
+	}
+	defer db.Close()
+
+	migrations := []core.Migration{
+		{Version: 1, Name: "one", Statements: map[core.Dialect][]string{core.MySQL: {"CREATE TABLE one"}}},
+		{Version: 2, Name: "two", Statements: map[core.Dialect][]string{core.MySQL: {"CREATE TABLE two", "CREATE INDEX two_name"}}},
+		{Version: 3, Name: "three", Statements: map[core.Dialect][]string{core.MySQL: {"CREATE TABLE three"}}},
+	}
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE two").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX two_name").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "two", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE three").WillReturnError(errors.New("disk full"))
+	mock.ExpectRollback()
+
+	done, err := core.Migrate(context.Background(), db, core.MySQL, migrations)
+	if err == nil || !strings.Contains(err.Error(), "migration 3 three failed: disk full") {
+		t.Errorf("Migrate threw the wrong error: %v", err)
+	}
+	if len(done) != 1 || done[0].Version != 2 {
+		t.Errorf("Migrate applied the wrong migrations, got: %+v.", done)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// A schema of a later build is left alone
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()).AddRow(4, time.Now()))
+	if _, err := core.Migrate(context.Background(), db, core.MySQL, migrations); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Migrate threw the wrong error: %v", err)
+	}
+
+	if _, err := core.Migrate(context.Background(), db, core.MySQL, []core.Migration{migrations[1], migrations[0]}); err == nil {
+		t.Errorf("Migrate accepted migrations out of order.")
+	}
+	if _, err := core.Migrate(context.Background(), db, core.SQLite, migrations); err == nil || !strings.Contains(err.Error(), "no statements for sqlite3") {
+		t.Errorf("Migrate threw the wrong error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLRepository(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New threw an error: %v", err)
+	}
+	defer db.Close()
+	repository := core.NewSQLRepository(db)
+	ctx := context.Background()
+
+	mock.ExpectExec("INSERT INTO accounts").
+		WithArgs("heroes@example.com", "hash", "", "DE", sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	account := &core.Account{Email: "heroes@example.com", PasswordHash: "hash", Country: "DE"}
+	if err := repository.CreateAccount(ctx, account); err != nil || account.ID != 7 || account.CreatedAt.IsZero() {
+		t.Errorf("CreateAccount was incorrect, got: %+v, %v.", account, err)
+	}
+
+	mock.ExpectExec("INSERT INTO accounts").WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	if err := repository.CreateAccount(ctx, &core.Account{Email: "heroes@example.com"}); err != core.ErrExists {
+		t.Errorf("CreateAccount threw the wrong error: %v", err)
+	}
+
+	mock.ExpectQuery("SELECT .* FROM accounts WHERE email = ?").WithArgs("nobody@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "proof_hash", "country", "created_at", "last_login"}))
+	if _, err := repository.AccountByEmail(ctx, "nobody@example.com"); err != core.ErrNotFound {
+		t.Errorf("AccountByEmail threw the wrong error: %v", err)
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery("SELECT .* FROM bans").WithArgs(7, "10.0.0.1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "address", "reason", "created_at", "expires_at"}).
+			AddRow(3, 0, "10.0.0.1", "Cheating", created, nil))
+	ban, err := repository.ActiveBan(ctx, 7, "10.0.0.1")
+	if err != nil || ban.ID != 3 || ban.Reason != "Cheating" || !ban.CreatedAt.Equal(created) || !ban.ExpiresAt.IsZero() {
+		t.Errorf("ActiveBan was incorrect, got: %+v, %v.", ban, err)
+	}
+
+	mock.ExpectExec("DELETE FROM personas").WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := repository.DeletePersona(ctx, 9); err != core.ErrNotFound {
+		t.Errorf("DeletePersona threw the wrong error: %v", err)
+	}
+
+	if err := repository.CreateBan(ctx, &core.Ban{Reason: "Nobody"}); err == nil {
+		t.Errorf("CreateBan accepted a ban of nobody.")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMySQLDSN(t *testing.T) {
+	db := new(core.DB)
+	db.SetMysqlServer("127.0.0.1:3306")
+	db.SetMysqlDB("goawaken")
+	db.SetMysqlUser("goawaken")
+	db.SetMysqlPw("secret")
+
+	config, err := mysql.ParseDSN(db.RepositoryDSN())
+	if err != nil {
+		t.Fatalf("ParseDSN threw an error: %v", err)
+	}
+	if config.Addr != "127.0.0.1:3306" || config.DBName != "goawaken" || config.User != "goawaken" || config.Passwd != "secret" {
+		t.Errorf("RepositoryDSN was incorrect, got: %+v.", config)
+	}
+	// Updates to the same values have to count as found
+	if !config.ParseTime || !config.ClientFoundRows {
+		t.Errorf("RepositoryDSN lacks options, got: %+v.", config)
+	}
+
+	// The connection of everybody else behaves as it always did
+	raw, err := mysql.ParseDSN(db.MySQLDSN())
+	if err != nil || raw.ParseTime || raw.ClientFoundRows {
+		t.Errorf("MySQLDSN was incorrect, got: %+v, %v.", raw, err)
+	}
+}
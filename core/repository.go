@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by the repository if there is no such row
+	ErrNotFound = errors.New("core: not found")
+	// ErrExists is returned by the repository when creating a row which
+	// would duplicate a unique key, e.g. the email of an account
+	ErrExists = errors.New("core: already exists")
+)
+
+// Account is a user account, which logs in with its email and password
+type Account struct {
+	ID    int
+	Email string
+	// PasswordHash is never the plain password, see HashPassword. The
+	// old servers stored the bare MD5 hex, see CheckPassword.
+	PasswordHash string
+	// ProofHash is the MD5 hex of the password the GPCM proof is built
+	// from, encrypted with the ProofKey of Credentials. GPCM can't do
+	// without the MD5, so it can't be a one-way hash like PasswordHash:
+	// the encryption keeps a dump of the database from giving away the
+	// MD5, which opens GPCM logins and falls to a dictionary quickly,
+	// but whoever has the ProofKey as well has them all. It's empty for
+	// accounts with a legacy PasswordHash.
+	ProofHash string
+	Country   string
+	CreatedAt time.Time
+	// LastLogin is zero if the account never logged in
+	LastLogin time.Time
+}
+
+// Persona is one of the soldiers or profiles of an account
+type Persona struct {
+	ID        int
+	AccountID int
+	Name      string
+	CreatedAt time.Time
+}
+
+// Session is a login, found by the key handed to the client, e.g. the
+// lkey of FESL
+type Session struct {
+	Key       string
+	AccountID int
+	// PersonaID is 0 until a persona logged in
+	PersonaID int
+	Address   string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Ban keeps an account or an address from logging in
+type Ban struct {
+	ID int
+	// AccountID is 0 for bans of an address only
+	AccountID int
+	// Address is empty for bans of an account only
+	Address   string
+	Reason    string
+	CreatedAt time.Time
+	// ExpiresAt is zero for permanent bans
+	ExpiresAt time.Time
+}
+
+// Accounts stores the accounts
+type Accounts interface {
+	// CreateAccount stores a new account and sets its ID and CreatedAt
+	CreateAccount(ctx context.Context, account *Account) error
+	AccountByID(ctx context.Context, id int) (*Account, error)
+	// AccountByEmail finds an account by its email, ignoring the case
+	AccountByEmail(ctx context.Context, email string) (*Account, error)
+	// SetPasswordHash stores the hashes of a new password, see
+	// Credentials
+	SetPasswordHash(ctx context.Context, id int, passwordHash string, proofHash string) error
+	SetLastLogin(ctx context.Context, id int, at time.Time) error
+}
+
+// Personas stores the personas of the accounts
+type Personas interface {
+	// CreatePersona stores a new persona and sets its ID and CreatedAt
+	CreatePersona(ctx context.Context, persona *Persona) error
+	PersonaByID(ctx context.Context, id int) (*Persona, error)
+	PersonaByName(ctx context.Context, name string) (*Persona, error)
+	// Personas returns the personas of an account, oldest first
+	Personas(ctx context.Context, accountID int) ([]*Persona, error)
+	DeletePersona(ctx context.Context, id int) error
+}
+
+// Sessions stores the logins of the accounts
+type Sessions interface {
+	// CreateSession stores a new session and sets its CreatedAt
+	CreateSession(ctx context.Context, session *Session) error
+	// SessionByKey returns ErrNotFound for expired sessions as well
+	SessionByKey(ctx context.Context, key string) (*Session, error)
+	SetSessionPersona(ctx context.Context, key string, personaID int) error
+	DeleteSession(ctx context.Context, key string) error
+	// DeleteExpiredSessions returns the number of sessions deleted
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+}
+
+// Bans stores the bans of accounts and addresses
+type Bans interface {
+	// CreateBan stores a new ban and sets its ID and CreatedAt
+	CreateBan(ctx context.Context, ban *Ban) error
+	// ActiveBan returns the latest ban in force for the account or the
+	// address, or ErrNotFound if there is none. Pass 0 or "" to check
+	// only one of them.
+	ActiveBan(ctx context.Context, accountID int, address string) (*Ban, error)
+	DeleteBan(ctx context.Context, id int) error
+}
+
+// Repository is everything the login servers store. Handlers should
+// depend on the smallest of its interfaces they need, e.g. Accounts and
+// Sessions for the FESL acct login.
+type Repository interface {
+	Accounts
+	Personas
+	Sessions
+	Bans
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// SQLRepository is the Repository on a MySQL or SQLite database. Its
+// queries are understood by both, the schema is created by Migrate.
+type SQLRepository struct {
+	db *DB
+}
+
+// NewSQLRepository returns the Repository on db. Its queries get the
+// default deadline, DB.Repository adds the circuit breaker of the DB.
+func NewSQLRepository(db *sql.DB) *SQLRepository {
+	return &SQLRepository{db: &DB{DBConnection: db}}
+}
+
+// dbTime is t as stored in the database, which holds no time zones and
+// no fractions of seconds. SQLite compares times as text, which only
+// works out if they are all alike.
+func dbTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+func now() time.Time {
+	return dbTime(time.Now())
+}
+
+// nullTime turns the zero time into NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: dbTime(t), Valid: !t.IsZero()}
+}
+
+// translate turns the errors of the drivers into those of the
+// repository. The SQLite driver is only known by its messages, so core
+// builds without cgo.
+func translate(err error) error {
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case errors.As(err, &mysqlErr) && mysqlErr.Number == 1062:
+		return ErrExists
+	case strings.HasPrefix(err.Error(), "UNIQUE constraint failed"):
+		return ErrExists
+	}
+	return err
+}
+
+// affected returns ErrNotFound if result changed no row
+func affected(result sql.Result, err error) error {
+	if err != nil {
+		return translate(err)
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// insert runs an INSERT and returns the new id
+func (repository *SQLRepository) insert(ctx context.Context, query string, args ...interface{}) (int, error) {
+	result, err := repository.db.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, translate(err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// account returns the account matching where
+func (repository *SQLRepository) account(ctx context.Context, where string, arg interface{}) (*Account, error) {
+	account := new(Account)
+	var lastLogin sql.NullTime
+	err := repository.db.QueryRow(ctx, "SELECT id, email, password_hash, proof_hash, country, created_at, last_login FROM accounts WHERE "+where, []interface{}{arg},
+		&account.ID, &account.Email, &account.PasswordHash, &account.ProofHash, &account.Country, &account.CreatedAt, &lastLogin)
+	if err != nil {
+		return nil, translate(err)
+	}
+	account.LastLogin = lastLogin.Time
+	return account, nil
+}
+
+// CreateAccount stores a new account and sets its ID and CreatedAt
+func (repository *SQLRepository) CreateAccount(ctx context.Context, account *Account) error {
+	createdAt := now()
+	id, err := repository.insert(ctx, "INSERT INTO accounts (email, password_hash, proof_hash, country, created_at, last_login) VALUES (?, ?, ?, ?, ?, ?)",
+		account.Email, account.PasswordHash, account.ProofHash, account.Country, createdAt, nullTime(account.LastLogin))
+	if err != nil {
+		return err
+	}
+	account.ID, account.CreatedAt = id, createdAt
+	return nil
+}
+
+// AccountByID returns the account with the id
+func (repository *SQLRepository) AccountByID(ctx context.Context, id int) (*Account, error) {
+	return repository.account(ctx, "id = ?", id)
+}
+
+// AccountByEmail finds an account by its email, ignoring the case
+func (repository *SQLRepository) AccountByEmail(ctx context.Context, email string) (*Account, error) {
+	return repository.account(ctx, "email = ?", email)
+}
+
+// SetPasswordHash stores the hashes of a new password of an account
+func (repository *SQLRepository) SetPasswordHash(ctx context.Context, id int, passwordHash string, proofHash string) error {
+	return affected(repository.db.Exec(ctx, "UPDATE accounts SET password_hash = ?, proof_hash = ? WHERE id = ?", passwordHash, proofHash, id))
+}
+
+// SetLastLogin records a login of an account
+func (repository *SQLRepository) SetLastLogin(ctx context.Context, id int, at time.Time) error {
+	return affected(repository.db.Exec(ctx, "UPDATE accounts SET last_login = ? WHERE id = ?", nullTime(at), id))
+}
+
+// personas returns the personas matching where, oldest first
+func (repository *SQLRepository) personas(ctx context.Context, where string, arg interface{}) ([]*Persona, error) {
+	var personas []*Persona
+	err := repository.db.Query(ctx, "SELECT id, account_id, name, created_at FROM personas WHERE "+where+" ORDER BY id", []interface{}{arg}, func(rows *sql.Rows) error {
+		persona := new(Persona)
+		personas = append(personas, persona)
+		return rows.Scan(&persona.ID, &persona.AccountID, &persona.Name, &persona.CreatedAt)
+	})
+	return personas, err
+}
+
+// persona returns the persona matching where
+func (repository *SQLRepository) persona(ctx context.Context, where string, arg interface{}) (*Persona, error) {
+	personas, err := repository.personas(ctx, where, arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(personas) == 0 {
+		return nil, ErrNotFound
+	}
+	return personas[0], nil
+}
+
+// CreatePersona stores a new persona and sets its ID and CreatedAt
+func (repository *SQLRepository) CreatePersona(ctx context.Context, persona *Persona) error {
+	createdAt := now()
+	id, err := repository.insert(ctx, "INSERT INTO personas (account_id, name, created_at) VALUES (?, ?, ?)",
+		persona.AccountID, persona.Name, createdAt)
+	if err != nil {
+		return err
+	}
+	persona.ID, persona.CreatedAt = id, createdAt
+	return nil
+}
+
+// PersonaByID returns the persona with the id
+func (repository *SQLRepository) PersonaByID(ctx context.Context, id int) (*Persona, error) {
+	return repository.persona(ctx, "id = ?", id)
+}
+
+// PersonaByName returns the persona with the name
+func (repository *SQLRepository) PersonaByName(ctx context.Context, name string) (*Persona, error) {
+	return repository.persona(ctx, "name = ?", name)
+}
+
+// Personas returns the personas of an account, oldest first
+func (repository *SQLRepository) Personas(ctx context.Context, accountID int) ([]*Persona, error) {
+	return repository.personas(ctx, "account_id = ?", accountID)
+}
+
+// DeletePersona deletes the persona with the id
+func (repository *SQLRepository) DeletePersona(ctx context.Context, id int) error {
+	return affected(repository.db.Exec(ctx, "DELETE FROM personas WHERE id = ?", id))
+}
+
+// CreateSession stores a new session and sets its CreatedAt
+func (repository *SQLRepository) CreateSession(ctx context.Context, session *Session) error {
+	createdAt := now()
+	_, err := repository.db.Exec(ctx, "INSERT INTO sessions (session_key, account_id, persona_id, address, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		session.Key, session.AccountID, session.PersonaID, session.Address, createdAt, dbTime(session.ExpiresAt))
+	if err != nil {
+		return translate(err)
+	}
+	session.CreatedAt = createdAt
+	return nil
+}
+
+// SessionByKey returns the session with the key unless it expired
+func (repository *SQLRepository) SessionByKey(ctx context.Context, key string) (*Session, error) {
+	session := new(Session)
+	err := repository.db.QueryRow(ctx, "SELECT session_key, account_id, persona_id, address, created_at, expires_at FROM sessions WHERE session_key = ? AND expires_at > ?", []interface{}{key, now()},
+		&session.Key, &session.AccountID, &session.PersonaID, &session.Address, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return session, nil
+}
+
+// SetSessionPersona records the persona logged in with a session
+func (repository *SQLRepository) SetSessionPersona(ctx context.Context, key string, personaID int) error {
+	return affected(repository.db.Exec(ctx, "UPDATE sessions SET persona_id = ? WHERE session_key = ?", personaID, key))
+}
+
+// DeleteSession deletes the session with the key
+func (repository *SQLRepository) DeleteSession(ctx context.Context, key string) error {
+	return affected(repository.db.Exec(ctx, "DELETE FROM sessions WHERE session_key = ?", key))
+}
+
+// DeleteExpiredSessions returns the number of sessions deleted
+func (repository *SQLRepository) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	result, err := repository.db.Exec(ctx, "DELETE FROM sessions WHERE expires_at <= ?", now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CreateBan stores a new ban and sets its ID and CreatedAt
+func (repository *SQLRepository) CreateBan(ctx context.Context, ban *Ban) error {
+	if ban.AccountID == 0 && ban.Address == "" {
+		return errors.New("core: a ban needs an account or an address")
+	}
+
+	createdAt := now()
+	id, err := repository.insert(ctx, "INSERT INTO bans (account_id, address, reason, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		ban.AccountID, ban.Address, ban.Reason, createdAt, nullTime(ban.ExpiresAt))
+	if err != nil {
+		return err
+	}
+	ban.ID, ban.CreatedAt = id, createdAt
+	return nil
+}
+
+// ActiveBan returns the latest ban in force for the account or the
+// address, or ErrNotFound if there is none
+func (repository *SQLRepository) ActiveBan(ctx context.Context, accountID int, address string) (*Ban, error) {
+	ban := new(Ban)
+	var expiresAt sql.NullTime
+	err := repository.db.QueryRow(ctx, `SELECT id, account_id, address, reason, created_at, expires_at FROM bans
+		WHERE ((account_id = ? AND account_id <> 0) OR (address = ? AND address <> ''))
+		AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY id DESC LIMIT 1`, []interface{}{accountID, address, now()},
+		&ban.ID, &ban.AccountID, &ban.Address, &ban.Reason, &ban.CreatedAt, &expiresAt)
+	if err != nil {
+		return nil, translate(err)
+	}
+	ban.ExpiresAt = expiresAt.Time
+	return ban, nil
+}
+
+// DeleteBan lifts the ban with the id
+func (repository *SQLRepository) DeleteBan(ctx context.Context, id int) error {
+	return affected(repository.db.Exec(ctx, "DELETE FROM bans WHERE id = ?", id))
+}
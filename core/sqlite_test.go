@@ -0,0 +1,135 @@
+//go:build cgo
+
+package core_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/core"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLite returns a migrated SQLite database in a temporary file
+func openSQLite(t *testing.T) *core.DB {
+	db := new(core.DB)
+	if _, err := db.NewSQLite(filepath.Join(t.TempDir(), "goawaken.db")); err != nil {
+		t.Fatalf("NewSQLite threw an error: %v", err)
+	}
+	t.Cleanup(func() { db.DBConnection.Close() })
+
+	done, err := db.Migrate(context.Background())
+	if err != nil || len(done) != len(core.Migrations) {
+		t.Fatalf("Migrate was incorrect, got: %d migrations, %v.", len(done), err)
+	}
+	return db
+}
+
+func TestSQLiteMigrate(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+
+	if done, err := db.Migrate(ctx); err != nil || len(done) != 0 {
+		t.Errorf("Migrate applied migrations again, got: %+v, %v.", done, err)
+	}
+
+	applied, err := core.AppliedMigrations(ctx, db.DBConnection)
+	if err != nil || len(applied) != len(core.Migrations) {
+		t.Fatalf("AppliedMigrations was incorrect, got: %v, %v.", applied, err)
+	}
+	if age := time.Since(applied[1]); age < 0 || age > time.Minute {
+		t.Errorf("Migration 1 was applied at the wrong time, got: %v.", applied[1])
+	}
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	db := openSQLite(t)
+	repository := db.Repository()
+	ctx := context.Background()
+
+	account := &core.Account{Email: "Heroes@example.com", PasswordHash: "hash"}
+	if err := repository.CreateAccount(ctx, account); err != nil || account.ID == 0 {
+		t.Fatalf("CreateAccount was incorrect, got: %+v, %v.", account, err)
+	}
+	if err := repository.CreateAccount(ctx, &core.Account{Email: "heroes@example.com"}); err != core.ErrExists {
+		t.Errorf("CreateAccount threw the wrong error: %v", err)
+	}
+
+	login := time.Now().Add(-time.Hour)
+	if err := repository.SetLastLogin(ctx, account.ID, login); err != nil {
+		t.Errorf("SetLastLogin threw an error: %v", err)
+	}
+	found, err := repository.AccountByEmail(ctx, "heroes@EXAMPLE.com")
+	if err != nil || found.ID != account.ID || !found.CreatedAt.Equal(account.CreatedAt) || found.LastLogin.Unix() != login.Unix() {
+		t.Errorf("AccountByEmail was incorrect, got: %+v, %v.", found, err)
+	}
+
+	for _, name := range []string{"Heroes", "Villains"} {
+		if err := repository.CreatePersona(ctx, &core.Persona{AccountID: account.ID, Name: name}); err != nil {
+			t.Fatalf("CreatePersona threw an error: %v", err)
+		}
+	}
+	if err := repository.CreatePersona(ctx, &core.Persona{AccountID: account.ID, Name: "heroes"}); err != core.ErrExists {
+		t.Errorf("CreatePersona threw the wrong error: %v", err)
+	}
+	personas, err := repository.Personas(ctx, account.ID)
+	if err != nil || len(personas) != 2 || personas[1].Name != "Villains" {
+		t.Errorf("Personas was incorrect, got: %+v, %v.", personas, err)
+	}
+
+	session := &core.Session{Key: "lkey", AccountID: account.ID, Address: "10.0.0.1", ExpiresAt: time.Now().Add(time.Hour)}
+	expired := &core.Session{Key: "old", AccountID: account.ID, ExpiresAt: time.Now().Add(-time.Hour)}
+	for _, session := range []*core.Session{session, expired} {
+		if err := repository.CreateSession(ctx, session); err != nil {
+			t.Fatalf("CreateSession threw an error: %v", err)
+		}
+	}
+	// The second time around the row is left as it is, which is no
+	// missing row
+	for i := 0; i < 2; i++ {
+		if err := repository.SetSessionPersona(ctx, "lkey", personas[0].ID); err != nil {
+			t.Errorf("SetSessionPersona threw an error: %v", err)
+		}
+	}
+	if found, err := repository.SessionByKey(ctx, "lkey"); err != nil || found.PersonaID != personas[0].ID || found.Address != "10.0.0.1" {
+		t.Errorf("SessionByKey was incorrect, got: %+v, %v.", found, err)
+	}
+	if _, err := repository.SessionByKey(ctx, "old"); err != core.ErrNotFound {
+		t.Errorf("SessionByKey found an expired session: %v", err)
+	}
+	if count, err := repository.DeleteExpiredSessions(ctx); err != nil || count != 1 {
+		t.Errorf("DeleteExpiredSessions was incorrect, got: %d, %v.", count, err)
+	}
+
+	if _, err := repository.ActiveBan(ctx, account.ID, "10.0.0.1"); err != core.ErrNotFound {
+		t.Errorf("ActiveBan threw the wrong error: %v", err)
+	}
+	bans := []*core.Ban{
+		{AccountID: account.ID, Reason: "Expired", ExpiresAt: time.Now().Add(-time.Minute)},
+		{Address: "10.0.0.1", Reason: "Cheating"},
+	}
+	for _, ban := range bans {
+		if err := repository.CreateBan(ctx, ban); err != nil {
+			t.Fatalf("CreateBan threw an error: %v", err)
+		}
+	}
+	if ban, err := repository.ActiveBan(ctx, account.ID, ""); err != core.ErrNotFound {
+		t.Errorf("ActiveBan found an expired ban: %+v, %v", ban, err)
+	}
+	if ban, err := repository.ActiveBan(ctx, account.ID, "10.0.0.1"); err != nil || ban.ID != bans[1].ID || !ban.ExpiresAt.IsZero() {
+		t.Errorf("ActiveBan was incorrect, got: %+v, %v.", ban, err)
+	}
+	if err := repository.DeleteBan(ctx, bans[1].ID); err != nil {
+		t.Errorf("DeleteBan threw an error: %v", err)
+	}
+
+	// Personas and sessions go with their account
+	if _, err := db.DBConnection.ExecContext(ctx, "DELETE FROM accounts WHERE id = ?", account.ID); err != nil {
+		t.Errorf("Deleting the account threw an error: %v", err)
+	}
+	if _, err := repository.PersonaByName(ctx, "Heroes"); err != core.ErrNotFound {
+		t.Errorf("PersonaByName found a persona of a deleted account: %v", err)
+	}
+}
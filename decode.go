@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/HeroesAwaken/GoAwaken/dissect"
+)
+
+// runDecode reads packets as hex, base64 or binary and prints what they
+// decode to
+func runDecode(flags *flag.FlagSet, args []string) error {
+	format := flags.String("format", dissect.FormatAuto, "Input format: auto, hex, base64 or binary")
+	jsonFlag := flags.Bool("json", false, "Print the packets as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errors.New("expected at most one file")
+	}
+
+	var input []byte
+	var err error
+	if flags.NArg() == 1 && flags.Arg(0) != "-" {
+		input, err = os.ReadFile(flags.Arg(0))
+	} else {
+		input, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	raw, err := dissect.ParseInput(input, *format)
+	if err != nil {
+		return err
+	}
+
+	// A single dissector for all of them, chunks may span several packets
+	var dissector dissect.Dissector
+	var packets []dissect.Packet
+	for _, data := range raw {
+		packets = append(packets, dissector.Dissect(data)...)
+	}
+
+	if *jsonFlag {
+		out, err := json.MarshalIndent(packets, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	for _, packet := range packets {
+		fmt.Println(packet.String())
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+// Package dissect decodes raw packets of unknown origin, e.g. the hex
+// dumps of debug logs. It tells FESL, Theater, plain-text GameSpy,
+// XOR'ed GameSpy and QR2 apart and puts chunked FESL messages back
+// together.
+package dissect
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+// Protocols told apart by Dissect
+const (
+	ProtocolFESL       = "fesl"
+	ProtocolTheater    = "theater"
+	ProtocolGameSpy    = "gamespy"
+	ProtocolGameSpyXOR = "gamespy+xor"
+	ProtocolQR2        = "qr2"
+	ProtocolUnknown    = "unknown"
+)
+
+// Field is a single key and value, in the order they were sent
+type Field struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Packet is a decoded packet
+type Packet struct {
+	Protocol string `json:"protocol"`
+	// Type is the FESL or Theater type, the GameSpy query or the name of
+	// the QR2 packet type
+	Type string `json:"type,omitempty"`
+	// ID is the FESL or Theater id, or the QR2 instance key
+	ID     string  `json:"id,omitempty"`
+	Length int     `json:"length"`
+	Fields []Field `json:"fields,omitempty"`
+	// Chunk is set for parts of a chunked FESL message, Chunks for the
+	// message put back together from them
+	Chunk  bool `json:"chunk,omitempty"`
+	Chunks int  `json:"chunks,omitempty"`
+	// Error tells why the packet could not be decoded completely
+	Error string `json:"error,omitempty"`
+	// Raw holds the packet for unknown protocols
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// Dissector decodes packets one after another. It keeps the chunks of
+// FESL messages until the message is complete.
+type Dissector struct {
+	chunks map[string]*chunked
+}
+
+// chunked is a FESL message on its way
+type chunked struct {
+	data  strings.Builder
+	size  int
+	count int
+}
+
+// Dissect decodes a single packet. data may hold several FESL frames or
+// GameSpy commands, they come back one by one.
+func Dissect(data []byte) []Packet {
+	return new(Dissector).Dissect(data)
+}
+
+// Dissect decodes data, which may hold several frames
+func (dissector *Dissector) Dissect(data []byte) []Packet {
+	var packets []Packet
+
+	for len(data) > 0 {
+		var packet Packet
+		var advance int
+
+		switch {
+		case isFESL(data):
+			length := int(binary.BigEndian.Uint32(data[8:12]))
+			packet = dissectFESL(data[:length])
+			advance = length
+		case data[0] == '\\':
+			advance = len(data)
+			if n, _, _ := (GameSpy.GameSpyFramer{}).Split(data); n > 0 {
+				advance = n
+			}
+			packet = dissectGameSpy(data[:advance], ProtocolGameSpy)
+		case isXOR(data):
+			packet = dissectGameSpy(xorGameSpy(data), ProtocolGameSpyXOR)
+			packet.Length = len(data)
+			advance = len(data)
+		case isQR2(data):
+			packet = dissectQR2(data)
+			advance = len(data)
+		default:
+			packet = Packet{Protocol: ProtocolUnknown, Length: len(data), Raw: data}
+			advance = len(data)
+		}
+
+		packets = append(packets, packet)
+		if whole, ok := dissector.reassemble(packet); ok {
+			packets = append(packets, whole)
+		}
+		data = data[advance:]
+	}
+	return packets
+}
+
+// isFESL reports whether data starts with a whole FESL or Theater frame
+func isFESL(data []byte) bool {
+	if len(data) < 12 {
+		return false
+	}
+	for _, c := range data[:4] {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	length := binary.BigEndian.Uint32(data[8:12])
+	return length >= 12 && int(length) <= len(data)
+}
+
+func dissectFESL(frame []byte) Packet {
+	packet := Packet{
+		Protocol: ProtocolFESL,
+		Type:     string(frame[:4]),
+		ID:       fmt.Sprintf("%#08x", binary.BigEndian.Uint32(frame[4:8])),
+		Length:   len(frame),
+		Fields:   parseFESL(string(frame[12:])),
+	}
+
+	// Theater types are upper case, FESL ones lower case
+	if strings.ToUpper(packet.Type) == packet.Type {
+		packet.Protocol = ProtocolTheater
+	}
+
+	if field(packet.Fields, "data") != "" && field(packet.Fields, "decodedSize") != "" {
+		packet.Chunk = true
+	}
+	return packet
+}
+
+// parseFESL splits a FESL payload into its fields, keeping their order
+func parseFESL(payload string) []Field {
+	payload = strings.TrimSuffix(payload, "\x00")
+
+	var fields []Field
+	for _, line := range strings.Split(payload, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+	}
+	return fields
+}
+
+// reassemble collects the chunks of FESL messages. It returns the whole
+// message once the last chunk is in.
+func (dissector *Dissector) reassemble(packet Packet) (Packet, bool) {
+	if !packet.Chunk {
+		return Packet{}, false
+	}
+	if dissector.chunks == nil {
+		dissector.chunks = make(map[string]*chunked)
+	}
+
+	key := packet.Protocol + " " + packet.Type + " " + packet.ID
+	message, ok := dissector.chunks[key]
+	if !ok {
+		size, _ := strconv.Atoi(field(packet.Fields, "decodedSize"))
+		message = &chunked{size: size}
+		dissector.chunks[key] = message
+	}
+	message.data.WriteString(field(packet.Fields, "data"))
+	message.count++
+
+	// The chunks are URL encoded base64, padding and all
+	encoded, err := url.QueryUnescape(message.data.String())
+	if err != nil {
+		encoded = message.data.String()
+	}
+	if base64.StdEncoding.DecodedLen(len(encoded)) < message.size {
+		return Packet{}, false
+	}
+	delete(dissector.chunks, key)
+
+	whole := Packet{
+		Protocol: packet.Protocol,
+		Type:     packet.Type,
+		ID:       packet.ID,
+		Chunks:   message.count,
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		whole.Error = "chunks don't decode: " + err.Error()
+		return whole, true
+	}
+	whole.Length = len(decoded)
+	whole.Fields = parseFESL(string(decoded))
+	if len(decoded) != message.size {
+		whole.Error = fmt.Sprintf("decoded %d bytes instead of %d", len(decoded), message.size)
+	}
+	return whole, true
+}
+
+func dissectGameSpy(data []byte, protocol string) Packet {
+	packet := Packet{Protocol: protocol, Length: len(data)}
+
+	command := strings.TrimSpace(strings.TrimSuffix(string(data), "\\final\\"))
+	parts := strings.Split(command, "\\")
+	if len(parts) < 2 {
+		packet.Error = "no query"
+		packet.Raw = data
+		return packet
+	}
+
+	packet.Type = parts[1]
+	for i := 1; i < len(parts); i += 2 {
+		value := ""
+		if i+1 < len(parts) {
+			value = parts[i+1]
+		}
+		if i == 1 && value == "" {
+			continue
+		}
+		packet.Fields = append(packet.Fields, Field{Key: parts[i], Value: value})
+	}
+	return packet
+}
+
+var gamespyKey = []byte("gamespy")
+
+func xorGameSpy(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i := range data {
+		out[i] = data[i] ^ gamespyKey[i%len(gamespyKey)]
+	}
+	return out
+}
+
+// isXOR reports whether data turns into GameSpy text with the XOR taken
+// off
+func isXOR(data []byte) bool {
+	decoded := xorGameSpy(data)
+	if decoded[0] != '\\' {
+		return false
+	}
+	for _, c := range decoded {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+func field(fields []Field, key string) string {
+	for _, field := range fields {
+		if field.Key == key {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// String formats the packet as text, the way the decode command prints
+// it
+func (packet Packet) String() string {
+	var b strings.Builder
+
+	b.WriteString(packet.Protocol)
+	if packet.Type != "" {
+		b.WriteString(" " + packet.Type)
+	}
+	if packet.ID != "" {
+		b.WriteString(" " + packet.ID)
+	}
+	fmt.Fprintf(&b, " (%d bytes)", packet.Length)
+	if packet.Chunk {
+		b.WriteString(" chunk")
+	}
+	if packet.Chunks > 0 {
+		fmt.Fprintf(&b, " reassembled from %d chunks", packet.Chunks)
+	}
+	b.WriteString("\n")
+
+	for _, field := range packet.Fields {
+		value := strconv.Quote(field.Value)
+		fmt.Fprintf(&b, "  %s=%s\n", field.Key, value[1:len(value)-1])
+	}
+	if packet.Error != "" {
+		fmt.Fprintf(&b, "  error: %s\n", packet.Error)
+	}
+	if len(packet.Raw) > 0 {
+		for _, line := range strings.Split(strings.TrimRight(hex.Dump(packet.Raw), "\n"), "\n") {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	return b.String()
+}
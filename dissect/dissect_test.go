@@ -0,0 +1,130 @@
+package dissect_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+	"github.com/HeroesAwaken/GoAwaken/dissect"
+)
+
+func value(packet dissect.Packet, key string) string {
+	for _, field := range packet.Fields {
+		if field.Key == key {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+func TestDissect(t *testing.T) {
+	fesl := GameSpy.EncodeFESL("acct", map[string]string{"TXN": "NuLogin", "nuid": "hero"}, 0xC0000002)
+	theater := GameSpy.EncodeFESL("CONN", map[string]string{"TXN": "CONN", "PROT": "2"}, 0x40000001)
+	command := []byte("\\login\\\\uniquenick\\hero\\id\\1\\final\\")
+	xored := GameSpy.XORFramer{Framer: GameSpy.GameSpyFramer{}}.Encode(command)
+	qr2 := append([]byte{0x03, 0x12, 0x34, 0x56, 0x78}, "gamename\x00heroes\x00password\x00\x00\x00\x00\x01"...)
+
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		protocol string
+		typ      string
+		key      string
+		value    string
+	}{
+		{"fesl", fesl, dissect.ProtocolFESL, "acct", "nuid", "hero"},
+		{"theater", theater, dissect.ProtocolTheater, "CONN", "PROT", "2"},
+		{"gamespy", command, dissect.ProtocolGameSpy, "login", "uniquenick", "hero"},
+		{"xor", xored, dissect.ProtocolGameSpyXOR, "login", "id", "1"},
+		{"qr2", qr2, dissect.ProtocolQR2, "heartbeat", "gamename", "heroes"},
+		{"unknown", []byte{0xFF, 0x00}, dissect.ProtocolUnknown, "", "", ""},
+	} {
+		packets := dissect.Dissect(test.data)
+		if len(packets) != 1 {
+			t.Errorf("%s: Dissect returned %d packets instead of 1.", test.name, len(packets))
+			continue
+		}
+		packet := packets[0]
+		if packet.Protocol != test.protocol || packet.Type != test.typ || value(packet, test.key) != test.value || packet.Length != len(test.data) {
+			t.Errorf("%s: Dissect was incorrect, got: %+v.", test.name, packet)
+		}
+	}
+
+	// A FESL frame followed by another one in the same read
+	if packets := dissect.Dissect(append(append([]byte{}, fesl...), theater...)); len(packets) != 2 || packets[1].Protocol != dissect.ProtocolTheater {
+		t.Errorf("Dissecting two frames was incorrect, got: %+v.", packets)
+	}
+
+	// Empty QR2 values don't end the key section
+	packet := dissect.Dissect(qr2)[0]
+	if value(packet, "password") != "" || value(packet, "players") != "0001" {
+		t.Errorf("QR2 heartbeat was incorrect, got: %+v.", packet)
+	}
+}
+
+func TestDissectChunks(t *testing.T) {
+	payload := "TXN=GetStats\nstats.0.key=kills\nstats.0.value=1337\n"
+	encoded := url.QueryEscape(base64.StdEncoding.EncodeToString([]byte(payload)))
+	half := len(encoded) / 2
+
+	var dissector dissect.Dissector
+	var packets []dissect.Packet
+	for _, part := range []string{encoded[:half], encoded[half:]} {
+		frame := GameSpy.EncodeFESL("rank", map[string]string{
+			"data":        part,
+			"decodedSize": strconv.Itoa(len(payload)),
+			"size":        strconv.Itoa(len(encoded)),
+		}, 0xB0000003)
+		packets = append(packets, dissector.Dissect(frame)...)
+	}
+
+	if len(packets) != 3 || !packets[0].Chunk || !packets[1].Chunk {
+		t.Fatalf("Dissecting chunks was incorrect, got: %+v.", packets)
+	}
+	whole := packets[2]
+	if whole.Chunks != 2 || whole.Error != "" || whole.Length != len(payload) || value(whole, "stats.0.value") != "1337" {
+		t.Errorf("Reassembled message was incorrect, got: %+v.", whole)
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	frame := GameSpy.EncodeFESL("fsys", map[string]string{"TXN": "Hello"}, 0xC0000001)
+
+	for _, test := range []struct {
+		name   string
+		input  string
+		format string
+		want   []string
+	}{
+		{"log", "2024/01/01 12:00:00 [Info] Got " + hex.EncodeToString(frame) + " from client\n" +
+			"2024/01/01 12:00:01 [Info] Got 5c 6c 63 5c 31 5c\n", dissect.FormatAuto,
+			[]string{string(frame), "\\lc\\1\\"}},
+		{"dump", hex.Dump(frame) + hex.Dump([]byte("\\lc\\1\\final\\")), dissect.FormatAuto,
+			[]string{string(frame), "\\lc\\1\\final\\"}},
+		{"base64", base64.StdEncoding.EncodeToString(frame) + "\n", dissect.FormatAuto, []string{string(frame)}},
+		{"binary", string(frame), dissect.FormatAuto, []string{string(frame)}},
+		{"forced", "5c6c63", dissect.FormatBinary, []string{"5c6c63"}},
+	} {
+		packets, err := dissect.ParseInput([]byte(test.input), test.format)
+		if err != nil {
+			t.Errorf("%s: ParseInput threw an error: %v", test.name, err)
+			continue
+		}
+		if len(packets) != len(test.want) {
+			t.Errorf("%s: ParseInput returned %d packets instead of %d.", test.name, len(packets), len(test.want))
+			continue
+		}
+		for i, want := range test.want {
+			if string(packets[i]) != want {
+				t.Errorf("%s: Packet %d was incorrect, got: %q, want: %q.", test.name, i, packets[i], want)
+			}
+		}
+	}
+
+	if _, err := dissect.ParseInput([]byte("nothing to see"), dissect.FormatHex); err != dissect.ErrNoPackets {
+		t.Errorf("ParseInput threw the wrong error: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package dissect
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Input formats understood by ParseInput
+const (
+	FormatAuto   = "auto"
+	FormatHex    = "hex"
+	FormatBase64 = "base64"
+	FormatBinary = "binary"
+)
+
+// ErrNoPackets is returned by ParseInput if the input holds nothing to
+// decode
+var ErrNoPackets = errors.New("no packets found")
+
+var (
+	// hexRun is a run of at least four hex bytes, possibly separated by
+	// single spaces or colons
+	hexRun = regexp.MustCompile(`(?:[0-9a-fA-F]{2}[ :]?){4,}`)
+	// dumpLine is a line of hex.Dump or hexdump -C output
+	dumpLine = regexp.MustCompile(`^([0-9a-fA-F]{8})  ((?:[0-9a-fA-F]{2} {1,2})+)`)
+)
+
+// ParseInput turns input in the given format into packets.
+//
+// Binary input is a single packet. Base64 input is a single packet too.
+// Hex input is read line by line, e.g. from a server log: every line
+// holding a hex run is a packet, and hex.Dump output is put back together
+// with every dump starting at offset 0 being a new packet. FormatAuto
+// tells the formats apart.
+func ParseInput(input []byte, format string) ([][]byte, error) {
+	if format == FormatAuto {
+		format = detectFormat(input)
+	}
+
+	var packets [][]byte
+	switch format {
+	case FormatBinary:
+		if len(input) > 0 {
+			packets = append(packets, input)
+		}
+	case FormatBase64:
+		packet, err := decodeBase64(string(input))
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, packet)
+	case FormatHex:
+		packets = parseHex(string(input))
+	default:
+		return nil, errors.New("unknown input format " + format)
+	}
+
+	if len(packets) == 0 {
+		return nil, ErrNoPackets
+	}
+	return packets, nil
+}
+
+// detectFormat guesses the format of input
+func detectFormat(input []byte) string {
+	for _, r := range string(bytes.TrimSpace(input)) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return FormatBinary
+		}
+	}
+
+	// Base64 comes as one word per line, log lines hold more than that
+	text := string(input)
+	words := strings.Fields(text)
+	if len(words) == len(strings.Split(strings.TrimSpace(text), "\n")) {
+		joined := strings.Join(words, "")
+		if _, err := decodeBase64(joined); err == nil && !isHex(joined) {
+			return FormatBase64
+		}
+	}
+	if hexRun.MatchString(text) {
+		return FormatHex
+	}
+	return FormatBinary
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func decodeBase64(text string) ([]byte, error) {
+	text = strings.Join(strings.Fields(text), "")
+	if text == "" {
+		return nil, ErrNoPackets
+	}
+	packet, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		packet, err = base64.RawStdEncoding.DecodeString(text)
+	}
+	return packet, err
+}
+
+func parseHex(text string) [][]byte {
+	var packets [][]byte
+	var dump []byte
+
+	flush := func() {
+		if len(dump) > 0 {
+			packets = append(packets, dump)
+		}
+		dump = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if match := dumpLine.FindStringSubmatch(line); match != nil {
+			if match[1] == "00000000" {
+				flush()
+			}
+			if data, err := hex.DecodeString(strings.Join(strings.Fields(match[2]), "")); err == nil {
+				dump = append(dump, data...)
+			}
+			continue
+		}
+		flush()
+
+		var longest string
+		for _, run := range hexRun.FindAllString(line, -1) {
+			run = strings.NewReplacer(" ", "", ":", "").Replace(run)
+			if len(run) > len(longest) {
+				longest = run
+			}
+		}
+		if data, err := hex.DecodeString(longest); err == nil && len(data) > 0 {
+			packets = append(packets, data)
+		}
+	}
+	flush()
+	return packets
+}
@@ -0,0 +1,108 @@
+package dissect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// QR2 packet types. Clients send the type and the instance key in front
+// of every packet, the master server puts 0xFE 0xFD before them.
+var qr2Types = []string{
+	0x00: "query",
+	0x01: "challenge",
+	0x02: "echo",
+	0x03: "heartbeat",
+	0x04: "adderror",
+	0x05: "echo_response",
+	0x06: "client_message",
+	0x07: "client_message_ack",
+	0x08: "keepalive",
+	0x09: "available",
+	0x0A: "client_registered",
+}
+
+// isQR2 reports whether data looks like a QR2 packet
+func isQR2(data []byte) bool {
+	if len(data) >= 7 && data[0] == 0xFE && data[1] == 0xFD {
+		return int(data[2]) < len(qr2Types)
+	}
+	return len(data) >= 5 && int(data[0]) < len(qr2Types)
+}
+
+func dissectQR2(data []byte) Packet {
+	packet := Packet{Protocol: ProtocolQR2, Length: len(data)}
+
+	fromServer := data[0] == 0xFE && data[1] == 0xFD
+	if fromServer {
+		data = data[2:]
+	}
+
+	packetType := data[0]
+	packet.Type = qr2Types[packetType]
+	packet.ID = fmt.Sprintf("%#08x", binary.BigEndian.Uint32(data[1:5]))
+	payload := data[5:]
+
+	direction := "client"
+	if fromServer {
+		direction = "server"
+	}
+	packet.Fields = append(packet.Fields, Field{Key: "from", Value: direction})
+
+	switch {
+	case packetType == 0x03 && !fromServer:
+		fields, rest := qr2Pairs(payload)
+		packet.Fields = append(packet.Fields, fields...)
+		if len(rest) > 0 {
+			// The player and team sections follow the server keys
+			packet.Fields = append(packet.Fields, Field{Key: "players", Value: hex.EncodeToString(rest)})
+		}
+	case packetType == 0x01, packetType == 0x04, packetType == 0x09:
+		values, rest := qr2Strings(payload)
+		for _, s := range values {
+			packet.Fields = append(packet.Fields, Field{Key: packet.Type, Value: s})
+		}
+		if len(rest) > 0 {
+			packet.Fields = append(packet.Fields, Field{Key: "data", Value: hex.EncodeToString(rest)})
+		}
+	case len(payload) > 0:
+		packet.Fields = append(packet.Fields, Field{Key: "data", Value: hex.EncodeToString(payload)})
+	}
+	return packet
+}
+
+// qr2Strings reads NUL terminated strings up to the first empty one. It
+// returns the strings and whatever follows the empty one.
+func qr2Strings(data []byte) ([]string, []byte) {
+	var values []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, 0)
+		if i == -1 {
+			// Unterminated, take it as it is
+			return append(values, string(data)), nil
+		}
+		if i == 0 {
+			return values, data[1:]
+		}
+		values = append(values, string(data[:i]))
+		data = data[i+1:]
+	}
+	return values, nil
+}
+
+// qr2Pairs reads NUL terminated keys and values up to the first empty
+// key. Values may be empty.
+func qr2Pairs(data []byte) ([]Field, []byte) {
+	var fields []Field
+	for len(data) > 0 {
+		key, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(key) == 0 {
+			return fields, rest
+		}
+		value, rest, _ := bytes.Cut(rest, []byte{0})
+		fields = append(fields, Field{Key: string(key), Value: string(value)})
+		data = rest
+	}
+	return fields, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gs "github.com/HeroesAwaken/GoAwaken/GameSpy"
+	"github.com/HeroesAwaken/GoAwaken/pcap"
+)
+
+// portFlags collects -port flags like 18300=fesl:fesl
+type portFlags map[uint16]pcap.Port
+
+func (ports portFlags) String() string {
+	return ""
+}
+
+func (ports portFlags) Set(value string) error {
+	number, service, ok := strings.Cut(value, "=")
+	name, protocol, ok2 := strings.Cut(service, ":")
+	if !ok || !ok2 {
+		return errors.New("expected port=name:protocol, e.g. 18300=fesl:fesl")
+	}
+
+	port, err := strconv.ParseUint(number, 10, 16)
+	if err != nil {
+		return err
+	}
+	if _, err := gs.FramerFor(protocol); err != nil {
+		return err
+	}
+
+	ports[uint16(port)] = pcap.Port{Name: name, Protocol: protocol}
+	return nil
+}
+
+// runImportPcap reads a pcap file and writes a transcript and a replay
+// capture for every flow on a known port
+func runImportPcap(flags *flag.FlagSet, args []string) error {
+	ports := make(portFlags)
+	for port, service := range pcap.DefaultPorts {
+		ports[port] = service
+	}
+
+	out := flags.String("out", ".", "Directory to write the transcripts and captures to")
+	printFlag := flags.Bool("print", false, "Print the transcripts instead of writing files")
+	flags.Var(ports, "port", "Additional server port as port=name:protocol, protocol being gamespy, gamespy+xor, fesl, irc or qr2. Repeatable.")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected a single pcap file")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	result, err := pcap.Import(file, pcap.Config{Ports: ports})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d packets, %d ignored, %d flows\n", result.Packets, result.Ignored, len(result.Flows))
+
+	if *printFlag {
+		for _, flow := range result.Flows {
+			if err := pcap.WriteTranscript(os.Stdout, flow); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	for _, flow := range result.Flows {
+		base := filepath.Join(*out, fmt.Sprintf("%03d-%s-%s", flow.Capture.ClientID, flow.Port.Name,
+			strings.NewReplacer(":", "_", "[", "", "]", "").Replace(flow.Client.String())))
+
+		if err := writeFile(base+".txt", func(file *os.File) error {
+			return pcap.WriteTranscript(file, flow)
+		}); err != nil {
+			return err
+		}
+
+		// Encrypted flows hold no frames worth replaying
+		if !flow.Encrypted {
+			if err := writeFile(base+".gacap", func(file *os.File) error {
+				return gs.WriteCapture(file, flow.Capture)
+			}); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d frames, %d notes\n", base, len(flow.Capture.Records), len(flow.Notes))
+	}
+	return nil
+}
+
+func writeFile(path string, write func(file *os.File) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
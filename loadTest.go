@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/loadtest"
+)
+
+// runLoadTest simulates players against a FESL and Theater server and
+// prints how every transaction went
+func runLoadTest(flags *flag.FlagSet, args []string) error {
+	var (
+		feslFlag     = flags.String("fesl", "127.0.0.1:18270", "Address of the FESL server")
+		theaterFlag  = flags.String("theater", "", "Address of the Theater server, empty for the one the Hello names")
+		playersFlag  = flags.Int("players", 100, "Number of players")
+		rampUpFlag   = flags.Duration("rampUp", 10*time.Second, "Time over which the players are started")
+		durationFlag = flags.Duration("duration", 0, "Run the scenario again and again for this long after the ramp-up, 0 runs it once")
+		scenarioFlag = flags.String("scenario", "", "Scenario script, empty for the default one")
+		userFlag     = flags.String("user", "player%d@example.com", "Account of the players, %d is the number of the player")
+		passFlag     = flags.String("password", "password", "Password of the players")
+		personaFlag  = flags.String("persona", "player%d", "Persona of the players, %d is the number of the player")
+		tlsFlag      = flags.Bool("tls", false, "Connect to FESL over TLS, without checking the certificate")
+		timeoutFlag  = flags.Duration("timeout", 10*time.Second, "Timeout of a single transaction")
+		seedFlag     = flags.Int64("seed", time.Now().UnixNano(), "Seed of the think times")
+		printFlag    = flags.Bool("printScenario", false, "Print the default scenario and exit")
+	)
+	flags.Parse(args)
+
+	if *printFlag {
+		fmt.Print(loadtest.DefaultScenario)
+		return nil
+	}
+	if *playersFlag < 1 {
+		return errors.New("expected at least one player")
+	}
+
+	var scenario *loadtest.Scenario
+	var err error
+	if *scenarioFlag == "" {
+		scenario, err = loadtest.ParseScenario(strings.NewReader(loadtest.DefaultScenario))
+	} else {
+		var file *os.File
+		if file, err = os.Open(*scenarioFlag); err != nil {
+			return err
+		}
+		scenario, err = loadtest.ParseScenario(file)
+		file.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	config := loadtest.Config{
+		FESL:     *feslFlag,
+		Theater:  *theaterFlag,
+		Players:  *playersFlag,
+		RampUp:   *rampUpFlag,
+		Duration: *durationFlag,
+		User:     *userFlag,
+		Password: *passFlag,
+		Persona:  *personaFlag,
+		Scenario: scenario,
+		Seed:     *seedFlag,
+	}
+	config.Timeout = *timeoutFlag
+	if *tlsFlag {
+		config.TLS = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	// Interrupting stops the players and still prints the report
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "Starting %d players over %v\n", config.Players, config.RampUp)
+	stats := loadtest.Run(ctx, config)
+	return stats.WriteReport(os.Stdout)
+}
@@ -0,0 +1,272 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+// ErrNotConnected is recorded for steps which need a connection the
+// scenario hasn't opened
+var ErrNotConnected = errors.New("not connected")
+
+// Config configures a load test
+type Config struct {
+	GameSpy.DialConfig
+	// FESL is the address of the FESL server. Theater is taken from the
+	// Hello if it's empty.
+	FESL    string
+	Theater string
+	// Players is how many players there are at most
+	Players int
+	// RampUp spreads the start of the players. 0 starts all at once.
+	RampUp time.Duration
+	// Duration runs the scenario again and again until it's over. 0
+	// runs it once.
+	Duration time.Duration
+	// User, Password and Persona are the credentials of every player.
+	// User and Persona are formatted with the number of the player,
+	// starting at 1, e.g. "player%d".
+	User     string
+	Password string
+	Persona  string
+	Scenario *Scenario
+	// Seed makes the think times repeatable
+	Seed int64
+}
+
+// Run runs the load test until every player is done, Duration is over
+// or ctx is canceled
+func Run(ctx context.Context, config Config) *Stats {
+	stats := NewStats()
+	defer stats.stop()
+
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.RampUp+config.Duration)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.Players; i++ {
+		if config.RampUp > 0 && i > 0 {
+			select {
+			case <-time.After(config.RampUp / time.Duration(config.Players)):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		p := &player{
+			config: config,
+			stats:  stats,
+			number: i + 1,
+			rand:   rand.New(rand.NewSource(config.Seed + int64(i))),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.run(ctx)
+		}()
+	}
+	wg.Wait()
+	return stats
+}
+
+// player is a single simulated player
+type player struct {
+	config Config
+	stats  *Stats
+	number int
+	rand   *rand.Rand
+
+	fesl    *GameSpy.FESLConn
+	theater *GameSpy.TheaterConn
+	lkey    string
+	name    string
+}
+
+func (p *player) run(ctx context.Context) {
+	p.stats.join()
+	defer p.stats.leave()
+
+	for {
+		ok := p.steps(ctx, p.config.Scenario.Steps)
+		p.logout()
+
+		if p.config.Duration == 0 || ctx.Err() != nil {
+			return
+		}
+		if !ok {
+			// Don't hammer a server which is down already
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// steps runs steps until one fails, a player gets nowhere after that
+func (p *player) steps(ctx context.Context, steps []Step) bool {
+	for _, step := range steps {
+		if ctx.Err() != nil {
+			return false
+		}
+
+		switch step.Op {
+		case OpRepeat:
+			for i := 0; i < step.Count; i++ {
+				if !p.steps(ctx, step.Steps) {
+					return false
+				}
+			}
+			continue
+		case OpThink:
+			think := step.Min
+			if step.Max > step.Min {
+				think += time.Duration(p.rand.Int63n(int64(step.Max - step.Min)))
+			}
+			select {
+			case <-time.After(think):
+			case <-ctx.Done():
+				return false
+			}
+			continue
+		case OpLogout:
+			p.logout()
+			continue
+		}
+
+		if !p.step(ctx, step) {
+			return false
+		}
+	}
+	return true
+}
+
+// step runs a step which talks to the server, recording how it went
+func (p *player) step(ctx context.Context, step Step) bool {
+	var err error
+	switch step.Op {
+	case OpLogin:
+		err = p.login(ctx)
+	case OpTheater:
+		err = p.joinTheater(ctx)
+	case OpCall, OpSend:
+		err = p.timed(ctx, step.name(), func() error {
+			return p.message(ctx, step)
+		})
+	}
+	return err == nil
+}
+
+// timed runs call and records it, unless the load test was over before
+// it finished
+func (p *player) timed(ctx context.Context, name string, call func() error) error {
+	start := time.Now()
+	err := call()
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	p.stats.Record(name, time.Since(start), err)
+	return err
+}
+
+func (p *player) login(ctx context.Context) error {
+	p.logout()
+
+	var err error
+	if err = p.timed(ctx, "fesl.fsys.Hello", func() error {
+		p.fesl, err = GameSpy.DialFESL(ctx, p.config.FESL, GameSpy.FESLConfig{DialConfig: p.config.DialConfig})
+		return err
+	}); err != nil {
+		return err
+	}
+
+	user := fmt.Sprintf(p.config.User, p.number)
+	if err = p.timed(ctx, "fesl.acct.NuLogin", func() error {
+		_, err := p.fesl.NuLogin(ctx, user, p.config.Password, "")
+		return err
+	}); err != nil {
+		return err
+	}
+
+	p.name = fmt.Sprintf(p.config.Persona, p.number)
+	return p.timed(ctx, "fesl.acct.NuLoginPersona", func() error {
+		persona, err := p.fesl.NuLoginPersona(ctx, p.name)
+		if err == nil {
+			p.lkey = persona.LKey
+		}
+		return err
+	})
+}
+
+func (p *player) joinTheater(ctx context.Context) error {
+	if p.fesl == nil {
+		p.stats.Record("theater.CONN", 0, ErrNotConnected)
+		return ErrNotConnected
+	}
+
+	address := p.config.Theater
+	if address == "" {
+		hello := p.fesl.Hello
+		address = net.JoinHostPort(hello.TheaterIP, strconv.Itoa(hello.TheaterPort))
+	}
+
+	var err error
+	if err = p.timed(ctx, "theater.CONN", func() error {
+		// Theater is plain TCP, whatever FESL is
+		dial := p.config.DialConfig
+		dial.TLS = nil
+		p.theater, err = GameSpy.DialTheater(ctx, address, GameSpy.TheaterConfig{DialConfig: dial})
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return p.timed(ctx, "theater.USER", func() error {
+		_, err := p.theater.SendUser(ctx, p.lkey, p.name, "")
+		return err
+	})
+}
+
+// message runs a call or send step
+func (p *player) message(ctx context.Context, step Step) error {
+	switch {
+	case step.Conn == "fesl" && p.fesl != nil:
+		if step.Op == OpSend {
+			return p.fesl.Send(ctx, step.Type, step.Fields, 0xC0000000)
+		}
+		_, err := p.fesl.Call(ctx, step.Type, step.Fields)
+		return err
+	case step.Conn == "theater" && p.theater != nil:
+		if step.Op == OpSend {
+			return p.theater.Send(ctx, step.Type, step.Fields, 0)
+		}
+		_, err := p.theater.Call(ctx, step.Type, step.Fields)
+		return err
+	}
+	return ErrNotConnected
+}
+
+func (p *player) logout() {
+	if p.theater != nil {
+		p.theater.Close()
+		p.theater = nil
+	}
+	if p.fesl != nil {
+		p.fesl.Close()
+		p.fesl = nil
+	}
+}
@@ -0,0 +1,143 @@
+package loadtest_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+	"github.com/HeroesAwaken/GoAwaken/loadtest"
+)
+
+// startServer runs a FESL server answering with answer, which gets the
+// request and returns the answer or nil for none
+func startServer(t *testing.T, answer func(message *GameSpy.CommandFESL) map[string]string) *GameSpy.PipeListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	listener := GameSpy.NewPipeListener()
+	server := &GameSpy.Server{Name: "Load", Framer: GameSpy.FESLFramer{}}
+	events, err := server.Listen(ctx, listener)
+	if err != nil {
+		t.Fatalf("Listen threw an error: %v", err)
+	}
+	go func() {
+		for event := range events {
+			command, ok := event.Data.(GameSpy.EventClientFESLCommand)
+			if !ok || event.Name == "client.command" {
+				continue
+			}
+			if msg := answer(command.Command); msg != nil {
+				command.Client.WriteFESL(command.Command.Query, msg, command.Command.PayloadID)
+			}
+		}
+	}()
+	return listener
+}
+
+func TestParseScenario(t *testing.T) {
+	scenario, err := loadtest.ParseScenario(strings.NewReader(loadtest.DefaultScenario))
+	if err != nil {
+		t.Fatalf("ParseScenario threw an error: %v", err)
+	}
+	if len(scenario.Steps) != 8 {
+		t.Fatalf("Default scenario was incorrect, got: %+v.", scenario.Steps)
+	}
+	repeat := scenario.Steps[6]
+	if repeat.Op != loadtest.OpRepeat || repeat.Count != 5 || len(repeat.Steps) != 3 || repeat.Steps[0].Max != 10*time.Second {
+		t.Errorf("Repeat was incorrect, got: %+v.", repeat)
+	}
+
+	for script, want := range map[string]string{
+		"":                        "no steps",
+		"login\nend\n":            "line 2: end without repeat",
+		"repeat 2\nlogin\n":       "repeat without end",
+		"call irc PING\n":         "unknown connection",
+		"think 2s 1s\n":           "below",
+		"call fesl acct nuid\n":   "expected key=value",
+		"dance\n":                 "unknown step",
+		"call theater LONGER\n":   "not 4 characters",
+		"repeat zero\nlogin\nend": "invalid repeat count",
+	} {
+		if _, err := loadtest.ParseScenario(strings.NewReader(script)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseScenario(%q) threw the wrong error: %v", script, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	fesl := startServer(t, func(message *GameSpy.CommandFESL) map[string]string {
+		switch message.Message["TXN"] {
+		case "Hello":
+			return map[string]string{"TXN": "Hello", "theaterIp": "127.0.0.1", "theaterPort": "18275"}
+		case "NuLogin":
+			return map[string]string{"TXN": "NuLogin", "lkey": "lkey-" + message.Message["nuid"]}
+		case "NuLoginPersona":
+			if message.Message["name"] == "player3" {
+				return map[string]string{"TXN": "NuLoginPersona", "errorCode": "101", "localizedMessage": "\"The user was not found\""}
+			}
+			return map[string]string{"TXN": "NuLoginPersona", "lkey": "lkey"}
+		}
+		return nil
+	})
+	pings := make(chan struct{}, 10)
+	theater := startServer(t, func(message *GameSpy.CommandFESL) map[string]string {
+		switch message.Query {
+		case "PING":
+			pings <- struct{}{}
+			return nil
+		case "USER":
+			return map[string]string{"TID": message.Message["TID"], "NAME": message.Message["NAME"]}
+		}
+		return map[string]string{"TID": message.Message["TID"]}
+	})
+
+	scenario, err := loadtest.ParseScenario(strings.NewReader("login\ntheater\nthink 1ms 2ms\ncall theater GLST LID=1\nsend theater PING\nlogout\n"))
+	if err != nil {
+		t.Fatalf("ParseScenario threw an error: %v", err)
+	}
+
+	config := loadtest.Config{
+		FESL:     "fesl",
+		Theater:  "theater",
+		Players:  5,
+		RampUp:   5 * time.Millisecond,
+		User:     "player%d@example.com",
+		Persona:  "player%d",
+		Scenario: scenario,
+	}
+	config.Dial = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if address == "theater" {
+			return theater.Dial(ctx)
+		}
+		return fesl.Dial(ctx)
+	}
+	stats := loadtest.Run(context.Background(), config)
+
+	counts := make(map[string][2]int)
+	for _, transaction := range stats.Transactions() {
+		counts[transaction.Name] = [2]int{transaction.Count, transaction.Errors}
+	}
+	for name, want := range map[string][2]int{
+		"fesl.fsys.Hello":          {5, 0},
+		"fesl.acct.NuLogin":        {5, 0},
+		"fesl.acct.NuLoginPersona": {5, 1},
+		"theater.CONN":             {4, 0},
+		"theater.USER":             {4, 0},
+		"theater.GLST":             {4, 0},
+		"theater.PING":             {4, 0},
+	} {
+		if counts[name] != want {
+			t.Errorf("%s was incorrect, got: %v, want: %v.", name, counts[name], want)
+		}
+	}
+
+	var report bytes.Buffer
+	stats.WriteReport(&report)
+	if !strings.Contains(report.String(), "5 players") || !strings.Contains(report.String(), "fesl.acct.NuLoginPersona: 1x acct NuLoginPersona failed with error 101: The user was not found") {
+		t.Errorf("Report was incorrect, got:\n%s", report.String())
+	}
+}
@@ -0,0 +1,207 @@
+// Package loadtest simulates players with the dial side of the GameSpy
+// package. Every player runs a scenario script against FESL and Theater
+// while the latency and the errors of every transaction are collected.
+package loadtest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations of a scenario step
+const (
+	// OpLogin connects to FESL and logs in with NuLogin and
+	// NuLoginPersona
+	OpLogin = "login"
+	// OpTheater connects to Theater and sends USER with the session key
+	// of the login
+	OpTheater = "theater"
+	// OpCall sends a request and waits for the answer
+	OpCall = "call"
+	// OpSend sends a message without waiting for an answer, e.g. a
+	// heartbeat
+	OpSend = "send"
+	// OpThink waits for a random time between Min and Max
+	OpThink = "think"
+	// OpRepeat runs Steps Count times
+	OpRepeat = "repeat"
+	// OpLogout closes the connections
+	OpLogout = "logout"
+)
+
+// Step is a single line of a scenario
+type Step struct {
+	Op string
+	// Conn is "fesl" or "theater", Type the message type and Fields the
+	// message of calls and sends
+	Conn   string
+	Type   string
+	Fields map[string]string
+	// Min and Max are the bounds of think times
+	Min time.Duration
+	Max time.Duration
+	// Count and Steps are the body of a repeat
+	Count int
+	Steps []Step
+}
+
+// Scenario is what every player does, one step after another
+type Scenario struct {
+	Steps []Step
+}
+
+// DefaultScenario is a player logging in, browsing the lobbies and
+// servers and staying around for a while with heartbeats
+const DefaultScenario = `# Log in and join Theater
+login
+theater
+think 1s 3s
+
+# Browse the lobbies and the servers of the first one
+call fesl acct TXN=NuGetPersonas namespace=
+call theater LLST FILTER-FAV-ONLY=0 FILTER-NOT-FULL=0 FILTER-NOT-PRIVATE=0 FILTER-NOT-CLOSED=0 FILTER-MIN-SIZE=0 FAV-PLAYER= FAV-GAME= FAV-PLAYER-UID= FAV-GAME-UID=
+call theater GLST LID=1 TYPE=G FILTER-FAV-ONLY=0 FILTER-NOT-FULL=0 FILTER-NOT-PRIVATE=0 FILTER-NOT-CLOSED=0 FILTER-MIN-SIZE=0 FAV-PLAYER= FAV-GAME= FAV-PLAYER-UID= FAV-GAME-UID= COUNT=-1
+
+# Stay around
+repeat 5
+	think 5s 10s
+	send theater PING
+	send fesl fsys TXN=Ping
+end
+
+logout
+`
+
+// ParseScenario reads a scenario script. Every line is a step, empty
+// lines and lines starting with # are skipped:
+//
+//	login
+//	theater
+//	call fesl acct TXN=NuGetPersonas namespace=
+//	call theater GLST LID=1 TYPE=G COUNT=-1
+//	send theater PING
+//	think 1s 3s
+//	repeat 5
+//		...
+//	end
+//	logout
+func ParseScenario(r io.Reader) (*Scenario, error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	// Every open repeat is on the stack, the scenario at the bottom
+	stack := []*Step{{}}
+	for scanner.Scan() {
+		line++
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+
+		if words[0] == "end" {
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("line %d: end without repeat", line)
+			}
+			repeat := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			parent := stack[len(stack)-1]
+			parent.Steps = append(parent.Steps, *repeat)
+			continue
+		}
+
+		step, err := parseStep(words)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if step.Op == OpRepeat {
+			stack = append(stack, &step)
+			continue
+		}
+		parent := stack[len(stack)-1]
+		parent.Steps = append(parent.Steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("line %d: repeat without end", line)
+	}
+	if len(stack[0].Steps) == 0 {
+		return nil, fmt.Errorf("scenario holds no steps")
+	}
+	return &Scenario{Steps: stack[0].Steps}, nil
+}
+
+func parseStep(words []string) (Step, error) {
+	step := Step{Op: words[0]}
+	args := words[1:]
+
+	switch step.Op {
+	case OpLogin, OpTheater, OpLogout:
+		if len(args) != 0 {
+			return step, fmt.Errorf("%s takes no arguments", step.Op)
+		}
+	case OpCall, OpSend:
+		if len(args) < 2 {
+			return step, fmt.Errorf("expected %s fesl|theater type [key=value...]", step.Op)
+		}
+		step.Conn, step.Type = args[0], args[1]
+		if step.Conn != "fesl" && step.Conn != "theater" {
+			return step, fmt.Errorf("unknown connection %q", step.Conn)
+		}
+		if len(step.Type) != 4 {
+			return step, fmt.Errorf("type %q is not 4 characters long", step.Type)
+		}
+		step.Fields = make(map[string]string)
+		for _, field := range args[2:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return step, fmt.Errorf("expected key=value, got %q", field)
+			}
+			step.Fields[key] = value
+		}
+	case OpThink:
+		if len(args) != 1 && len(args) != 2 {
+			return step, fmt.Errorf("expected think duration [max]")
+		}
+		var err error
+		if step.Min, err = time.ParseDuration(args[0]); err != nil {
+			return step, err
+		}
+		step.Max = step.Min
+		if len(args) == 2 {
+			if step.Max, err = time.ParseDuration(args[1]); err != nil {
+				return step, err
+			}
+		}
+		if step.Max < step.Min {
+			return step, fmt.Errorf("think time %v is below %v", step.Max, step.Min)
+		}
+	case OpRepeat:
+		if len(args) != 1 {
+			return step, fmt.Errorf("expected repeat count")
+		}
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 1 {
+			return step, fmt.Errorf("invalid repeat count %q", args[0])
+		}
+		step.Count = count
+	default:
+		return step, fmt.Errorf("unknown step %q", step.Op)
+	}
+	return step, nil
+}
+
+// name returns the transaction the step is counted under, e.g.
+// "fesl.acct.NuGetPersonas" or "theater.GLST"
+func (step Step) name() string {
+	name := step.Conn + "." + step.Type
+	if txn := step.Fields["TXN"]; txn != "" {
+		name += "." + txn
+	}
+	return name
+}
@@ -0,0 +1,185 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Stats collects the outcome of every transaction. It's safe for
+// concurrent use.
+type Stats struct {
+	lock         sync.Mutex
+	transactions map[string]*transaction
+	players      int
+	active       int
+	peak         int
+	start        time.Time
+	end          time.Time
+}
+
+type transaction struct {
+	latencies []time.Duration
+	errors    int
+	// messages counts the errors by their text
+	messages map[string]int
+}
+
+// Transaction is the summary of a single transaction
+type Transaction struct {
+	Name      string
+	Count     int
+	Errors    int
+	ErrorRate float64
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+	Max       time.Duration
+	// Messages counts the errors by their text
+	Messages map[string]int
+}
+
+// NewStats returns empty stats, started now
+func NewStats() *Stats {
+	return &Stats{
+		transactions: make(map[string]*transaction),
+		start:        time.Now(),
+	}
+}
+
+// Record adds a transaction which took latency. Failed ones are counted
+// as errors, their latency is left out of the percentiles.
+func (stats *Stats) Record(name string, latency time.Duration, err error) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	t, ok := stats.transactions[name]
+	if !ok {
+		t = &transaction{messages: make(map[string]int)}
+		stats.transactions[name] = t
+	}
+	if err != nil {
+		t.errors++
+		t.messages[err.Error()]++
+		return
+	}
+	t.latencies = append(t.latencies, latency)
+}
+
+// join counts a player coming online, leave one going offline
+func (stats *Stats) join() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.players++
+	stats.active++
+	if stats.active > stats.peak {
+		stats.peak = stats.active
+	}
+}
+
+func (stats *Stats) leave() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.active--
+}
+
+// stop marks the end of the run
+func (stats *Stats) stop() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.end = time.Now()
+}
+
+// Transactions returns the summary of every transaction, sorted by name
+func (stats *Stats) Transactions() []Transaction {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	summaries := make([]Transaction, 0, len(stats.transactions))
+	for name, t := range stats.transactions {
+		latencies := append([]time.Duration(nil), t.latencies...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		summary := Transaction{
+			Name:     name,
+			Count:    len(latencies) + t.errors,
+			Errors:   t.errors,
+			P50:      percentile(latencies, 0.5),
+			P90:      percentile(latencies, 0.9),
+			P99:      percentile(latencies, 0.99),
+			Messages: make(map[string]int, len(t.messages)),
+		}
+		if len(latencies) > 0 {
+			summary.Max = latencies[len(latencies)-1]
+		}
+		summary.ErrorRate = float64(summary.Errors) / float64(summary.Count)
+		for message, count := range t.messages {
+			summary.Messages[message] = count
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+// percentile returns the latency p of all are below, latencies being
+// sorted
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(latencies)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return latencies[i]
+}
+
+// WriteReport writes the summary as a table
+func (stats *Stats) WriteReport(w io.Writer) error {
+	stats.lock.Lock()
+	players, peak := stats.players, stats.peak
+	end := stats.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	elapsed := end.Sub(stats.start).Round(time.Millisecond)
+	stats.lock.Unlock()
+
+	fmt.Fprintf(w, "%d players, %d at once, %v\n\n", players, peak, elapsed)
+
+	transactions := stats.Transactions()
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "transaction\tcount\terrors\terror rate\tp50\tp90\tp99\tmax\t")
+	for _, t := range transactions {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%.2f%%\t%v\t%v\t%v\t%v\t\n", t.Name, t.Count, t.Errors, t.ErrorRate*100,
+			round(t.P50), round(t.P90), round(t.P99), round(t.Max))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	header := "\nerrors:\n"
+	for _, t := range transactions {
+		messages := make([]string, 0, len(t.Messages))
+		for message := range t.Messages {
+			messages = append(messages, message)
+		}
+		sort.Slice(messages, func(i, j int) bool { return t.Messages[messages[i]] > t.Messages[messages[j]] })
+
+		for _, message := range messages {
+			fmt.Fprintf(w, "%s  %s: %dx %s\n", header, t.Name, t.Messages[message], message)
+			header = ""
+		}
+	}
+	return nil
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
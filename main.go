@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
 	"flag"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"net/http"
 	_ "net/http/pprof"
@@ -39,11 +47,32 @@ func CheckAndGenerateHTTPSCertificate(certFile, keyFile string) {
 }
 
 func main() {
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
 	var (
 		logLevel     = flag.String("logLevel", "error", "LogLevel [error|warning|note|debug]")
 		certFileFlag = flag.String("cert", "cert.pem", "[HTTPS] Location of your certification file. Env: LOUIS_HTTPS_CERT")
 		keyFileFlag  = flag.String("key", "key.pem", "[HTTPS] Location of your private key file. Env: LOUIS_HTTPS_KEY")
+		shutdownFlag = flag.Duration("shutdownTimeout", 10*time.Second, "How long to wait for clients to disconnect when shutting down")
+		readyFlag    = flag.Duration("upgradeTimeout", 30*time.Second, "How long to wait for the upgraded process to get ready before killing it")
+		listenFlag   = flag.String("listen", "42127", "Port or listen address of the test socket, e.g. [::]:42127 or systemd:test")
+		adminFlag    = flag.String("admin", "127.0.0.1:6061", "Listen address of the admin interface, POST /upgrade starts a zero-downtime upgrade")
+		banListFlag  = flag.String("banList", "", "File with allowed and denied networks, reloaded on SIGHUP")
+		maxPerIPFlag = flag.Int("maxConnsPerIP", 0, "Maximum number of concurrent connections per IP, 0 for no limit")
+		rateFlag     = flag.Float64("acceptRate", 0, "Connections accepted per second per IP, 0 for no limit")
+		crashDirFlag = flag.String("crashDir", "crashes", "Directory for crash reports of clients which made the server panic")
+		recordFlag   = flag.String("recordDir", "", "Directory to record the traffic of every connection to, empty to disable")
+		proxiesFlag  = flag.String("trustedProxies", "", "Comma separated networks of load balancers sending the PROXY protocol, e.g. 10.0.0.0/8")
+		upstreamFlag = flag.String("upstream", "", "Address of a reference server to forward every client to, logging the traffic in both directions")
+		upTLSFlag    = flag.Bool("upstreamTLS", false, "Connect to the upstream server over TLS, without checking the certificate")
+		upLogFlag    = flag.String("upstreamLog", "-", "File to log the traffic to and from the upstream server to, - for stderr")
+		migrateFlag  = flag.Bool("migrate", true, "Apply pending schema migrations to the database at startup")
+		rewrites     = make(rewriteFlags)
+		database     = addDatabaseFlags(flag.CommandLine)
 	)
+	flag.Var(rewrites, "rewrite", "Key to rewrite in messages from upstream as key=value, e.g. theaterIp=127.0.0.1. Repeatable.")
 	flag.Parse()
 
 	go func() {
@@ -63,34 +92,204 @@ func main() {
 
 	CheckAndGenerateHTTPSCertificate(*certFileFlag, *keyFileFlag)
 
+	db, err := database.open()
+	if err != nil {
+		log.Fatalln("Error: Couldn't connect to the database.", err)
+	}
+	if db != nil {
+		// The pool and the circuit breaker show up in /debug/vars
+		expvar.Publish("db", expvar.Func(func() interface{} { return db.Stats() }))
+	}
+	if db != nil && *migrateFlag {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		migrations, err := db.Migrate(ctx)
+		cancel()
+		for _, migration := range migrations {
+			log.Notef("Applied migration %d %s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalln("Error: Couldn't migrate the database.", err)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	upgrades := make(chan struct{}, 1)
+	if len(upgradeSignals) > 0 {
+		upgradeSignalsChannel := make(chan os.Signal, 1)
+		signal.Notify(upgradeSignalsChannel, upgradeSignals...)
+		go func() {
+			for range upgradeSignalsChannel {
+				requestUpgrade(upgrades)
+			}
+		}()
+	}
+	// Taken over from the old process on upgrades, like the game
+	// listeners
+	adminListener, err := gs.Listen("tcp", gs.InheritedOr("admin", *adminFlag))
+	if err != nil {
+		log.Fatalln("Error: Couldn't listen on the admin address.", err)
+	}
+	go serveAdmin(adminListener, upgrades)
+
+	trustedProxies, err := gs.ParseCIDRs(strings.Split(*proxiesFlag, ","))
+	if err != nil {
+		log.Fatalln("Error: Couldn't parse the trusted proxies.", err)
+	}
+
+	admission := &gs.Admission{
+		Config: gs.AdmissionConfig{
+			MaxPerIP: *maxPerIPFlag,
+			IPRate:   *rateFlag,
+			IPBurst:  int(*rateFlag) + 1,
+		},
+	}
+	if *banListFlag != "" {
+		admission.Source = gs.FileListSource{Path: *banListFlag}
+		if err := admission.Reload(); err != nil {
+			log.Fatalln("Error: Couldn't load the ban list.", err)
+		}
+	}
+
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+
 	test3 := new(gs.Socket)
-	eventsChannel, err := test3.New("Testing", "42127", false)
+	test3.Proxy.Trusted = trustedProxies
+	test3.Admission = admission
+	test3.Crashes.Dir = *crashDirFlag
+	test3.Record.Dir = *recordFlag
+	test3.Upstream.Address = *upstreamFlag
+	// The traffic is the point of proxying, it's not subject to -logLevel
+	test3.Upstream.Log = os.Stderr
+	if *upstreamFlag != "" && *upLogFlag != "-" {
+		upstreamLog, err := os.OpenFile(*upLogFlag, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatalln("Error: Couldn't open the upstream log.", err)
+		}
+		defer upstreamLog.Close()
+		test3.Upstream.Log = upstreamLog
+	}
+	if *upTLSFlag {
+		test3.Upstream.TLS = &tls.Config{InsecureSkipVerify: true}
+	}
+	if len(rewrites) > 0 {
+		test3.Upstream.Rewrite = []gs.Rewrite{gs.RewriteKeys(gs.Outbound, rewrites)}
+	}
+	eventsChannel, err := test3.New("Testing", gs.InheritedOr("test", *listenFlag), false)
 	if err != nil {
 		log.Errorln(err)
 	}
 
+	// The old process drains its clients once we're serving
+	if err := gs.Ready(); err != nil {
+		log.Errorln("Couldn't tell the old process we're ready.", err)
+	}
+
 	for {
 		select {
 		case event := <-eventsChannel:
-			switch {
-			case event.Name == "newClient":
-				log.Debugln(event)
-			case event.Name == "error":
-				log.Debugln(event)
-			case event.Name == "close":
+			switch event.Kind() {
+			case gs.KindClose:
 				log.Debugln(event)
 				os.Exit(0)
 			default:
 				log.Debugln(event)
 			}
+		case sig := <-signals:
+			log.Noteln("Captured " + sig.String() + ". Shutting down.")
+			shutdown(*shutdownFlag, eventsChannel, test3.Shutdown)
+			os.Exit(0)
+		case <-reloads:
+			if err := admission.Reload(); err != nil {
+				log.Errorln("Error: Couldn't reload the ban list. Keeping the previous one.", err)
+			}
+		case <-upgrades:
+			log.Noteln("Upgrading. Handing the listeners over to a new process.")
+			ctx, cancel := context.WithTimeout(context.Background(), *readyFlag)
+			_, err := gs.Upgrade(ctx, map[string]gs.Filer{
+				"test":  &test3.Server,
+				"admin": adminListener.(gs.Filer),
+			})
+			cancel()
+			if err != nil {
+				log.Errorln("Upgrade failed, keeping on running.", err)
+				continue
+			}
+			shutdown(*shutdownFlag, eventsChannel, test3.Drain)
+			os.Exit(0)
 		}
 	}
+}
+
+// serveAdmin runs the admin interface. It's meant for localhost only.
+func serveAdmin(listener net.Listener, upgrades chan struct{}) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !requestUpgrade(upgrades) {
+			http.Error(w, "Upgrade already in progress", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	log.Errorln("The admin interface stopped.", http.Serve(listener, mux))
+}
+
+// requestUpgrade queues an upgrade unless there is one already
+func requestUpgrade(upgrades chan struct{}) bool {
+	select {
+	case upgrades <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	for sig := range c {
-		log.Noteln("Captured" + sig.String() + ". Shutting down.")
-		os.Exit(0)
+// shutdown runs the given shutdown functions while draining events, so
+// client goroutines never block on a full event channel
+func shutdown(timeout time.Duration, eventsChannel chan gs.SocketEvent, shutdownFuncs ...func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for _, shutdownFunc := range shutdownFuncs {
+			if err := shutdownFunc(ctx); err != nil {
+				log.Errorln("Shutdown did not finish cleanly:", err)
+			}
+		}
+		close(done)
+	}()
+
+	for {
+		select {
+		case event := <-eventsChannel:
+			log.Debugln(event)
+		case <-done:
+			return
+		}
 	}
+}
+
+// rewriteFlags collects -rewrite flags like theaterIp=127.0.0.1
+type rewriteFlags map[string]string
 
+func (rewrites rewriteFlags) String() string {
+	return ""
+}
+
+func (rewrites rewriteFlags) Set(value string) error {
+	key, value, ok := strings.Cut(value, "=")
+	if !ok {
+		return errors.New("expected key=value, e.g. theaterIp=127.0.0.1")
+	}
+	rewrites[key] = value
+	return nil
 }
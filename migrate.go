@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/core"
+)
+
+// databaseFlags are the flags of the database, shared by the server and
+// the migrate command
+type databaseFlags struct {
+	sqlite   *string
+	server   *string
+	name     *string
+	user     *string
+	password *string
+	maxOpen  *int
+	timeout  *time.Duration
+}
+
+func addDatabaseFlags(flags *flag.FlagSet) databaseFlags {
+	return databaseFlags{
+		sqlite:   flags.String("sqlite", "", "SQLite database file to use instead of MySQL, e.g. goawaken.db"),
+		server:   flags.String("mysqlServer", "", "Address of the MySQL server, e.g. 127.0.0.1:3306, empty to run without a database"),
+		name:     flags.String("mysqlDB", "goawaken", "Name of the MySQL database"),
+		user:     flags.String("mysqlUser", "goawaken", "MySQL user"),
+		password: flags.String("mysqlPw", "", "Password of the MySQL user"),
+		maxOpen:  flags.Int("dbMaxOpenConns", core.DefaultDBOptions.MaxOpenConns, "Maximum number of open MySQL connections"),
+		timeout:  flags.Duration("dbQueryTimeout", core.DefaultDBOptions.QueryTimeout, "Deadline of a single database query"),
+	}
+}
+
+// open connects to the database, or returns nil if none is configured
+func (database databaseFlags) open() (*core.DB, error) {
+	db := new(core.DB)
+	db.SetOptions(core.DBOptions{MaxOpenConns: *database.maxOpen, QueryTimeout: *database.timeout})
+	switch {
+	case *database.sqlite != "" && *database.server != "":
+		return nil, errors.New("expected either -sqlite or -mysqlServer, not both")
+	case *database.sqlite != "":
+		if _, err := db.NewSQLite(*database.sqlite); err != nil {
+			return nil, err
+		}
+	case *database.server != "":
+		if _, err := db.New(*database.server, *database.name, *database.user, *database.password); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	return db, nil
+}
+
+// runMigrate applies the pending schema migrations or shows which are
+// applied
+func runMigrate(flags *flag.FlagSet, args []string) error {
+	var (
+		database    = addDatabaseFlags(flags)
+		statusFlag  = flags.Bool("status", false, "Show the migrations and when they were applied instead of applying them")
+		timeoutFlag = flags.Duration("timeout", 5*time.Minute, "Timeout of all migrations together")
+	)
+	flags.Parse(args)
+
+	db, err := database.open()
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return errors.New("-sqlite or -mysqlServer is required")
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+
+	if *statusFlag {
+		applied, err := db.AppliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, migration := range core.Migrations {
+			status := "pending"
+			if appliedAt, ok := applied[migration.Version]; ok {
+				status = appliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", migration.Version, migration.Name, status)
+		}
+		return w.Flush()
+	}
+
+	done, err := db.Migrate(ctx)
+	for _, migration := range done {
+		fmt.Printf("Applied %d %s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Println("The schema is up to date.")
+	}
+	return nil
+}
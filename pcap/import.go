@@ -0,0 +1,373 @@
+package pcap
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+// Port is a known server port
+type Port struct {
+	// Name is the service, e.g. "theater"
+	Name string
+	// Protocol names the framer, see GameSpy.FramerFor
+	Protocol string
+}
+
+// DefaultPorts are the ports of the original GameSpy and EA services
+var DefaultPorts = map[uint16]Port{
+	29900: {Name: "gpcm", Protocol: "gamespy"},
+	29901: {Name: "gpsp", Protocol: "gamespy"},
+	27900: {Name: "qr2", Protocol: "qr2"},
+	18270: {Name: "fesl", Protocol: "fesl"},
+	18275: {Name: "theater", Protocol: "fesl"},
+}
+
+// Config configures an import
+type Config struct {
+	// Ports maps server ports to their services. nil means DefaultPorts.
+	Ports map[uint16]Port
+}
+
+// Note is a remark on a flow, e.g. about lost data
+type Note struct {
+	Time time.Time
+	Text string
+}
+
+// Flow is a single TCP connection or the UDP traffic between a client
+// and a server port
+type Flow struct {
+	Port     Port
+	Client   netip.AddrPort
+	Server   netip.AddrPort
+	Datagram bool
+	// Encrypted is set for TLS streams, their frames can't be decoded
+	Encrypted bool
+	// Capture holds the frames in the format of the replay harness
+	Capture *GameSpy.Capture
+	Notes   []Note
+
+	framer GameSpy.Framer
+	dirs   [2]*stream
+	closed bool
+}
+
+// stream is one direction of a TCP connection
+type stream struct {
+	direction GameSpy.Direction
+	started   bool
+	next      uint32
+	pending   map[uint32][]byte
+	buffered  int
+	buffer    []byte
+	broken    bool
+}
+
+// Result is the outcome of an import
+type Result struct {
+	Flows []*Flow
+	// Packets is the number of packets read, Ignored the number of those
+	// which were not part of a flow on a known port
+	Packets int
+	Ignored int
+}
+
+// maxPending caps the out of order data kept per direction. Once it's
+// reached, the data in between is considered lost.
+const maxPending = 1 << 20
+
+type flowKey struct {
+	client netip.AddrPort
+	server netip.AddrPort
+	proto  int
+}
+
+// Import reads a libpcap or pcapng file and reassembles the flows on
+// known ports
+func Import(r io.Reader, config Config) (*Result, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := config.Ports
+	if ports == nil {
+		ports = DefaultPorts
+	}
+
+	result := new(Result)
+	flows := make(map[flowKey]*Flow)
+
+	for {
+		packet, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Packets++
+
+		s, err := decodePacket(packet.LinkType, packet.Data)
+		if err != nil {
+			result.Ignored++
+			continue
+		}
+
+		// Whoever uses the known port is the server
+		key := flowKey{client: s.src, server: s.dst, proto: s.proto}
+		direction := GameSpy.Inbound
+		port, ok := ports[s.dst.Port()]
+		if !ok {
+			if port, ok = ports[s.src.Port()]; !ok {
+				result.Ignored++
+				continue
+			}
+			key.client, key.server = s.dst, s.src
+			direction = GameSpy.Outbound
+		}
+
+		flow := flows[key]
+		// A new connection on the same ports starts a new flow
+		if flow != nil && s.proto == protoTCP && s.flags&(flagSYN|flagACK) == flagSYN && (flow.closed || len(flow.Capture.Records) > 0) {
+			flow = nil
+		}
+		if flow == nil {
+			flow, err = newFlow(port, key, packet.Time, uint64(len(result.Flows)+1))
+			if err != nil {
+				return result, err
+			}
+			flows[key] = flow
+			result.Flows = append(result.Flows, flow)
+		}
+
+		if s.proto == protoUDP {
+			flow.datagram(packet.Time, direction, s.payload)
+		} else {
+			flow.segment(packet.Time, direction, s, packet.Truncated)
+		}
+	}
+
+	for _, flow := range result.Flows {
+		flow.finish()
+	}
+	return result, nil
+}
+
+func newFlow(port Port, key flowKey, start time.Time, id uint64) (*Flow, error) {
+	framer, err := GameSpy.FramerFor(port.Protocol)
+	if err != nil {
+		return nil, fmt.Errorf("port %d: %w", key.server.Port(), err)
+	}
+
+	flow := &Flow{
+		Port:     port,
+		Client:   key.client,
+		Server:   key.server,
+		Datagram: key.proto == protoUDP,
+		framer:   framer,
+		dirs: [2]*stream{
+			{direction: GameSpy.Inbound, pending: make(map[uint32][]byte)},
+			{direction: GameSpy.Outbound, pending: make(map[uint32][]byte)},
+		},
+	}
+	flow.Capture = &GameSpy.Capture{
+		CaptureHeader: GameSpy.CaptureHeader{
+			Version:  GameSpy.CaptureVersion,
+			Protocol: port.Protocol,
+			Server:   port.Name,
+			ClientID: id,
+			Addr:     key.client.String(),
+			Start:    start,
+			Datagram: flow.Datagram,
+		},
+	}
+	return flow, nil
+}
+
+func (flow *Flow) note(t time.Time, format string, args ...interface{}) {
+	flow.Notes = append(flow.Notes, Note{Time: t, Text: fmt.Sprintf(format, args...)})
+}
+
+func (flow *Flow) stream(direction GameSpy.Direction) *stream {
+	if direction == GameSpy.Inbound {
+		return flow.dirs[0]
+	}
+	return flow.dirs[1]
+}
+
+// datagram records a single UDP datagram. The XOR is taken off where
+// there is one, plain-text GameSpy and binary QR2 are taken as they are.
+func (flow *Flow) datagram(t time.Time, direction GameSpy.Direction, payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+
+	frame := payload
+	if xor, ok := flow.framer.(GameSpy.XORFramer); ok && payload[0] != '\\' {
+		_, frame, _ = xor.Split(payload)
+	}
+	flow.record(t, direction, frame)
+}
+
+// segment puts a TCP segment in place and records the frames it
+// completes
+func (flow *Flow) segment(t time.Time, direction GameSpy.Direction, s segment, truncated bool) {
+	dir := flow.stream(direction)
+
+	if s.flags&flagSYN != 0 {
+		dir.started = true
+		dir.next = s.seq + 1
+		return
+	}
+	if s.flags&(flagFIN|flagRST) != 0 {
+		flow.closed = true
+	}
+	if len(s.payload) == 0 || dir.broken {
+		return
+	}
+	if truncated {
+		flow.note(t, "%s: packet cut short by the capture, can't follow this direction any further", dir.direction)
+		dir.broken = true
+		return
+	}
+
+	// Captures started mid-connection begin wherever we are
+	if !dir.started {
+		dir.started = true
+		dir.next = s.seq
+	}
+
+	seq, payload := s.seq, s.payload
+	if diff := int32(seq - dir.next); diff < 0 {
+		// Retransmitted, maybe partly new
+		if int(-diff) >= len(payload) {
+			return
+		}
+		payload = payload[-diff:]
+		seq = dir.next
+	} else if diff > 0 {
+		if _, ok := dir.pending[seq]; !ok {
+			dir.pending[seq] = append([]byte(nil), payload...)
+			dir.buffered += len(payload)
+		}
+		if dir.buffered > maxPending {
+			flow.skip(t, dir)
+		}
+		return
+	}
+
+	flow.deliver(t, dir, payload)
+	dir.next = seq + uint32(len(payload))
+	flow.drain(t, dir)
+}
+
+// drain delivers the pending segments which follow on what was
+// delivered so far
+func (flow *Flow) drain(t time.Time, dir *stream) {
+	for !dir.broken && len(dir.pending) > 0 {
+		progress := false
+		for seq, payload := range dir.pending {
+			diff := int32(seq - dir.next)
+			if diff > 0 {
+				continue
+			}
+			delete(dir.pending, seq)
+			dir.buffered -= len(payload)
+			progress = true
+
+			if int(-diff) < len(payload) {
+				flow.deliver(t, dir, payload[-diff:])
+				dir.next = seq + uint32(len(payload))
+			}
+		}
+		if !progress {
+			return
+		}
+	}
+}
+
+// skip gives up on a direction once the data it misses is unlikely to
+// show up. Frames can't be found across the gap.
+func (flow *Flow) skip(t time.Time, dir *stream) {
+	seqs := make([]uint32, 0, len(dir.pending))
+	for seq := range dir.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return int32(seqs[i]-dir.next) < int32(seqs[j]-dir.next)
+	})
+
+	flow.note(t, "%s: %d bytes missing from the capture, can't follow this direction any further", dir.direction, seqs[0]-dir.next)
+	dir.broken = true
+	dir.pending = nil
+	dir.buffered = 0
+}
+
+// deliver hands data in order to the framer of the flow
+func (flow *Flow) deliver(t time.Time, dir *stream, data []byte) {
+	if flow.Encrypted || dir.broken {
+		return
+	}
+
+	if len(flow.Capture.Records) == 0 && len(dir.buffer) == 0 && looksLikeTLS(data) {
+		flow.Encrypted = true
+		flow.note(t, "TLS, the traffic can't be decoded without the keys")
+		return
+	}
+
+	dir.buffer = append(dir.buffer, data...)
+	for len(dir.buffer) > 0 {
+		advance, frame, err := flow.framer.Split(dir.buffer)
+		if err != nil {
+			flow.note(t, "%s: unreadable data, can't follow this direction any further: %v", dir.direction, err)
+			dir.broken = true
+			dir.buffer = nil
+			return
+		}
+		if advance == 0 {
+			return
+		}
+		flow.record(t, dir.direction, frame)
+		dir.buffer = dir.buffer[advance:]
+	}
+}
+
+func (flow *Flow) record(t time.Time, direction GameSpy.Direction, frame []byte) {
+	flow.Capture.Records = append(flow.Capture.Records, GameSpy.CaptureRecord{
+		Time:      t,
+		Direction: direction,
+		Frame:     append([]byte(nil), frame...),
+	})
+}
+
+// finish notes whatever is left over once the capture is over
+func (flow *Flow) finish() {
+	end := flow.Capture.Start
+	if records := flow.Capture.Records; len(records) > 0 {
+		end = records[len(records)-1].Time
+	}
+
+	for _, dir := range flow.dirs {
+		if dir.buffered > 0 {
+			flow.note(end, "%s: %d bytes after a gap in the capture", dir.direction, dir.buffered)
+		}
+		if len(dir.buffer) > 0 && !flow.Encrypted {
+			flow.note(end, "%s: %d bytes of an incomplete frame at the end", dir.direction, len(dir.buffer))
+		}
+	}
+}
+
+// looksLikeTLS reports whether data starts a TLS or SSLv3 handshake, or
+// an SSLv2 client hello as the old EA clients send it
+func looksLikeTLS(data []byte) bool {
+	if len(data) >= 3 && data[0] == 0x16 && data[1] == 0x03 {
+		return true
+	}
+	return len(data) >= 3 && data[0]&0x80 != 0 && data[2] == 0x01
+}
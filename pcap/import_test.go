@@ -0,0 +1,256 @@
+package pcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+	"github.com/HeroesAwaken/GoAwaken/pcap"
+)
+
+var (
+	client   = netip.MustParseAddrPort("192.0.2.10:50000")
+	theater  = netip.MustParseAddrPort("203.0.113.5:18275")
+	fesl     = netip.MustParseAddrPort("203.0.113.5:18270")
+	qr2      = netip.MustParseAddrPort("203.0.113.5:27900")
+	elsewise = netip.MustParseAddrPort("203.0.113.5:53")
+)
+
+type testPacket struct {
+	time time.Time
+	data []byte
+}
+
+// ethernet wraps an IPv4 TCP or UDP segment into an ethernet frame
+func ethernet(src, dst netip.AddrPort, udp bool, seq uint32, flags byte, payload []byte) []byte {
+	var l4 []byte
+	proto := byte(6)
+	if udp {
+		proto = 17
+		l4 = make([]byte, 8)
+		binary.BigEndian.PutUint16(l4[4:6], uint16(8+len(payload)))
+	} else {
+		l4 = make([]byte, 20)
+		binary.BigEndian.PutUint32(l4[4:8], seq)
+		l4[12] = 5 << 4
+		l4[13] = flags
+	}
+	binary.BigEndian.PutUint16(l4[0:2], src.Port())
+	binary.BigEndian.PutUint16(l4[2:4], dst.Port())
+	l4 = append(l4, payload...)
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(l4)))
+	ip[8] = 64
+	ip[9] = proto
+	copy(ip[12:16], src.Addr().AsSlice())
+	copy(ip[16:20], dst.Addr().AsSlice())
+
+	frame := make([]byte, 14)
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+	return append(append(frame, ip...), l4...)
+}
+
+// session is a capture of a theater login with a mess of TCP on top, a
+// TLS connection and some QR2 datagrams
+func session() []testPacket {
+	start := time.Unix(1700000000, 0)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	hello := GameSpy.EncodeFESL("CONN", map[string]string{"TXN": "CONN", "PROT": "2", "PLAT": "PC"}, 0x40000001)
+	answer := GameSpy.EncodeFESL("CONN", map[string]string{"TXN": "CONN", "TIME": "1700000000"}, 0x00000001)
+	heartbeat := append([]byte{0x03, 0x12, 0x34, 0x56, 0x78}, "gamename\x00heroes\x00\x00\x00\x00"...)
+	challenge := append([]byte{0xFE, 0xFD, 0x01, 0x12, 0x34, 0x56, 0x78}, "Dw8Ch2\x00"...)
+
+	return []testPacket{
+		{at(0), ethernet(client, theater, false, 999, 0x02, nil)},
+		{at(1), ethernet(theater, client, false, 4999, 0x12, nil)},
+		// The second half overtakes the first, which is sent twice
+		{at(2), ethernet(client, theater, false, 1010, 0x18, hello[10:])},
+		{at(3), ethernet(client, theater, false, 1000, 0x18, hello[:10])},
+		{at(4), ethernet(client, theater, false, 1000, 0x18, hello[:10])},
+		{at(5), ethernet(theater, client, false, 5000, 0x18, answer)},
+		{at(6), ethernet(client, theater, false, 1000+uint32(len(hello)), 0x11, nil)},
+
+		{at(10), ethernet(client, fesl, false, 1, 0x02, nil)},
+		{at(11), ethernet(client, fesl, false, 2, 0x18, []byte{0x16, 0x03, 0x00, 0x00, 0x2f, 0x01})},
+
+		{at(20), ethernet(client, qr2, true, 0, 0, heartbeat)},
+		{at(21), ethernet(qr2, client, true, 0, 0, challenge)},
+		{at(22), ethernet(client, elsewise, true, 0, 0, []byte("dns"))},
+	}
+}
+
+func writePcap(packets []testPacket) []byte {
+	var buf bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xA1B2C3D4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], pcap.LinkEthernet)
+	buf.Write(header)
+
+	for _, packet := range packets {
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(packet.time.Unix()))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(packet.time.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(packet.data)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(packet.data)))
+		buf.Write(record)
+		buf.Write(packet.data)
+	}
+	return buf.Bytes()
+}
+
+func writePcapNG(packets []testPacket) []byte {
+	var buf bytes.Buffer
+	block := func(blockType uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(12+len(body)))
+		typ := make([]byte, 4)
+		binary.BigEndian.PutUint32(typ, blockType)
+		buf.Write(typ)
+		buf.Write(length)
+		buf.Write(body)
+		buf.Write(length)
+	}
+
+	block(0x0A0D0D0A, []byte{0x1A, 0x2B, 0x3C, 0x4D, 0, 1, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	// Ethernet with nanosecond timestamps
+	block(1, []byte{0, 1, 0, 0, 0, 0, 0xFF, 0xFF, 0, 9, 0, 1, 9, 0, 0, 0, 0, 0, 0, 0})
+
+	for _, packet := range packets {
+		body := make([]byte, 20)
+		nanos := uint64(packet.time.UnixNano())
+		binary.BigEndian.PutUint32(body[4:8], uint32(nanos>>32))
+		binary.BigEndian.PutUint32(body[8:12], uint32(nanos))
+		binary.BigEndian.PutUint32(body[12:16], uint32(len(packet.data)))
+		binary.BigEndian.PutUint32(body[16:20], uint32(len(packet.data)))
+		block(6, append(body, packet.data...))
+	}
+	return buf.Bytes()
+}
+
+func TestImport(t *testing.T) {
+	result, err := pcap.Import(bytes.NewReader(writePcap(session())), pcap.Config{})
+	if err != nil {
+		t.Fatalf("Import threw an error: %v", err)
+	}
+	if result.Packets != 12 || result.Ignored != 1 || len(result.Flows) != 3 {
+		t.Fatalf("Import was incorrect, got: %d packets, %d ignored, %d flows.", result.Packets, result.Ignored, len(result.Flows))
+	}
+
+	flow := result.Flows[0]
+	if flow.Port.Name != "theater" || flow.Client != client || flow.Server != theater || flow.Datagram || flow.Encrypted {
+		t.Errorf("Theater flow was incorrect, got: %+v.", flow)
+	}
+	records := flow.Capture.Records
+	if len(records) != 2 || records[0].Direction != GameSpy.Inbound || records[1].Direction != GameSpy.Outbound {
+		t.Fatalf("Theater frames were incorrect, got: %+v.", records)
+	}
+	message, err := GameSpy.FESLFramer{}.Decode(records[0].Frame)
+	if err != nil || message.(*GameSpy.CommandFESL).Message["PLAT"] != "PC" {
+		t.Errorf("Reassembled frame was incorrect, got: %q.", records[0].Frame)
+	}
+	if !records[0].Time.Equal(time.Unix(1700000000, 3000000)) {
+		t.Errorf("Frame time was incorrect, got: %v.", records[0].Time)
+	}
+
+	if tls := result.Flows[1]; !tls.Encrypted || len(tls.Capture.Records) != 0 || len(tls.Notes) != 1 {
+		t.Errorf("TLS flow was incorrect, got: %+v.", tls)
+	}
+
+	udp := result.Flows[2]
+	if !udp.Datagram || udp.Capture.Protocol != "qr2" || len(udp.Capture.Records) != 2 {
+		t.Fatalf("QR2 flow was incorrect, got: %+v.", udp)
+	}
+	if record := udp.Capture.Records[1]; record.Direction != GameSpy.Outbound || record.Frame[0] != 0xFE {
+		t.Errorf("QR2 challenge was incorrect, got: %+v.", record)
+	}
+	message, err = GameSpy.QR2Framer{}.Decode(udp.Capture.Records[0].Frame)
+	if err != nil || message.(*GameSpy.Command).Query != "heartbeat" || message.(*GameSpy.Command).Message["gamename"] != "heroes" {
+		t.Errorf("QR2 heartbeat was incorrect, got: %+v, %v.", message, err)
+	}
+}
+
+func TestImportPcapNG(t *testing.T) {
+	packets := session()
+	classic, err := pcap.Import(bytes.NewReader(writePcap(packets)), pcap.Config{})
+	if err != nil {
+		t.Fatalf("Import threw an error: %v", err)
+	}
+	ng, err := pcap.Import(bytes.NewReader(writePcapNG(packets)), pcap.Config{})
+	if err != nil {
+		t.Fatalf("Importing pcapng threw an error: %v", err)
+	}
+
+	if len(ng.Flows) != len(classic.Flows) {
+		t.Fatalf("pcapng held %d flows instead of %d.", len(ng.Flows), len(classic.Flows))
+	}
+	for i := range ng.Flows {
+		if !reflect.DeepEqual(ng.Flows[i].Capture, classic.Flows[i].Capture) {
+			t.Errorf("Flow %d was incorrect, got: %+v, want: %+v.", i, ng.Flows[i].Capture, classic.Flows[i].Capture)
+		}
+	}
+
+	if _, err := pcap.Import(strings.NewReader("\\lc\\1\\final\\"), pcap.Config{}); err != pcap.ErrInvalidFile {
+		t.Errorf("Importing something else threw the wrong error: %v", err)
+	}
+}
+
+func TestImportGap(t *testing.T) {
+	packets := session()
+	// Lose the first half of the hello for good
+	packets = append(packets[:3], packets[5:]...)
+
+	result, err := pcap.Import(bytes.NewReader(writePcap(packets)), pcap.Config{})
+	if err != nil {
+		t.Fatalf("Import threw an error: %v", err)
+	}
+
+	flow := result.Flows[0]
+	if len(flow.Capture.Records) != 1 || flow.Capture.Records[0].Direction != GameSpy.Outbound {
+		t.Errorf("Frames were incorrect, got: %+v.", flow.Capture.Records)
+	}
+	if len(flow.Notes) != 1 || !strings.Contains(flow.Notes[0].Text, "after a gap") {
+		t.Errorf("Notes were incorrect, got: %+v.", flow.Notes)
+	}
+}
+
+func TestWriteTranscript(t *testing.T) {
+	result, err := pcap.Import(bytes.NewReader(writePcap(session())), pcap.Config{})
+	if err != nil {
+		t.Fatalf("Import threw an error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	for _, flow := range result.Flows {
+		pcap.WriteTranscript(&buf, flow)
+	}
+	transcript := buf.String()
+
+	for _, want := range []string{
+		"# theater (fesl/tcp) 192.0.2.10:50000 -> 203.0.113.5:18275, 2023-11-14T22:13:20Z\n",
+		"+0.003s  client  CONN 0x40000001\n                 TXN=CONN\n                 PLAT=PC\n",
+		"+0.005s  server  CONN 0x00000001\n",
+		"note    TLS, the traffic can't be decoded without the keys\n",
+		"+0.000s  client  heartbeat\n                 data=0000\n                 from=client\n                 gamename=heroes\n                 key=0x12345678\n",
+		"+0.001s  server  challenge\n                 from=server\n                 key=0x12345678\n                 value=Dw8Ch2\n",
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("Transcript is missing %q, got:\n%s", want, transcript)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// Transport protocols of a segment
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// TCP flags
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagRST = 0x04
+	flagACK = 0x10
+)
+
+// errSkip marks packets which are fine but hold nothing for us, e.g.
+// ARP or ICMP
+var errSkip = errors.New("not TCP or UDP")
+
+// segment is the TCP or UDP part of a packet
+type segment struct {
+	proto   int
+	src     netip.AddrPort
+	dst     netip.AddrPort
+	seq     uint32
+	flags   byte
+	payload []byte
+}
+
+// decodePacket digs the TCP or UDP segment out of a packet
+func decodePacket(linkType uint32, data []byte) (segment, error) {
+	network, err := decodeLink(linkType, data)
+	if err != nil {
+		return segment{}, err
+	}
+	return decodeIP(network)
+}
+
+// decodeLink returns the network layer of a frame
+func decodeLink(linkType uint32, data []byte) ([]byte, error) {
+	switch linkType {
+	case LinkEthernet:
+		if len(data) < 14 {
+			return nil, errors.New("short ethernet header")
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		// VLAN tags
+		for etherType == 0x8100 || etherType == 0x88A8 {
+			if len(data) < 4 {
+				return nil, errors.New("short VLAN tag")
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		if etherType != 0x0800 && etherType != 0x86DD {
+			return nil, errSkip
+		}
+		return data, nil
+	case LinkNull, LinkLoop:
+		// The address family is in host byte order, the IP version
+		// tells just as well
+		if len(data) < 4 {
+			return nil, errors.New("short loopback header")
+		}
+		return data[4:], nil
+	case LinkRaw, LinkIPv4, LinkIPv6:
+		return data, nil
+	case LinkLinuxSLL:
+		if len(data) < 16 {
+			return nil, errors.New("short SLL header")
+		}
+		return data[16:], nil
+	case LinkLinuxSLL2:
+		if len(data) < 20 {
+			return nil, errors.New("short SLL2 header")
+		}
+		return data[20:], nil
+	}
+	return nil, fmt.Errorf("unsupported link type %d", linkType)
+}
+
+// decodeIP returns the segment of an IPv4 or IPv6 packet
+func decodeIP(data []byte) (segment, error) {
+	if len(data) < 1 {
+		return segment{}, errors.New("empty IP packet")
+	}
+
+	var s segment
+	var proto int
+	var payload []byte
+
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return segment{}, errors.New("short IPv4 header")
+		}
+		headerLength := int(data[0]&0x0F) * 4
+		total := int(binary.BigEndian.Uint16(data[2:4]))
+		if headerLength < 20 || len(data) < headerLength {
+			return segment{}, errors.New("bad IPv4 header length")
+		}
+		if flags := binary.BigEndian.Uint16(data[6:8]); flags&0x3FFF != 0 {
+			// More fragments or an offset, we don't put them together
+			return segment{}, errors.New("fragmented IPv4 packet")
+		}
+		// Ethernet pads short frames, the total length is what counts
+		if total >= headerLength && total < len(data) {
+			data = data[:total]
+		}
+
+		proto = int(data[9])
+		src, _ := netip.AddrFromSlice(data[12:16])
+		dst, _ := netip.AddrFromSlice(data[16:20])
+		s.src, s.dst = netip.AddrPortFrom(src, 0), netip.AddrPortFrom(dst, 0)
+		payload = data[headerLength:]
+	case 6:
+		if len(data) < 40 {
+			return segment{}, errors.New("short IPv6 header")
+		}
+		length := int(binary.BigEndian.Uint16(data[4:6]))
+		proto = int(data[6])
+		src, _ := netip.AddrFromSlice(data[8:24])
+		dst, _ := netip.AddrFromSlice(data[24:40])
+		s.src, s.dst = netip.AddrPortFrom(src, 0), netip.AddrPortFrom(dst, 0)
+		payload = data[40:]
+		if length < len(payload) {
+			payload = payload[:length]
+		}
+
+		// Skip the extension headers we may meet in front of TCP or UDP
+		for proto == 0 || proto == 43 || proto == 60 {
+			if len(payload) < 8 {
+				return segment{}, errors.New("short IPv6 extension header")
+			}
+			next := int(payload[0])
+			extLength := (int(payload[1]) + 1) * 8
+			if len(payload) < extLength {
+				return segment{}, errors.New("short IPv6 extension header")
+			}
+			proto, payload = next, payload[extLength:]
+		}
+		if proto == 44 {
+			return segment{}, errors.New("fragmented IPv6 packet")
+		}
+	default:
+		return segment{}, errSkip
+	}
+
+	switch proto {
+	case protoTCP:
+		if len(payload) < 20 {
+			return segment{}, errors.New("short TCP header")
+		}
+		offset := int(payload[12]>>4) * 4
+		if offset < 20 || len(payload) < offset {
+			return segment{}, errors.New("bad TCP header length")
+		}
+		s.proto = protoTCP
+		s.src = netip.AddrPortFrom(s.src.Addr(), binary.BigEndian.Uint16(payload[0:2]))
+		s.dst = netip.AddrPortFrom(s.dst.Addr(), binary.BigEndian.Uint16(payload[2:4]))
+		s.seq = binary.BigEndian.Uint32(payload[4:8])
+		s.flags = payload[13]
+		s.payload = payload[offset:]
+	case protoUDP:
+		if len(payload) < 8 {
+			return segment{}, errors.New("short UDP header")
+		}
+		length := int(binary.BigEndian.Uint16(payload[4:6]))
+		s.proto = protoUDP
+		s.src = netip.AddrPortFrom(s.src.Addr(), binary.BigEndian.Uint16(payload[0:2]))
+		s.dst = netip.AddrPortFrom(s.dst.Addr(), binary.BigEndian.Uint16(payload[2:4]))
+		s.payload = payload[8:]
+		if length >= 8 && length-8 < len(s.payload) {
+			s.payload = s.payload[:length-8]
+		}
+	default:
+		return segment{}, errSkip
+	}
+	return s, nil
+}
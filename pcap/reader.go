@@ -0,0 +1,295 @@
+// Package pcap reads packet captures of the original servers and turns
+// the GameSpy, FESL, Theater and QR2 traffic in them into captures the
+// replay harness understands, plus annotated transcripts.
+//
+//	flows, err := pcap.Import(file, pcap.Config{})
+//	for _, flow := range flows {
+//		pcap.WriteTranscript(os.Stdout, flow)
+//	}
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Link types of the captures we can read
+const (
+	LinkNull      uint32 = 0
+	LinkEthernet  uint32 = 1
+	LinkRaw       uint32 = 101
+	LinkLoop      uint32 = 108
+	LinkLinuxSLL  uint32 = 113
+	LinkIPv4      uint32 = 228
+	LinkIPv6      uint32 = 229
+	LinkLinuxSLL2 uint32 = 276
+)
+
+// ErrInvalidFile is returned for files which are neither libpcap nor
+// pcapng
+var ErrInvalidFile = errors.New("not a pcap or pcapng file")
+
+// maxPacket guards against allocating whatever a broken length claims
+const maxPacket = 1 << 18
+
+// Packet is a single captured packet
+type Packet struct {
+	Time     time.Time
+	LinkType uint32
+	Data     []byte
+	// Truncated is set if the capture holds less than was on the wire
+	Truncated bool
+}
+
+// Reader reads the packets of a libpcap or pcapng file
+type Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	ng    bool
+
+	// libpcap
+	linkType uint32
+	nanos    bool
+
+	// pcapng, per interface
+	interfaces []ngInterface
+}
+
+type ngInterface struct {
+	linkType uint32
+	// resolution is the length of a timestamp unit
+	resolution time.Duration
+}
+
+// NewReader reads the file header of r
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+
+	magic, err := reader.r.Peek(4)
+	if err != nil {
+		return nil, ErrInvalidFile
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(magic) == 0x0A0D0D0A:
+		reader.ng = true
+		// The byte order is read with the section header
+		return reader, nil
+	case binary.LittleEndian.Uint32(magic) == 0xA1B2C3D4:
+		reader.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(magic) == 0xA1B2C3D4:
+		reader.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(magic) == 0xA1B23C4D:
+		reader.order, reader.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(magic) == 0xA1B23C4D:
+		reader.order, reader.nanos = binary.BigEndian, true
+	default:
+		return nil, ErrInvalidFile
+	}
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		return nil, ErrInvalidFile
+	}
+	// The upper bits carry the FCS length on some systems
+	reader.linkType = reader.order.Uint32(header[20:24]) & 0x0FFFFFFF
+	return reader, nil
+}
+
+// Next returns the next packet, or io.EOF after the last one
+func (reader *Reader) Next() (Packet, error) {
+	if reader.ng {
+		return reader.nextNG()
+	}
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Packet{}, fmt.Errorf("%w: truncated packet header", ErrInvalidFile)
+		}
+		return Packet{}, err
+	}
+
+	seconds := reader.order.Uint32(header[0:4])
+	fraction := reader.order.Uint32(header[4:8])
+	captured := reader.order.Uint32(header[8:12])
+	original := reader.order.Uint32(header[12:16])
+	if captured > maxPacket {
+		return Packet{}, fmt.Errorf("%w: packet of %d bytes", ErrInvalidFile, captured)
+	}
+
+	data := make([]byte, captured)
+	if _, err := io.ReadFull(reader.r, data); err != nil {
+		return Packet{}, fmt.Errorf("%w: truncated packet", ErrInvalidFile)
+	}
+
+	nanos := int64(fraction)
+	if !reader.nanos {
+		nanos *= 1000
+	}
+	return Packet{
+		Time:      time.Unix(int64(seconds), nanos),
+		LinkType:  reader.linkType,
+		Data:      data,
+		Truncated: original > captured,
+	}, nil
+}
+
+// pcapng block types
+const (
+	ngSectionHeader   = 0x0A0D0D0A
+	ngInterfaceDesc   = 0x00000001
+	ngSimplePacket    = 0x00000003
+	ngEnhancedPacket  = 0x00000006
+	ngOptionTSResol   = 9
+	ngOptionEndOfOpts = 0
+)
+
+func (reader *Reader) nextNG() (Packet, error) {
+	for {
+		blockType, body, err := reader.readBlock()
+		if err != nil {
+			return Packet{}, err
+		}
+
+		switch blockType {
+		case ngSectionHeader:
+			// Every section starts over with its own interfaces
+			reader.interfaces = nil
+		case ngInterfaceDesc:
+			if len(body) < 8 {
+				return Packet{}, fmt.Errorf("%w: short interface block", ErrInvalidFile)
+			}
+			iface := ngInterface{
+				linkType:   uint32(reader.order.Uint16(body[0:2])),
+				resolution: time.Microsecond,
+			}
+			reader.parseInterfaceOptions(&iface, body[8:])
+			reader.interfaces = append(reader.interfaces, iface)
+		case ngEnhancedPacket:
+			if len(body) < 20 {
+				return Packet{}, fmt.Errorf("%w: short packet block", ErrInvalidFile)
+			}
+			id := reader.order.Uint32(body[0:4])
+			if int(id) >= len(reader.interfaces) {
+				return Packet{}, fmt.Errorf("%w: packet of unknown interface %d", ErrInvalidFile, id)
+			}
+			iface := reader.interfaces[id]
+
+			timestamp := uint64(reader.order.Uint32(body[4:8]))<<32 | uint64(reader.order.Uint32(body[8:12]))
+			captured := reader.order.Uint32(body[12:16])
+			original := reader.order.Uint32(body[16:20])
+			if uint64(captured) > uint64(len(body)-20) {
+				return Packet{}, fmt.Errorf("%w: packet longer than its block", ErrInvalidFile)
+			}
+
+			return Packet{
+				Time:      ngTime(timestamp, iface.resolution),
+				LinkType:  iface.linkType,
+				Data:      body[20 : 20+captured],
+				Truncated: original > captured,
+			}, nil
+		case ngSimplePacket:
+			if len(body) < 4 || len(reader.interfaces) == 0 {
+				return Packet{}, fmt.Errorf("%w: short packet block", ErrInvalidFile)
+			}
+			original := reader.order.Uint32(body[0:4])
+			data := body[4:]
+			if uint32(len(data)) > original {
+				data = data[:original]
+			}
+			return Packet{
+				LinkType:  reader.interfaces[0].linkType,
+				Data:      data,
+				Truncated: original > uint32(len(data)),
+			}, nil
+		}
+		// Everything else, like statistics or name resolution, is skipped
+	}
+}
+
+// readBlock reads a whole pcapng block and returns its type and body
+func (reader *Reader) readBlock() (uint32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("%w: truncated block", ErrInvalidFile)
+		}
+		return 0, nil, err
+	}
+
+	if binary.BigEndian.Uint32(header[0:4]) == ngSectionHeader {
+		// The byte order magic follows the length, which can't be read
+		// without it
+		magic, err := reader.r.Peek(4)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: truncated section header", ErrInvalidFile)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == 0x1A2B3C4D:
+			reader.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == 0x1A2B3C4D:
+			reader.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("%w: unknown byte order", ErrInvalidFile)
+		}
+	}
+	if reader.order == nil {
+		return 0, nil, ErrInvalidFile
+	}
+
+	blockType := reader.order.Uint32(header[0:4])
+	length := reader.order.Uint32(header[4:8])
+	if length < 12 || length%4 != 0 || length > maxPacket+64 {
+		return 0, nil, fmt.Errorf("%w: block of %d bytes", ErrInvalidFile, length)
+	}
+
+	// The body is followed by the length again
+	rest := make([]byte, length-8)
+	if _, err := io.ReadFull(reader.r, rest); err != nil {
+		return 0, nil, fmt.Errorf("%w: truncated block", ErrInvalidFile)
+	}
+	return blockType, rest[:len(rest)-4], nil
+}
+
+func (reader *Reader) parseInterfaceOptions(iface *ngInterface, options []byte) {
+	for len(options) >= 4 {
+		code := reader.order.Uint16(options[0:2])
+		length := int(reader.order.Uint16(options[2:4]))
+		if code == ngOptionEndOfOpts || 4+length > len(options) {
+			return
+		}
+
+		if code == ngOptionTSResol && length >= 1 {
+			resolution := options[4]
+			if resolution&0x80 == 0 {
+				unit := time.Second
+				for i := byte(0); i < resolution&0x7F && unit > 1; i++ {
+					unit /= 10
+				}
+				iface.resolution = unit
+			} else {
+				iface.resolution = time.Second >> (resolution & 0x7F)
+			}
+			if iface.resolution <= 0 {
+				iface.resolution = time.Nanosecond
+			}
+		}
+
+		// Options are padded to 32 bits
+		next := 4 + (length+3)&^3
+		if next > len(options) {
+			return
+		}
+		options = options[next:]
+	}
+}
+
+func ngTime(timestamp uint64, resolution time.Duration) time.Time {
+	perSecond := uint64(time.Second / resolution)
+	return time.Unix(int64(timestamp/perSecond), int64(timestamp%perSecond)*int64(resolution))
+}
@@ -0,0 +1,136 @@
+package pcap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HeroesAwaken/GoAwaken/GameSpy"
+)
+
+// WriteTranscript writes flow as readable text, every frame decoded with
+// its keys, and the notes in between:
+//
+//	# theater (fesl) 192.0.2.10:50123 -> 203.0.113.5:18275, 2026-10-19T12:00:00Z
+//	+0.000s client  fsys 0xc0000001
+//	                TXN=Hello
+//	                clientString=bfheroes-pc
+//	+0.052s server  fsys 0xc0000001
+//	                TXN=Hello
+//	+1.310s note    in: 120 bytes missing from the capture
+func WriteTranscript(w io.Writer, flow *Flow) error {
+	capture := flow.Capture
+
+	kind := "tcp"
+	if flow.Datagram {
+		kind = "udp"
+	}
+	_, err := fmt.Fprintf(w, "# %s (%s/%s) %v -> %v, %s\n", flow.Port.Name, capture.Protocol, kind,
+		flow.Client, flow.Server, capture.Start.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+
+	framer, err := GameSpy.FramerFor(capture.Protocol)
+	if err != nil {
+		return err
+	}
+
+	notes := flow.Notes
+	for _, record := range capture.Records {
+		for len(notes) > 0 && notes[0].Time.Before(record.Time) {
+			if err := writeNote(w, capture.Start, notes[0]); err != nil {
+				return err
+			}
+			notes = notes[1:]
+		}
+
+		who := "client"
+		if record.Direction == GameSpy.Outbound {
+			who = "server"
+		}
+		lines := FormatFrame(framer, record.Frame)
+		if _, err := fmt.Fprintf(w, "%-8s %-7s %s\n", offset(capture.Start, record.Time), who, lines[0]); err != nil {
+			return err
+		}
+		for _, line := range lines[1:] {
+			if _, err := fmt.Fprintf(w, "%16s %s\n", "", line); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, note := range notes {
+		if err := writeNote(w, capture.Start, note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeNote(w io.Writer, start time.Time, note Note) error {
+	_, err := fmt.Fprintf(w, "%-8s %-7s %s\n", offset(start, note.Time), "note", note.Text)
+	return err
+}
+
+func offset(start time.Time, t time.Time) string {
+	return fmt.Sprintf("+%.3fs", t.Sub(start).Seconds())
+}
+
+// FormatFrame decodes frame with framer and returns it as lines of
+// text. The first line names the message, the others hold its keys.
+// Frames which don't decode are hex dumped.
+func FormatFrame(framer GameSpy.Framer, frame []byte) []string {
+	message, err := framer.Decode(frame)
+	if err != nil || message == nil {
+		reason := "empty frame"
+		if err != nil {
+			reason = err.Error()
+		}
+		lines := []string{fmt.Sprintf("undecodable, %s", reason)}
+		return append(lines, strings.Split(strings.TrimRight(hex.Dump(frame), "\n"), "\n")...)
+	}
+
+	var lines []string
+	var keys map[string]string
+	switch message := message.(type) {
+	case *GameSpy.CommandFESL:
+		lines = append(lines, fmt.Sprintf("%s %#08x", message.Query, message.PayloadID))
+		keys = message.Message
+	case *GameSpy.Command:
+		lines = append(lines, message.Query)
+		keys = message.Message
+	}
+
+	return append(lines, FormatKeys(keys)...)
+}
+
+// FormatKeys returns the keys of a message as key=value lines with the
+// values escaped like Go strings. TXN comes first, the rest is sorted.
+// The internal __query is left out.
+func FormatKeys(keys map[string]string) []string {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		if name != "__query" {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "TXN" || names[j] == "TXN" {
+			return names[i] == "TXN"
+		}
+		return names[i] < names[j]
+	})
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		// Escaped, but without quotes around
+		value := strconv.Quote(keys[name])
+		lines = append(lines, name+"="+value[1:len(value)-1])
+	}
+	return lines
+}
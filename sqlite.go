@@ -0,0 +1,7 @@
+//go:build cgo
+
+package main
+
+// The SQLite driver behind -sqlite takes cgo, builds without it run on
+// MySQL only
+import _ "github.com/mattn/go-sqlite3"
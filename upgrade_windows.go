@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// upgradeSignals trigger a zero-downtime upgrade. Windows has no spare
+// signal, use the admin interface instead.
+var upgradeSignals = []os.Signal{}